@@ -4,6 +4,7 @@ package dnsresolver
 // import
 import (
 	"crypto/tls"
+	"iter"
 	"net"
 	"net/netip"
 	"time"
@@ -59,6 +60,8 @@ type Resolver struct {
 	Name string
 	// Server server_ip:port
 	Server string
+	// Servers fallback server_ip:port list, used in order while Server is marked down
+	Servers []string
 	// NoIP4 no dns.srv conn attempt via ip4
 	NoIP4 bool
 	// NoIP6 no dns.srv conn attempt via ip6
@@ -75,14 +78,76 @@ type Resolver struct {
 	// when custom tls.Config and TLSKeyPin are enabled a tlsconfig.VerifyConnection
 	// function is required (examples, see resolver.go or use tlsConfigPin(TLSKeyPin))
 	TLSConfig *tls.Config
+	// TSIG key signs all queries and transfers, responses without valid signature fail (optional)
+	TSIG *TSIGKey
+	// SIG0 key signs dynamic updates when no TSIG key is set, responses are verified only with
+	// SIG0Key.ServerKey set (optional, see LoadSIG0Key)
+	SIG0 *SIG0Key
+	// DNSSEC requests validation (DO and AD bit) from a validating upstream, secure lookups
+	// like LookupTLSA then require authenticated answers
+	DNSSEC bool
 	// Timeout ...
 	Timeout time.Duration
+	// Sources lookup order, eg. SourcesSystem (files, dns), nil queries Server only
+	Sources []Source
+	// Cache for dns answers (optional, see NewCache)
+	Cache *Cache
+	// Health tracks the server state (optional, default: DefaultHealth)
+	Health *Health
+	// Metrics receives query, latency and failure events (optional, see PrometheusMetrics)
+	Metrics Metrics
+	// Dnstap logs every query and response (optional, see NewDnstap)
+	Dnstap *Dnstap
+	// Policy applies response policy zones to all answers (optional, see LoadPolicyZone)
+	Policy *Policy
+	// TracePort of the name servers asked by Trace (0: 53)
+	TracePort uint16
+	// pool connection of an ExchangeSeq worker, reused between cache misses
+	pool *connPool
 }
 
 // Answer ...
 type Answer struct {
 	Raw     map[uint16]string
 	Summary map[uint16]string
+	// Policy hits per type, see Resolver.Policy
+	Policy map[uint16]*PolicyHit
+	// Records answer section per type, CNAMEs included, see Answer.WriteZone
+	Records map[uint16][]dns.RR
+}
+
+// Result is the outcome of a single record type, see ExchangeSeq
+type Result struct {
+	Query string
+	Type  uint16
+	// Msg complete response, empty on transport failures
+	Msg *dns.Msg
+	// Answer records of Type, empty for failed queries and answers without data
+	Answer []dns.RR
+	// Server that answered, empty for answers of local Sources, Proto used, local for those
+	Server string
+	Proto  string
+	RTT    time.Duration
+	// Policy hit, see Resolver.Policy
+	Policy *PolicyHit
+	// Err reason of failed results, as yielded by ExchangeSeq
+	Err error
+}
+
+// RcodeError is returned for answers with a non-success response code
+type RcodeError struct {
+	Query  string
+	Type   uint16
+	Rcode  int
+	Server string
+	Proto  string
+	// Cached answer, served from the (negative) cache
+	Cached bool
+}
+
+// Error ...
+func (e *RcodeError) Error() string {
+	return dns.TypeToString[e.Type] + _errLookup + e.Server + _sep + e.Proto + _sep + dns.RcodeToString[e.Rcode]
 }
 
 // TypeAll holds all DNS Types (A, AAA, CNAME, MX ...)
@@ -108,15 +173,30 @@ func ResolverResolvConf() *Resolver {
 	if err != nil {
 		return &Resolver{}
 	}
+	if len(c.Servers) == 0 {
+		return &Resolver{}
+	}
+	var servers []string
+	for _, server := range c.Servers[1:] {
+		servers = append(servers, net.JoinHostPort(server, c.Port))
+	}
 	return &Resolver{
 		Name:    _resolvconf,
 		Server:  net.JoinHostPort(c.Servers[0], c.Port),
+		Servers: servers,
 		Timeout: 8 * time.Second,
 	}
 }
 
-// ResolverAuto ...
+// ResolverAuto returns the first reachable resolver, looking up via SourcesSystem
 func ResolverAuto() *Resolver {
+	resolver := resolverAuto()
+	resolver.Sources = SourcesSystem
+	return resolver
+}
+
+// resolverAuto ...
+func resolverAuto() *Resolver {
 	switch {
 	case isFile(_resolvconf) && ResolverResolvConf().IsReachable():
 		return ResolverResolvConf()
@@ -169,24 +249,27 @@ func (r *Resolver) Exchange(query string, raw, summary bool, rTypes []uint16) (*
 	return r.exchangeAll(query, raw, summary, rTypes)
 }
 
+// ExchangeSeq queries the types concurrently and yields the result of every type as soon as it
+// arrives (completion order, not request order), empty answers included, failed ones with the
+// error (RcodeError, TSIGError, transport failures), Sources, Cache and Servers apply as for Lookup
+func (r *Resolver) ExchangeSeq(query string, rTypes []uint16) iter.Seq2[Result, error] {
+	return r.exchangeSeq(query, rTypes)
+}
+
 // IsReachable ...
 func (r *Resolver) IsReachable() bool {
 	err := r.IsFunctional()
 	return err == nil
 }
 
-// IsFunctional ...
+// IsFunctional reports if any server of the resolver is usable, see Health
 func (r *Resolver) IsFunctional() error {
-	if _, ok := resolverReachableMap.Load(r.Server); ok {
-		return nil
-	}
-	_, err := r.resolve(_ping, dns.TypeA)
-	if err != nil {
-		return err
-	}
-	if r.DoT {
-		resolverReachableMap.Store(r.Server, true)
+	var err error
+	h := r.health()
+	for _, server := range r.servers() {
+		if err = h.check(r, server); err == nil {
+			return nil
+		}
 	}
-	resolverReachableMap.Store(r.Server, false)
-	return nil
+	return err
 }
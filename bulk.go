@@ -0,0 +1,246 @@
+package dnsresolver
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_bulkWorkers = 16
+	_bulkRetries = 2
+	_bulkBackoff = 100 * time.Millisecond
+	_errBulk     = "[dnsinfo] [bulk] "
+)
+
+// Bulk resolves large query sets via a bounded worker pool, every worker keeps its own
+// connection open, Sources and Cache of the resolver are bypassed, Policy applies
+type Bulk struct {
+	// Workers concurrent queries, each with a pooled connection (0: 16)
+	Workers int
+	// QPS global query rate limit, retries included (0: unlimited)
+	QPS int
+	// Retries of queries failing with transport errors, SERVFAIL or REFUSED (0: 2, <0: none)
+	Retries int
+	// Backoff before the first retry, doubled on every further one (0: 100ms)
+	Backoff time.Duration
+	// Types queried for reader input lines without types (nil: A)
+	Types []uint16
+
+	r *Resolver
+}
+
+// BulkQuery ...
+type BulkQuery struct {
+	Name string
+	Type uint16
+}
+
+// BulkResult is the final outcome of a query, after all retries
+type BulkResult struct {
+	Query    BulkQuery
+	Msg      *dns.Msg
+	Server   string
+	Proto    string
+	RTT      time.Duration
+	Attempts int
+	Err      error
+}
+
+// NewBulk ...
+func NewBulk(r *Resolver) *Bulk {
+	return &Bulk{r: r}
+}
+
+// Run resolves all queries and streams the results in completion order, stopping the
+// iteration early cancels the outstanding queries
+func (b *Bulk) Run(queries iter.Seq[BulkQuery]) iter.Seq[BulkResult] {
+	return func(yield func(BulkResult) bool) {
+		jobs, results, done := make(chan BulkQuery), make(chan BulkResult), make(chan struct{})
+		defer close(done)
+		var tick <-chan time.Time
+		if b.QPS > 0 {
+			ticker := time.NewTicker(max(time.Second/time.Duration(b.QPS), time.Nanosecond)) // QPS above 1e9
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		go func() {
+			defer close(jobs)
+			for q := range queries {
+				select {
+				case jobs <- q:
+				case <-done:
+					return
+				}
+			}
+		}()
+		var bg sync.WaitGroup
+		workers := b.Workers
+		if workers <= 0 {
+			workers = _bulkWorkers
+		}
+		for range workers {
+			bg.Add(1)
+			go func() {
+				defer bg.Done()
+				b.worker(jobs, results, done, tick)
+			}()
+		}
+		go func() {
+			bg.Wait()
+			close(results)
+		}()
+		for res := range results {
+			if !yield(res) {
+				return
+			}
+		}
+	}
+}
+
+// RunReader resolves the names read from rd, one query per line: name [TYPE ...],
+// empty lines and # comments are skipped
+func (b *Bulk) RunReader(rd io.Reader) iter.Seq[BulkResult] {
+	return func(yield func(BulkResult) bool) {
+		scanner := bufio.NewScanner(rd)
+		for res := range b.Run(b.readQueries(scanner)) {
+			if !yield(res) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(BulkResult{Err: errors.New(_errBulk + err.Error())})
+		}
+	}
+}
+
+// readQueries ...
+func (b *Bulk) readQueries(scanner *bufio.Scanner) iter.Seq[BulkQuery] {
+	return func(yield func(BulkQuery) bool) {
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			types := b.Types
+			if len(types) == 0 {
+				types = []uint16{dns.TypeA}
+			}
+			if len(fields) > 1 {
+				types = types[:0:0]
+				for _, t := range fields[1:] {
+					types = append(types, dns.StringToType[strings.ToUpper(t)]) // unknown: TypeNone, reported per query
+				}
+			}
+			for _, t := range types {
+				if !yield(BulkQuery{Name: fields[0], Type: t}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// worker resolves jobs via its pooled connection, redialed after transport errors
+func (b *Bulk) worker(jobs <-chan BulkQuery, results chan<- BulkResult, done <-chan struct{}, tick <-chan time.Time) {
+	r := b.r
+	var conn *dns.Conn
+	var proto, server string
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	retries := b.Retries
+	switch {
+	case retries == 0:
+		retries = _bulkRetries
+	case retries < 0:
+		retries = 0
+	}
+	for q := range jobs {
+		res := BulkResult{Query: q}
+		if q.Type == dns.TypeNone {
+			res.Err = errors.New(_errBulk + q.Name + _sep + strings.TrimSuffix(_errUnsupportedType, ": "))
+		}
+		backoff := durationOr(b.Backoff, _bulkBackoff)
+		for attempt := 0; attempt <= retries && q.Type != dns.TypeNone; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(backoff):
+					backoff *= 2
+				case <-done:
+					return
+				}
+			}
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-done:
+					return
+				}
+			}
+			res.Attempts++
+			if conn == nil {
+				var err error
+				proto, server = r.proto(), r.server()
+				conn, err = r.dial(server, proto)
+				r.health().report(server, err)
+				if err != nil { // nothing of an earlier attempt stays
+					conn = nil
+					res.Msg, res.Server, res.Proto, res.RTT = nil, server, proto, 0
+					res.Err = errors.New(_errBulk + server + _sep + proto + _sep + err.Error())
+					continue
+				}
+			} else {
+				r.metrics().ConnReuse(server)
+			}
+			start := time.Now()
+			used := proto
+			rsp, _, err := r.applyPolicy(q.Name, q.Type, func() (rsp *dns.Msg, err error) {
+				rsp, used, err = r.resolveViaConn(conn, proto, server, q.Name, q.Type)
+				return rsp, err
+			})
+			res.Msg, res.Server, res.Proto, res.RTT, res.Err = rsp, server, used, time.Since(start), err
+			if !isUpstreamFailure(err) {
+				break
+			}
+			var rcodeErr *RcodeError
+			if !errors.As(err, &rcodeErr) { // transport failure, redial
+				r.health().report(server, err)
+				conn.Close()
+				conn = nil
+			}
+		}
+		select {
+		case results <- res:
+		case <-done:
+			return
+		}
+	}
+}
+
+// String returns the matching answer records, one per line, or the error
+func (res BulkResult) String() string {
+	prefix := res.Query.Name + _sep + dns.TypeToString[res.Query.Type] + _sep
+	if res.Err != nil {
+		return prefix + _rfail + res.Err.Error() + _linefeed
+	}
+	var s strings.Builder
+	for _, rr := range res.Msg.Answer {
+		if rr.Header().Rrtype == res.Query.Type {
+			s.WriteString(rr.String() + _linefeed)
+		}
+	}
+	if s.Len() == 0 {
+		return prefix + _noAnswer + _linefeed
+	}
+	return s.String()
+}
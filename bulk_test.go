@@ -0,0 +1,180 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+func TestBulkRun(t *testing.T) {
+	var zone strings.Builder
+	zone.WriteString(_testZone)
+	for i := range 50 {
+		fmt.Fprintf(&zone, "h%d IN A 192.0.2.%d\n", i, i)
+	}
+	s := newServer(t, zone.String())
+	b := dnsresolver.NewBulk(s.Resolver())
+	b.Workers = 4
+	queries := func(yield func(dnsresolver.BulkQuery) bool) {
+		for i := range 50 {
+			if !yield(dnsresolver.BulkQuery{Name: fmt.Sprintf("h%d.example.com", i), Type: dns.TypeA}) {
+				return
+			}
+		}
+	}
+	n := 0
+	for res := range b.Run(queries) {
+		if res.Err != nil || len(res.Msg.Answer) != 1 || res.Attempts != 1 {
+			t.Errorf("%s: err %v, answers %d, attempts %d", res.Query.Name, res.Err, len(res.Msg.Answer), res.Attempts)
+		}
+		n++
+	}
+	if n != 50 {
+		t.Fatalf("results: got %d, want 50", n)
+	}
+}
+
+func TestBulkRunReader(t *testing.T) {
+	s := newServer(t, _testZone)
+	b := dnsresolver.NewBulk(s.Resolver())
+	input := "# comment\n\nwww.example.com A AAAA\nmail.example.com\nnx.example.com\nwww.example.com BOGUS\n"
+	got := map[string]error{}
+	for res := range b.RunReader(strings.NewReader(input)) {
+		got[res.Query.Name+" "+dns.TypeToString[res.Query.Type]] = res.Err
+	}
+	want := []string{"www.example.com A", "www.example.com AAAA", "mail.example.com A", "nx.example.com A", "www.example.com None"}
+	for _, k := range want {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing result %q", k)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("results: got %d, want %d", len(got), len(want))
+	}
+	for _, k := range want[:3] {
+		if got[k] != nil {
+			t.Errorf("%s: %v", k, got[k])
+		}
+	}
+	var rcodeErr *dnsresolver.RcodeError
+	if !errors.As(got["nx.example.com A"], &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError {
+		t.Errorf("nx.example.com: got %v, want NXDOMAIN", got["nx.example.com A"])
+	}
+	if got["www.example.com None"] == nil {
+		t.Error("unknown type: want error")
+	}
+}
+
+func TestBulkQPS(t *testing.T) {
+	s := newServer(t, _testZone)
+	for _, qps := range []int{20, 2_000_000_000, math.MaxInt} {
+		b := dnsresolver.NewBulk(s.Resolver())
+		b.QPS = qps
+		start, n := time.Now(), 0
+		for res := range b.RunReader(strings.NewReader(strings.Repeat("www.example.com\n", 5))) {
+			if res.Err != nil {
+				t.Errorf("qps %d: %v", qps, res.Err)
+			}
+			n++
+		}
+		if n != 5 {
+			t.Errorf("qps %d: got %d results, want 5", qps, n)
+		}
+		if elapsed := time.Since(start); qps == 20 && elapsed < 200*time.Millisecond {
+			t.Errorf("qps %d: 5 queries took %s, want rate limited", qps, elapsed)
+		}
+	}
+}
+
+func TestBulkRetry(t *testing.T) {
+	s := newServer(t, _testZone)
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	b := dnsresolver.NewBulk(s.Resolver())
+	b.Retries, b.Backoff = 2, time.Millisecond
+	for res := range b.Run(slices.Values([]dnsresolver.BulkQuery{{Name: "www.example.com", Type: dns.TypeA}, {Name: "nx.example.com", Type: dns.TypeA}})) {
+		want := 1
+		if res.Query.Name == "www.example.com" {
+			want = 3
+		}
+		if res.Attempts != want {
+			t.Errorf("%s: attempts %d, want %d", res.Query.Name, res.Attempts, want)
+		}
+	}
+}
+
+func TestBulkPolicyIsFinal(t *testing.T) {
+	s := newServer(t, _testZone)
+	z, err := dnsresolver.LoadPolicyZone(strings.NewReader("$TTL 60\n@ IN SOA . . 1 1 1 1 1\n*.example.com CNAME .\n"), "rpz.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.Resolver()
+	r.Policy = &dnsresolver.Policy{Zones: []*dnsresolver.PolicyZone{z}}
+	b := dnsresolver.NewBulk(r)
+	b.Backoff = time.Millisecond
+	var queries []dnsresolver.BulkQuery
+	for i := range 20 {
+		queries = append(queries, dnsresolver.BulkQuery{Name: fmt.Sprintf("h%d.example.com", i), Type: dns.TypeA})
+	}
+	for res := range b.Run(slices.Values(queries)) {
+		var policyErr *dnsresolver.PolicyError
+		if !errors.As(res.Err, &policyErr) || res.Attempts != 1 {
+			t.Errorf("%s: err %v, attempts %d, want policy error after 1 attempt", res.Query.Name, res.Err, res.Attempts)
+		}
+	}
+	if state := r.Health.State(s.Addr); !state.Up || state.Fails != 0 {
+		t.Errorf("health: %+v, want up without failures", state)
+	}
+}
+
+func TestBulkDoT(t *testing.T) {
+	s := newServer(t, _testZone)
+	b := dnsresolver.NewBulk(s.ResolverDoT())
+	b.Workers = 8
+	var queries []dnsresolver.BulkQuery
+	for range 32 {
+		queries = append(queries, dnsresolver.BulkQuery{Name: "www.example.com", Type: dns.TypeA})
+	}
+	for res := range b.Run(slices.Values(queries)) {
+		if res.Err != nil || res.Proto != "tcp-tls" {
+			t.Errorf("err %v, proto %s", res.Err, res.Proto)
+		}
+	}
+}
+
+func TestBulkFallbackProto(t *testing.T) {
+	s := newServer(t, _testZone)
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Truncate: true})
+	b := dnsresolver.NewBulk(s.Resolver())
+	for res := range b.Run(slices.Values([]dnsresolver.BulkQuery{{Name: "www.example.com", Type: dns.TypeA}, {Name: "mail.example.com", Type: dns.TypeA}})) {
+		want := "udp"
+		if res.Query.Name == "www.example.com" {
+			want = "tcp"
+		}
+		if res.Err != nil || res.Proto != want {
+			t.Errorf("%s: err %v, proto %s, want %s", res.Query.Name, res.Err, res.Proto, want)
+		}
+	}
+}
+
+func TestBulkDialRetry(t *testing.T) {
+	s := newServer(t, _testZone)
+	s.Close()
+	r := s.Resolver()
+	r.NoUDP = true // tcp dials fail on the closed listener
+	b := dnsresolver.NewBulk(r)
+	b.Retries, b.Backoff = 2, time.Millisecond
+	for res := range b.Run(slices.Values([]dnsresolver.BulkQuery{{Name: "www.example.com", Type: dns.TypeA}})) {
+		if res.Attempts != 3 || res.Err == nil || res.Msg != nil || res.Server != s.Addr || res.Proto != "tcp" {
+			t.Errorf("got %+v, want 3 failed dials to %s", res, s.Addr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package dnsresolver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_caaCritical  = 128
+	_caaIssue     = "issue"
+	_caaIssueWild = "issuewild"
+	_caaIodef     = "iodef"
+	_errCAA       = "[dnsinfo] [caa] "
+)
+
+// CAA is the relevant CAA RRset of a domain, RFC 8659 section 3
+type CAA struct {
+	// Domain the RRset was found at, the queried name or one of its parents
+	Domain string
+	// Properties empty when no CAA RRset exists up to the top level domain
+	Properties []CAAProperty
+}
+
+// CAAProperty is a parsed CAA record
+type CAAProperty struct {
+	Critical bool
+	// Tag issue, issuewild, iodef or any other (lower case) property tag
+	Tag string
+	// Issuer domain of issue and issuewild, empty for ";" (no issuance)
+	Issuer string
+	// Params key=value parameters of issue and issuewild, eg. accounturi, validationmethods
+	Params map[string]string
+	// Value raw property value, the report url for iodef
+	Value string
+}
+
+// LookupCAA climbs from domain towards the top level domain and returns the first non
+// empty CAA RRset, CNAMEs are followed by the upstream
+func (r *Resolver) LookupCAA(domain string) (*CAA, error) {
+	name := strings.TrimPrefix(dns.Fqdn(domain), "*.")
+	for {
+		rsp, err := r.resolve(name, dns.TypeCAA)
+		var rcodeErr *RcodeError
+		if err != nil && !(errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError) {
+			return nil, errors.New(_errCAA + name + _sep + err.Error())
+		}
+		caa := &CAA{Domain: name}
+		for _, rr := range rsp.Answer {
+			if v, ok := rr.(*dns.CAA); ok {
+				caa.Properties = append(caa.Properties, parseCAA(v))
+			}
+		}
+		if len(caa.Properties) > 0 {
+			return caa, nil
+		}
+		off, end := dns.NextLabel(name, 0)
+		if end || name[off:] == _dot {
+			return &CAA{Domain: name}, nil
+		}
+		name = name[off:]
+	}
+}
+
+// IsIssuanceAllowed reports whether the CA caIdentifier (eg. letsencrypt.org) may issue a
+// certificate for domain, a wildcard certificate when wildcard is set
+func (r *Resolver) IsIssuanceAllowed(domain, caIdentifier string, wildcard bool) (bool, error) {
+	caa, err := r.LookupCAA(domain)
+	if err != nil {
+		return false, err
+	}
+	return caa.Allows(caIdentifier, wildcard || strings.HasPrefix(domain, "*.")), nil
+}
+
+// Allows evaluates the RRset for caIdentifier, RFC 8659 section 4.2 and 4.3
+func (c *CAA) Allows(caIdentifier string, wildcard bool) bool {
+	var issue, issueWild []CAAProperty
+	for _, p := range c.Properties {
+		switch p.Tag {
+		case _caaIssue:
+			issue = append(issue, p)
+		case _caaIssueWild:
+			issueWild = append(issueWild, p)
+		case _caaIodef:
+		default:
+			if p.Critical {
+				return false // unknown critical property
+			}
+		}
+	}
+	relevant := issue
+	if wildcard && len(issueWild) > 0 {
+		relevant = issueWild
+	}
+	if len(relevant) == 0 {
+		return true
+	}
+	caIdentifier = strings.TrimSuffix(caIdentifier, _dot)
+	for _, p := range relevant {
+		if p.Issuer != _empty && strings.EqualFold(p.Issuer, caIdentifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCAA ...
+func parseCAA(rr *dns.CAA) CAAProperty {
+	p := CAAProperty{Critical: rr.Flag&_caaCritical != 0, Tag: strings.ToLower(rr.Tag), Value: rr.Value}
+	if p.Tag != _caaIssue && p.Tag != _caaIssueWild {
+		return p
+	}
+	issuer, params, _ := strings.Cut(rr.Value, ";")
+	p.Issuer = strings.TrimSuffix(strings.TrimSpace(issuer), _dot)
+	for _, param := range strings.Split(params, ";") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key != _empty {
+			if p.Params == nil {
+				p.Params = make(map[string]string)
+			}
+			p.Params[key] = value
+		}
+	}
+	return p
+}
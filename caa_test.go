@@ -0,0 +1,126 @@
+package dnsresolver_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _caaZone ...
+const _caaZone = `@		IN CAA	0 issue "ca.example.net; accounturi=https://ca.example.net/acct/1; validationmethods=dns-01"
+@		IN CAA	0 iodef "mailto:security@example.com"
+wild		IN CAA	0 issue ";"
+wild		IN CAA	0 issuewild "wildca.example.net."
+deny		IN CAA	0 issue ";"
+crit		IN CAA	0 issue "ca.example.net"
+crit		IN CAA	128 tbs "unknown"
+noncrit		IN CAA	0 issue "ca.example.net"
+noncrit		IN CAA	0 tbs "unknown"
+upper		IN CAA	0 ISSUE "CA.Example.Net"
+caalias		IN CNAME wild
+`
+
+func TestLookupCAA(t *testing.T) {
+	s := newServer(t, _testZone+_caaZone)
+	r := s.Resolver()
+	caa, err := r.LookupCAA("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caa.Domain != "example.com." || len(caa.Properties) != 2 {
+		t.Fatalf("unexpected rrset: %+v", caa)
+	}
+	for _, p := range caa.Properties {
+		switch p.Tag {
+		case "issue":
+			if p.Critical || p.Issuer != "ca.example.net" || p.Params["accounturi"] != "https://ca.example.net/acct/1" ||
+				p.Params["validationmethods"] != "dns-01" {
+				t.Errorf("unexpected issue property: %+v", p)
+			}
+		case "iodef":
+			if p.Issuer != "" || p.Params != nil || p.Value != "mailto:security@example.com" {
+				t.Errorf("unexpected iodef property: %+v", p)
+			}
+		default:
+			t.Errorf("unexpected property: %+v", p)
+		}
+	}
+	for name, want := range map[string]string{
+		"www.example.com":     "example.com.", // climbs to the parent
+		"a.b.www.example.com": "example.com.",
+		"*.wild.example.com":  "wild.example.com.", // wildcard prefix stripped
+		"caalias.example.com": "caalias.example.com.",
+		"upper.example.com.":  "upper.example.com.",
+	} {
+		if caa, err = r.LookupCAA(name); err != nil || caa.Domain != want || len(caa.Properties) == 0 {
+			t.Errorf("%s: got %+v %v, want rrset at %s", name, caa, err, want)
+		}
+	}
+	if caa, err = r.LookupCAA("upper.example.com"); err != nil || caa.Properties[0].Tag != "issue" || caa.Properties[0].Issuer != "CA.Example.Net" {
+		t.Errorf("tag case: got %+v %v", caa, err)
+	}
+}
+
+func TestLookupCAANone(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	caa, err := r.LookupCAA("www.example.com")
+	if err != nil || caa.Domain != "com." || len(caa.Properties) != 0 {
+		t.Fatalf("got %+v %v, want empty rrset at the top level domain", caa, err)
+	}
+	if ok, err := r.IsIssuanceAllowed("www.example.com", "any.example.net", false); err != nil || !ok {
+		t.Errorf("no rrset: got %v %v, want allowed", ok, err)
+	}
+	s.SetFault("example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	if _, err = r.LookupCAA("www.example.com"); err == nil {
+		t.Error("server failure: expected error")
+	}
+	if _, err = r.IsIssuanceAllowed("www.example.com", "any.example.net", false); err == nil {
+		t.Error("server failure: expected error")
+	}
+}
+
+func TestIsIssuanceAllowed(t *testing.T) {
+	s := newServer(t, _testZone+_caaZone)
+	r := s.Resolver()
+	for _, tc := range []struct {
+		domain   string
+		ca       string
+		wildcard bool
+		want     bool
+	}{
+		{"example.com", "ca.example.net", false, true},
+		{"example.com", "ca.example.net.", false, true},
+		{"example.com", "CA.EXAMPLE.NET", false, true},
+		{"example.com", "other.example.net", false, false},
+		{"www.example.com", "ca.example.net", false, true},
+		{"example.com", "ca.example.net", true, true}, // issue applies without issuewild
+		{"wild.example.com", "wildca.example.net", false, false},
+		{"wild.example.com", "wildca.example.net", true, true},
+		{"*.wild.example.com", "wildca.example.net", false, true},
+		{"wild.example.com", "ca.example.net", true, false},
+		{"deny.example.com", "ca.example.net", false, false},
+		{"deny.example.com", "ca.example.net", true, false},
+		{"crit.example.com", "ca.example.net", false, false}, // unknown critical property
+		{"noncrit.example.com", "ca.example.net", false, true},
+		{"upper.example.com", "ca.example.net", false, true},
+		{"caalias.example.com", "wildca.example.net", true, true},
+	} {
+		if ok, err := r.IsIssuanceAllowed(tc.domain, tc.ca, tc.wildcard); err != nil || ok != tc.want {
+			t.Errorf("%s %s wildcard %v: got %v %v, want %v", tc.domain, tc.ca, tc.wildcard, ok, err, tc.want)
+		}
+	}
+}
+
+func TestCAAAllows(t *testing.T) {
+	caa := &dnsresolver.CAA{Properties: []dnsresolver.CAAProperty{{Tag: "iodef", Value: "mailto:security@example.com"}}}
+	if !caa.Allows("ca.example.net", false) {
+		t.Error("iodef only: expected allowed")
+	}
+	caa.Properties = append(caa.Properties, dnsresolver.CAAProperty{Tag: "issuewild", Issuer: "ca.example.net"})
+	if !caa.Allows("other.example.net", false) || caa.Allows("other.example.net", true) || !caa.Allows("ca.example.net", true) {
+		t.Error("issuewild only: has to restrict wildcard issuance alone")
+	}
+}
@@ -0,0 +1,441 @@
+package dnsresolver
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_negativeTTLMax = 3 * time.Hour           // RFC 2308 section 5
+	_ttlMax         = 24 * time.Hour          // positive answers
+	_staleTTL       = 30 * time.Second        // RFC 8767 section 4
+	_staleMax       = 24 * time.Hour          // RFC 8767 suggests 1 - 3 days
+	_staleRecheck   = 30 * time.Second        // RFC 8767 failure recheck timer
+	_staleTimeout   = 1800 * time.Millisecond // RFC 8767 client response timer
+	_prefetchRatio  = 0.1                     // remaining ttl fraction
+	_prefetchHits   = 2                       // min hits per ttl to qualify
+)
+
+// cacheState ...
+type cacheState int
+
+// cache states
+const (
+	cacheMiss       cacheState = iota
+	cacheFresh                 // valid entry
+	cacheStale                 // expired, ask upstream first
+	cacheStaleServe            // expired, upstream failed recently
+)
+
+// Cache is a dns response cache, it can be shared between resolvers, entries are kept
+// apart per upstream server set and DNSSEC setting
+type Cache struct {
+	// NegativeTTL for negative answers without SOA in the authority section (0: do not cache)
+	NegativeTTL time.Duration
+	// NegativeTTLMax caps the SOA derived negative ttl (0: 3h, RFC 2308)
+	NegativeTTLMax time.Duration
+	// TTLMax caps the ttl of positive answers (0: 24h)
+	TTLMax time.Duration
+	// MaxEntries limits the cache size (0: unlimited)
+	MaxEntries int
+	// ServeStale answers from expired entries while the upstream fails, RFC 8767
+	ServeStale bool
+	// StaleTTL is the ttl set on stale answers (0: 30s)
+	StaleTTL time.Duration
+	// StaleMax is the max time after expiry an entry is served stale (0: 24h)
+	StaleMax time.Duration
+	// StaleRecheck is the time after a failed refresh stale answers are served without asking upstream (0: 30s)
+	StaleRecheck time.Duration
+	// StaleTimeout is the time to wait for the upstream before an expired entry is answered stale (0: 1.8s)
+	StaleTimeout time.Duration
+	// Prefetch refreshes popular entries in the background shortly before they expire
+	Prefetch bool
+
+	mu       sync.Mutex
+	entries  map[cacheKey]*cacheEntry
+	inflight map[cacheKey]*cacheQuery
+}
+
+// cacheKey ...
+type cacheKey struct {
+	name  string
+	rType uint16
+	scope string // upstream servers and DNSSEC setting of the resolver
+}
+
+// cacheEntry ...
+type cacheEntry struct {
+	msg        *dns.Msg
+	server     string
+	proto      string
+	stored     time.Time
+	expire     time.Time
+	hits       int
+	failed     time.Time
+	refreshing bool
+}
+
+// cacheQuery is the upstream query of an expired entry, shared by concurrent callers
+type cacheQuery struct {
+	done   chan struct{}
+	rsp    *dns.Msg
+	server string
+	proto  string
+	err    error
+}
+
+// NewCache ...
+func NewCache() *Cache {
+	return &Cache{NegativeTTLMax: _negativeTTLMax, TTLMax: _ttlMax}
+}
+
+// Len returns the number of cached entries, including expired ones
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Flush drops all entries
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// forget drops all entries of name, eg. after an update
+func (c *Cache) forget(name string) {
+	name = dns.CanonicalName(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k.name == name {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// resolveCached answers from cache, serves stale data and schedules prefetches
+func (r *Resolver) resolveCached(query string, rType uint16) (*dns.Msg, string, string, error) {
+	c, key := r.Cache, r.cacheKey(query, rType)
+	e, state, refresh := c.get(key)
+	r.metrics().Cache(state != cacheMiss)
+	if refresh {
+		go r.refreshCache(query, rType)
+	}
+	switch state {
+	case cacheFresh, cacheStaleServe:
+		return e.msg, e.server, e.proto, e.err(query, rType)
+	case cacheStale:
+		return r.resolveStale(query, rType, e)
+	}
+	rsp, server, proto, err := r.exchangeDNS(query, rType)
+	c.store(key, server, proto, rsp, err)
+	return rsp, server, proto, err
+}
+
+// resolveStale asks upstream for an expired entry, answers stale when the upstream fails or
+// does not answer within the client response timer, the late answer still feeds the cache,
+// concurrent callers share one upstream query
+func (r *Resolver) resolveStale(query string, rType uint16, e *cacheEntry) (*dns.Msg, string, string, error) {
+	key := r.cacheKey(query, rType)
+	q, start := r.Cache.join(key)
+	if start {
+		go func() {
+			q.rsp, q.server, q.proto, q.err = r.refreshCache(query, rType)
+			r.Cache.leave(key, q)
+		}()
+	}
+	timer := time.NewTimer(durationOr(r.Cache.StaleTimeout, _staleTimeout))
+	defer timer.Stop()
+	select {
+	case <-q.done:
+		if isUpstreamFailure(q.err) {
+			return e.msg, e.server, e.proto, e.err(query, rType)
+		}
+		if q.rsp != nil {
+			return q.rsp.Copy(), q.server, q.proto, q.err
+		}
+		return q.rsp, q.server, q.proto, q.err
+	case <-timer.C:
+		return e.msg, e.server, e.proto, e.err(query, rType)
+	}
+}
+
+// join returns the in-flight upstream query of an expired entry, start reports a new one
+func (c *Cache) join(key cacheKey) (q *cacheQuery, start bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if q, ok := c.inflight[key]; ok {
+		return q, false
+	}
+	if c.inflight == nil {
+		c.inflight = make(map[cacheKey]*cacheQuery)
+	}
+	q = &cacheQuery{done: make(chan struct{})}
+	c.inflight[key] = q
+	return q, true
+}
+
+// leave ends the in-flight upstream query of an expired entry, waiting callers get its result
+func (c *Cache) leave(key cacheKey, q *cacheQuery) {
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(q.done)
+}
+
+// refreshCache re-queries an entry, failures mark it for stale serving, the refresh state
+// is reset whatever the outcome
+func (r *Resolver) refreshCache(query string, rType uint16) (*dns.Msg, string, string, error) {
+	key := r.cacheKey(query, rType)
+	defer r.Cache.refreshed(key)
+	resolver := *r
+	resolver.pool = nil // runs in the background, the pooled connection belongs to the caller
+	rsp, server, proto, err := resolver.exchangeDNS(query, rType)
+	if isUpstreamFailure(err) {
+		r.Cache.failed(key)
+		return rsp, server, proto, err
+	}
+	r.Cache.store(key, server, proto, rsp, err)
+	return rsp, server, proto, err
+}
+
+// cacheKey returns the key of query, answers of differently configured resolvers sharing the
+// cache do not mix, eg. without AD bit for a DNSSEC resolver
+func (r *Resolver) cacheKey(query string, rType uint16) cacheKey {
+	scope := strings.Join(r.servers(), _sep)
+	if r.DNSSEC {
+		scope += _sep + "dnssec"
+	}
+	return cacheKey{name: dns.CanonicalName(query), rType: rType, scope: scope}
+}
+
+// get returns a copy of the entry with aged ttls, refresh reports a due background refresh
+func (c *Cache) get(key cacheKey) (e *cacheEntry, state cacheState, refresh bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, cacheMiss, false
+	}
+	if now.After(e.expire) {
+		if !c.ServeStale || now.After(e.expire.Add(durationOr(c.StaleMax, _staleMax))) {
+			delete(c.entries, key)
+			return nil, cacheMiss, false
+		}
+		stale := &cacheEntry{msg: e.stale(durationOr(c.StaleTTL, _staleTTL)), server: e.server, proto: e.proto}
+		if now.Sub(e.failed) > durationOr(c.StaleRecheck, _staleRecheck) {
+			return stale, cacheStale, false
+		}
+		// upstream failed recently, answer stale and refresh in background
+		if !e.refreshing {
+			e.refreshing, refresh = true, true
+		}
+		return stale, cacheStaleServe, refresh
+	}
+	e.hits++
+	if c.Prefetch && !e.refreshing && e.hits >= _prefetchHits {
+		ttl := e.expire.Sub(e.stored)
+		if e.expire.Sub(now) < time.Duration(float64(ttl)*_prefetchRatio) {
+			e.refreshing, refresh = true, true
+		}
+	}
+	return &cacheEntry{msg: e.aged(now), server: e.server, proto: e.proto}, cacheFresh, refresh
+}
+
+// failed records a failed upstream refresh
+func (c *Cache) failed(key cacheKey) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		e.failed = time.Now()
+	}
+	c.mu.Unlock()
+}
+
+// refreshed ends a background refresh, also for answers that are not cached (eg. NOTIMP)
+func (c *Cache) refreshed(key cacheKey) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		e.refreshing = false
+	}
+	c.mu.Unlock()
+}
+
+// store caches positive answers with their min ttl and negative ones per RFC 2308
+func (c *Cache) store(key cacheKey, server, proto string, msg *dns.Msg, err error) {
+	var rcodeErr *RcodeError
+	switch {
+	case err == nil:
+	case errors.As(err, &rcodeErr):
+		server, proto = rcodeErr.Server, rcodeErr.Proto
+	default:
+		return
+	}
+	if isNegative(msg, key.rType) {
+		c.setNegative(key, server, proto, msg)
+		return
+	}
+	if msg.Rcode != dns.RcodeSuccess || msg.Truncated {
+		return
+	}
+	ttl := durationOr(c.TTLMax, _ttlMax)
+	for _, rr := range msg.Answer {
+		if t := time.Duration(rr.Header().Ttl) * time.Second; t < ttl {
+			ttl = t
+		}
+	}
+	c.set(key, server, proto, msg, ttl)
+}
+
+// setNegative caches NXDOMAIN and NODATA responses, RFC 2308
+func (c *Cache) setNegative(key cacheKey, server, proto string, msg *dns.Msg) {
+	ttl, ok := negativeTTL(msg)
+	if !ok {
+		ttl = c.NegativeTTL
+	}
+	if ttlMax := durationOr(c.NegativeTTLMax, _negativeTTLMax); ttl > ttlMax {
+		ttl = ttlMax
+	}
+	c.set(key, server, proto, msg, ttl)
+}
+
+// set ...
+func (c *Cache) set(key cacheKey, server, proto string, msg *dns.Msg, ttl time.Duration) {
+	if ttl <= 0 || len(msg.Question) == 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[cacheKey]*cacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && c.MaxEntries > 0 && len(c.entries) >= c.MaxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cacheEntry{msg: msg.Copy(), server: server, proto: proto, stored: now, expire: now.Add(ttl)}
+}
+
+// evictLocked drops expired entries, or a random one if none expired
+func (c *Cache) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if now.After(e.expire) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < c.MaxEntries {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+// err recreates the error the original answer was returned with
+func (e *cacheEntry) err(query string, rType uint16) error {
+	if e.msg.Rcode != dns.RcodeSuccess {
+		return rcodeError(query, rType, e.msg.Rcode, e.server, e.proto, true)
+	}
+	return nil
+}
+
+// aged returns a copy of the cached message, ttls reduced by the time spent in cache
+func (e *cacheEntry) aged(now time.Time) *dns.Msg {
+	age := uint32(now.Sub(e.stored) / time.Second)
+	return e.withTTL(func(ttl uint32) uint32 {
+		if ttl > age {
+			return ttl - age
+		}
+		return 0
+	})
+}
+
+// stale returns a copy of the cached message, ttls capped to the stale ttl
+func (e *cacheEntry) stale(staleTTL time.Duration) *dns.Msg {
+	staleCap := uint32(staleTTL / time.Second)
+	return e.withTTL(func(ttl uint32) uint32 {
+		if ttl < staleCap {
+			return ttl
+		}
+		return staleCap
+	})
+}
+
+// withTTL ...
+func (e *cacheEntry) withTTL(fn func(uint32) uint32) *dns.Msg {
+	msg := e.msg.Copy()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			hdr.Ttl = fn(hdr.Ttl)
+		}
+	}
+	return msg
+}
+
+// isUpstreamFailure reports errors worth answering stale for or retrying: network errors, SERVFAIL,
+// REFUSED, policy hits, TSIG failures and all other rcodes are final answers
+func isUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var policyErr *PolicyError
+	var tsigErr *TSIGError
+	if errors.As(err, &policyErr) || errors.As(err, &tsigErr) {
+		return false
+	}
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) {
+		return rcodeErr.Rcode == dns.RcodeServerFailure || rcodeErr.Rcode == dns.RcodeRefused
+	}
+	return true
+}
+
+// isNegative reports NXDOMAIN or NODATA (no record of the requested type)
+func isNegative(msg *dns.Msg, rType uint16) bool {
+	switch msg.Rcode {
+	case dns.RcodeNameError:
+		return true
+	case dns.RcodeSuccess:
+		for _, rr := range msg.Answer {
+			if rr.Header().Rrtype == rType {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// negativeTTL is min(SOA ttl, SOA minimum) from the authority section, RFC 2308 section 5
+func negativeTTL(msg *dns.Msg) (time.Duration, bool) {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return time.Duration(ttl) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// durationOr ...
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,267 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// cachedResolver ...
+func cachedResolver(s *dnsresolvertest.Server, c *dnsresolver.Cache) *dnsresolver.Resolver {
+	r := s.Resolver()
+	r.Cache = c
+	return r
+}
+
+func TestNegativeCache(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := cachedResolver(s, dnsresolver.NewCache())
+	for i, q := range []struct {
+		name  string
+		rType uint16
+	}{{"nx.example.com", dns.TypeA}, {"www.example.com", dns.TypeMX}} {
+		for round := range 2 {
+			_, err := r.Lookup(q.name, q.rType)
+			if err == nil {
+				t.Fatalf("%s: want error", q.name)
+			}
+			var rcodeErr *dnsresolver.RcodeError
+			if q.rType == dns.TypeA && (!errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError || rcodeErr.Cached != (round == 1)) {
+				t.Errorf("%s round %d: got %v (%+v)", q.name, round, err, rcodeErr)
+			}
+		}
+		if s.Queries() != i+1 {
+			t.Errorf("%s: queries %d, want %d", q.name, s.Queries(), i+1)
+		}
+	}
+	if r.Cache.Len() != 2 {
+		t.Errorf("entries %d, want 2", r.Cache.Len())
+	}
+}
+
+func TestNegativeCacheTTL(t *testing.T) {
+	s := newServer(t, _testZone)
+	c := dnsresolver.NewCache()
+	c.NegativeTTLMax = time.Nanosecond
+	r := cachedResolver(s, c)
+	r.Lookup("nx.example.com", dns.TypeA)
+	time.Sleep(time.Millisecond)
+	r.Lookup("nx.example.com", dns.TypeA)
+	if s.Queries() != 2 {
+		t.Errorf("queries %d, want 2, capped negative ttl expired", s.Queries())
+	}
+}
+
+func TestNegativeCacheNoSOA(t *testing.T) {
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		rsp := new(dns.Msg)
+		rsp.SetRcode(m, dns.RcodeNameError)
+		w.WriteMsg(rsp)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, tc := range []struct {
+		negativeTTL time.Duration
+		queries     int
+	}{{0, 2}, {time.Minute, 1}} {
+		c := dnsresolver.NewCache()
+		c.NegativeTTL = tc.negativeTTL
+		r := cachedResolver(s, c)
+		before := s.Queries()
+		r.Lookup("nx.test", dns.TypeA)
+		r.Lookup("nx.test", dns.TypeA)
+		if got := s.Queries() - before; got != tc.queries {
+			t.Errorf("NegativeTTL %v: queries %d, want %d", tc.negativeTTL, got, tc.queries)
+		}
+	}
+}
+
+func TestReverseLookupError(t *testing.T) {
+	s := newServer(t, _testZone)
+	_, err := s.Resolver().ReverseLookupIP4("192.0.2.1")
+	var rcodeErr *dnsresolver.RcodeError
+	if !errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError {
+		t.Fatalf("got %v, want wrapped NXDOMAIN", err)
+	}
+	if _, err = s.Resolver().ReverseLookupIP4("192.0.2.%d"); err == nil {
+		t.Fatal("invalid address: want error")
+	}
+}
+
+// _shortTTLZone has a 1s ttl record for expiry tests
+const _shortTTLZone = _testZone + "short 1 IN A 192.0.2.11\n"
+
+func TestCachePositive(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := cachedResolver(s, dnsresolver.NewCache())
+	for range 3 {
+		if addrs, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil || len(addrs) != 1 {
+			t.Fatalf("got %v %v", addrs, err)
+		}
+	}
+	if s.Queries() != 1 {
+		t.Errorf("queries %d, want 1", s.Queries())
+	}
+}
+
+func TestCacheServeStale(t *testing.T) {
+	s := newServer(t, _shortTTLZone)
+	c := dnsresolver.NewCache()
+	c.ServeStale = true
+	r := cachedResolver(s, c)
+	if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	s.SetFault("short.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	addrs, err := r.LookupAddr("short.example.com", dns.TypeA)
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("stale: got %v %v", addrs, err)
+	}
+}
+
+func TestCacheStaleTimeout(t *testing.T) {
+	s := newServer(t, _shortTTLZone)
+	c := dnsresolver.NewCache()
+	c.ServeStale, c.StaleTimeout = true, 50*time.Millisecond
+	r := cachedResolver(s, c)
+	if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	s.SetFault("short.example.com", dnsresolvertest.Fault{Delay: 500 * time.Millisecond})
+	start := time.Now()
+	addrs, err := r.LookupAddr("short.example.com", dns.TypeA)
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("stale: got %v %v", addrs, err)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("stale answer after %v, want about StaleTimeout", d)
+	}
+	time.Sleep(600 * time.Millisecond) // late answer refreshes the entry
+	s.ClearFaults()
+	queries := s.Queries()
+	r.LookupAddr("short.example.com", dns.TypeA)
+	if s.Queries() != queries {
+		t.Errorf("late answer not cached, queries %d, want %d", s.Queries(), queries)
+	}
+}
+
+func TestCacheRefreshReset(t *testing.T) {
+	s := newServer(t, _shortTTLZone)
+	c := dnsresolver.NewCache()
+	c.ServeStale = true
+	r := cachedResolver(s, c)
+	if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	s.SetFault("short.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	r.LookupAddr("short.example.com", dns.TypeA) // upstream fails, entry served stale from now on
+	s.SetFault("short.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeNotImplemented})
+	for i := range 3 {
+		queries := s.Queries()
+		if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if s.Queries() != queries+1 {
+			t.Fatalf("round %d: no background refresh, queries %d", i, s.Queries())
+		}
+	}
+}
+
+func TestCachePrefetch(t *testing.T) {
+	s := newServer(t, _shortTTLZone)
+	c := dnsresolver.NewCache()
+	c.Prefetch = true
+	r := cachedResolver(s, c)
+	start := time.Now()
+	for range 2 { // stored, first hit
+		if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Until(start.Add(940 * time.Millisecond))) // last tenth of the 1s ttl
+	for range 3 {
+		if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s.Queries() != 2 {
+		t.Fatalf("queries %d, want 2, exactly one background refresh", s.Queries())
+	}
+	time.Sleep(time.Until(start.Add(1200 * time.Millisecond))) // past the original expiry
+	if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if s.Queries() != 2 {
+		t.Errorf("queries %d, want 2, the refresh has to renew the ttl", s.Queries())
+	}
+}
+
+func TestCacheStaleConcurrent(t *testing.T) {
+	s := newServer(t, _shortTTLZone)
+	c := dnsresolver.NewCache()
+	c.ServeStale = true
+	r := cachedResolver(s, c)
+	if _, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	s.SetFault("short.example.com", dnsresolvertest.Fault{Delay: 200 * time.Millisecond})
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addrs, err := r.LookupAddr("short.example.com", dns.TypeA); err != nil || len(addrs) != 1 {
+				t.Errorf("got %v %v", addrs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Queries() != 2 {
+		t.Errorf("queries %d, want 2, concurrent callers have to share one upstream query", s.Queries())
+	}
+}
+
+func TestCacheAnsweringServer(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := cachedResolver(s, dnsresolver.NewCache())
+	r.Server, r.Servers, r.NoUDP, r.Timeout = "127.0.0.1:1", []string{s.Addr}, true, time.Second
+	for round := range 2 {
+		_, err := r.Lookup("nx.example.com", dns.TypeA)
+		var rcodeErr *dnsresolver.RcodeError
+		if !errors.As(err, &rcodeErr) || rcodeErr.Server != s.Addr || rcodeErr.Cached != (round == 1) {
+			t.Fatalf("round %d: got %+v, want NXDOMAIN from %s", round, rcodeErr, s.Addr)
+		}
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	s, other := newServer(t, _testZone), newServer(t, _testZone)
+	c := dnsresolver.NewCache()
+	secure := cachedResolver(s, c)
+	secure.DNSSEC = true
+	resolvers := []*dnsresolver.Resolver{cachedResolver(s, c), secure, cachedResolver(other, c)}
+	for round := range 2 {
+		for _, r := range resolvers {
+			if addrs, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil || len(addrs) != 1 {
+				t.Fatalf("round %d: got %v %v", round, addrs, err)
+			}
+		}
+	}
+	// plain and DNSSEC resolver ask upstream once each, the second round is answered from cache
+	if s.Queries() != 2 || other.Queries() != 1 || c.Len() != 3 {
+		t.Errorf("queries %d %d, entries %d, want 2 1 3", s.Queries(), other.Queries(), c.Len())
+	}
+}
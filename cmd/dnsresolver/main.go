@@ -0,0 +1,63 @@
+// package main ...
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// main resolves names in bulk, one query per line: name [TYPE ...]
+func main() {
+	server := flag.String("server", "", "dns server ip:port (default: auto)")
+	workers := flag.Int("workers", 0, "concurrent queries (default: 16)")
+	qps := flag.Int("qps", 0, "query rate limit (default: unlimited)")
+	retries := flag.Int("retries", 0, "retries of failed queries (default: 2, <0: none)")
+	types := flag.String("types", "A", "comma separated types for lines without types")
+	timeout := flag.Duration("timeout", 4*time.Second, "query timeout")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dnsresolver [options] <file|->")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var in io.Reader = os.Stdin
+	if name := flag.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	r := &dnsresolver.Resolver{Name: *server, Server: *server, Timeout: *timeout}
+	if *server == "" {
+		r = dnsresolver.ResolverAuto()
+	}
+	bulk := dnsresolver.NewBulk(r)
+	bulk.Workers, bulk.QPS, bulk.Retries = *workers, *qps, *retries
+	for _, t := range strings.Split(*types, ",") {
+		rType, ok := dns.StringToType[strings.ToUpper(strings.TrimSpace(t))]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "unknown type: "+t)
+			os.Exit(2)
+		}
+		bulk.Types = append(bulk.Types, rType)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for res := range bulk.RunReader(in) {
+		out.WriteString(res.String())
+	}
+}
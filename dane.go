@@ -0,0 +1,193 @@
+package dnsresolver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_daneUsagePKIXTA = 0
+	_daneUsagePKIXEE = 1
+	_daneUsageDANETA = 2
+	_daneUsageDANEEE = 3
+	_daneSelCert     = 0
+	_daneSelSPKI     = 1
+	_daneMatchFull   = 0
+	_daneMatchSHA256 = 1
+	_daneMatchSHA512 = 2
+	_errDANE         = "[dnsinfo] [dane] verification failed "
+	_errTLSA         = "[dnsinfo] [tlsa] "
+)
+
+// errDANE ...
+var errDANE = errors.New(_errDANE)
+
+// LookupTLSA resolves the TLSA records of _port._proto.host, with DNSSEC set the answer
+// has to be authenticated (AD bit) by the upstream validator
+func (r *Resolver) LookupTLSA(host string, port uint16, proto string) ([]*dns.TLSA, error) {
+	name := tlsaName(host, port, proto)
+	records, secure, err := r.lookupTLSA(name)
+	if err == nil && r.DNSSEC && !secure {
+		return nil, errors.New(_errTLSA + name + _sep + "answer not DNSSEC authenticated")
+	}
+	return records, err
+}
+
+// VerifyConnectionDANE returns a tls.Config.VerifyConnection func that enforces the
+// TLSA records of host:port (tcp), see VerifyDANE, requires DNSSEC and an authenticated answer
+func (r *Resolver) VerifyConnectionDANE(host string, port uint16) (func(tls.ConnectionState) error, error) {
+	if !r.DNSSEC {
+		return nil, errors.New(_errTLSA + tlsaName(host, port, _tcp) + _sep + "DNSSEC required, spoofable records can not replace PKIX")
+	}
+	records, err := r.LookupTLSA(host, port, _tcp)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New(_errTLSA + tlsaName(host, port, _tcp) + _sep + _noAnswer)
+	}
+	return VerifyDANE(records, nil), nil
+}
+
+// TLSConfigDANE returns a tls.Config for host:port verified via DANE only, the usual
+// PKIX verification is replaced by VerifyDANE (PKIX usages still check the chain), requires
+// DNSSEC and an authenticated answer, see VerifyConnectionDANE
+func (r *Resolver) TLSConfigDANE(host string, port uint16) (*tls.Config, error) {
+	verify, err := r.VerifyConnectionDANE(host, port)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName:         strings.TrimSuffix(host, _dot),
+		InsecureSkipVerify: true, // VerifyConnection enforces the TLSA records
+		MinVersion:         tls.VersionTLS12,
+		VerifyConnection:   verify,
+	}, nil
+}
+
+// VerifyDANE returns a tls.Config.VerifyConnection func that accepts a connection if any
+// usable TLSA record matches (RFC 6698, RFC 7671), roots (nil: system) anchor the PKIX usages
+func VerifyDANE(records []*dns.TLSA, roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("%w%s%s", errDANE, state.ServerName, _sep+"no peer certificate")
+		}
+		usable := 0
+		for _, tlsa := range records {
+			ok, known := daneMatch(tlsa, state, roots)
+			if known {
+				usable++
+			}
+			if ok {
+				return nil
+			}
+		}
+		if usable == 0 {
+			return fmt.Errorf("%w%s%s", errDANE, state.ServerName, _sep+"no usable TLSA record")
+		}
+		return fmt.Errorf("%w%s", errDANE, state.ServerName)
+	}
+}
+
+// lookupTLSA returns the records and the DNSSEC state of the answer, NXDOMAIN is no error
+func (r *Resolver) lookupTLSA(name string) ([]*dns.TLSA, bool, error) {
+	rsp, err := r.resolve(name, dns.TypeTLSA)
+	var rcodeErr *RcodeError
+	if err != nil && !(errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError) {
+		return nil, false, errors.New(_errTLSA + name + _sep + err.Error())
+	}
+	var records []*dns.TLSA
+	for _, rr := range rsp.Answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok {
+			records = append(records, tlsa)
+		}
+	}
+	return records, rsp.AuthenticatedData, nil
+}
+
+// daneMatch reports a match and whether the record parameters are known at all
+func daneMatch(tlsa *dns.TLSA, state tls.ConnectionState, roots *x509.CertPool) (match, known bool) {
+	if tlsa.Selector > _daneSelSPKI || tlsa.MatchingType > _daneMatchSHA512 {
+		return false, false
+	}
+	leaf := state.PeerCertificates[0]
+	switch tlsa.Usage {
+	case _daneUsageDANEEE: // leaf only, no name or expiry checks (RFC 7671 section 5.1)
+		return daneMatchCert(tlsa, leaf), true
+	case _daneUsagePKIXEE:
+		return daneMatchCert(tlsa, leaf) && pkixVerify(state, roots) != nil, true
+	case _daneUsageDANETA:
+		for _, ca := range state.PeerCertificates {
+			if !daneMatchCert(tlsa, ca) {
+				continue
+			}
+			anchor := x509.NewCertPool() // also for a self-signed leaf, DANE-TA checks the name (RFC 7671 section 5.2)
+			anchor.AddCert(ca)
+			if pkixVerify(state, anchor) != nil {
+				return true, true
+			}
+		}
+		return false, true
+	case _daneUsagePKIXTA:
+		for _, verified := range pkixVerify(state, roots) {
+			for _, ca := range verified[1:] {
+				if daneMatchCert(tlsa, ca) {
+					return true, true
+				}
+			}
+		}
+		return false, true
+	}
+	return false, false
+}
+
+// pkixVerify returns the verified chains of the leaf against roots
+func pkixVerify(state tls.ConnectionState, roots *x509.CertPool) [][]*x509.Certificate {
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return nil
+	}
+	return chains
+}
+
+// daneMatchCert ...
+func daneMatchCert(tlsa *dns.TLSA, cert *x509.Certificate) bool {
+	data := cert.Raw
+	if tlsa.Selector == _daneSelSPKI {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch tlsa.MatchingType {
+	case _daneMatchSHA256:
+		h := sha256.Sum256(data)
+		data = h[:]
+	case _daneMatchSHA512:
+		h := sha512.Sum512(data)
+		data = h[:]
+	}
+	want, err := hex.DecodeString(tlsa.Certificate)
+	return err == nil && bytes.Equal(data, want)
+}
+
+// tlsaName ...
+func tlsaName(host string, port uint16, proto string) string {
+	return "_" + strconv.Itoa(int(port)) + "._" + strings.TrimPrefix(proto, "_") + _dot + dns.Fqdn(host)
+}
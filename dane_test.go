@@ -0,0 +1,210 @@
+package dnsresolver_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// testCert is a certificate with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert issues a certificate for names, self signed without parent
+func newCert(t *testing.T, parent *testCert, names ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     names,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign|x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}
+}
+
+// tlsa returns the TLSA record of cert
+func tlsa(usage, selector, matching uint8, cert *x509.Certificate) *dns.TLSA {
+	data := cert.Raw
+	if selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	switch matching {
+	case 1:
+		h := sha256.Sum256(data)
+		data = h[:]
+	case 2:
+		h := sha512.Sum512(data)
+		data = h[:]
+	}
+	return &dns.TLSA{
+		Hdr:          dns.RR_Header{Name: "_443._tcp.tls.example.com.", Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: 300},
+		Usage:        usage,
+		Selector:     selector,
+		MatchingType: matching,
+		Certificate:  hex.EncodeToString(data),
+	}
+}
+
+func TestVerifyDANE(t *testing.T) {
+	ca := newCert(t, nil)
+	leaf := newCert(t, ca, "tls.example.com")
+	other := newCert(t, nil, "tls.example.com")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	state := tls.ConnectionState{ServerName: "tls.example.com", PeerCertificates: []*x509.Certificate{leaf.cert, ca.cert}}
+	for _, tc := range []struct {
+		name   string
+		record *dns.TLSA
+		roots  *x509.CertPool
+		state  tls.ConnectionState
+		err    string
+	}{
+		{name: "DANE-EE SPKI SHA-256", record: tlsa(3, 1, 1, leaf.cert), state: state},
+		{name: "DANE-EE cert SHA-512", record: tlsa(3, 0, 2, leaf.cert), state: state},
+		{name: "DANE-EE cert full", record: tlsa(3, 0, 0, leaf.cert), state: state},
+		{name: "DANE-EE other key", record: tlsa(3, 1, 1, other.cert), state: state, err: "verification failed"},
+		{name: "DANE-EE any name", record: tlsa(3, 1, 1, leaf.cert), state: tls.ConnectionState{ServerName: "other.example.com", PeerCertificates: state.PeerCertificates}},
+		{name: "DANE-TA SPKI SHA-256", record: tlsa(2, 1, 1, ca.cert), state: state},
+		{name: "DANE-TA wrong name", record: tlsa(2, 1, 1, ca.cert), state: tls.ConnectionState{ServerName: "other.example.com", PeerCertificates: state.PeerCertificates}, err: "verification failed"},
+		{name: "DANE-TA self-signed leaf", record: tlsa(2, 1, 1, other.cert), state: tls.ConnectionState{ServerName: "tls.example.com", PeerCertificates: []*x509.Certificate{other.cert}}},
+		{name: "DANE-TA self-signed leaf wrong name", record: tlsa(2, 1, 1, other.cert), state: tls.ConnectionState{ServerName: "other.example.com", PeerCertificates: []*x509.Certificate{other.cert}}, err: "verification failed"},
+		{name: "DANE-TA not in chain", record: tlsa(2, 1, 1, other.cert), state: state, err: "verification failed"},
+		{name: "PKIX-TA", record: tlsa(0, 0, 1, ca.cert), roots: roots, state: state},
+		{name: "PKIX-TA untrusted", record: tlsa(0, 0, 1, ca.cert), roots: x509.NewCertPool(), state: state, err: "verification failed"},
+		{name: "PKIX-EE", record: tlsa(1, 1, 1, leaf.cert), roots: roots, state: state},
+		{name: "PKIX-EE untrusted", record: tlsa(1, 1, 1, leaf.cert), roots: x509.NewCertPool(), state: state, err: "verification failed"},
+		{name: "unknown selector", record: &dns.TLSA{Usage: 3, Selector: 2, MatchingType: 1}, state: state, err: "no usable TLSA record"},
+		{name: "no certificate", record: tlsa(3, 1, 1, leaf.cert), state: tls.ConnectionState{ServerName: "tls.example.com"}, err: "no peer certificate"},
+	} {
+		err := dnsresolver.VerifyDANE([]*dns.TLSA{tc.record}, tc.roots)(tc.state)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestLookupTLSA(t *testing.T) {
+	leaf := newCert(t, nil, "tls.example.com")
+	s := newServer(t, _testZone+tlsa(3, 1, 1, leaf.cert).String()+"\n")
+	r := s.Resolver()
+	records, err := r.LookupTLSA("tls.example.com", 443, "tcp")
+	if err != nil || len(records) != 1 || records[0].Usage != 3 {
+		t.Errorf("got %v %v", records, err)
+	}
+	if records, err := r.LookupTLSA("www.example.com", 443, "tcp"); err != nil || len(records) != 0 {
+		t.Errorf("no records: got %v %v, want none", records, err)
+	}
+	r.DNSSEC = true // the harness does not validate, AD stays unset
+	if _, err := r.LookupTLSA("tls.example.com", 443, "tcp"); err == nil || !strings.Contains(err.Error(), "not DNSSEC authenticated") {
+		t.Errorf("DNSSEC: got %v, want unauthenticated error", err)
+	}
+	secure, _ := newSecureServer(t, _testZone+tlsa(3, 1, 1, leaf.cert).String()+"\n")
+	r = secure.Resolver()
+	r.DNSSEC = true
+	if _, err := r.VerifyConnectionDANE("www.example.com", 443); err == nil {
+		t.Error("VerifyConnectionDANE without records: want error")
+	}
+}
+
+func TestTLSConfigDANEInsecure(t *testing.T) {
+	leaf := newCert(t, nil, "tls.example.com")
+	zone := _testZone + tlsa(3, 1, 1, leaf.cert).String() + "\n"
+	s, _ := newSecureServer(t, zone)
+	unauthenticated := newServer(t, zone).Resolver()
+	unauthenticated.DNSSEC = true
+	for name, r := range map[string]*dnsresolver.Resolver{
+		"no DNSSEC":       s.Resolver(), // authenticated answer, but validation not requested
+		"unauthenticated": unauthenticated,
+	} {
+		if _, err := r.TLSConfigDANE("tls.example.com", 443); err == nil {
+			t.Errorf("%s: TLSConfigDANE: want refused", name)
+		}
+		if _, err := r.VerifyConnectionDANE("tls.example.com", 443); err == nil {
+			t.Errorf("%s: VerifyConnectionDANE: want refused", name)
+		}
+	}
+}
+
+func TestTLSConfigDANE(t *testing.T) {
+	leaf, other := newCert(t, nil, "tls.example.com"), newCert(t, nil, "tls.example.com")
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	_, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	for _, tc := range []struct {
+		cert *x509.Certificate
+		ok   bool
+	}{{leaf.cert, true}, {other.cert, false}} {
+		record := tlsa(3, 1, 1, tc.cert)
+		record.Hdr.Name = "_" + portStr + "._tcp.tls.example.com."
+		s, _ := newSecureServer(t, _testZone+record.String()+"\n")
+		r := s.Resolver()
+		r.DNSSEC = true
+		c, err := r.TLSConfigDANE("tls.example.com", uint16(port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tls.Dial("tcp", l.Addr().String(), c)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("pinned %v: got %v, want ok %v", tc.cert.SerialNumber, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package dnsresolver
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_errDNSBL = "[dnsinfo] [dnsbl] "
+)
+
+// var
+var (
+	_dnsblListed  = netip.MustParsePrefix("127.0.0.0/8")
+	_dnsblRefused = netip.MustParsePrefix("127.255.255.0/24")
+)
+
+// DNSBLCodes maps the return codes of known lists (zone, code, meaning), extend as needed
+var DNSBLCodes = map[string]map[string]string{
+	"zen.spamhaus.org": {
+		"127.0.0.2":  "SBL, spamhaus spam source",
+		"127.0.0.3":  "SBL CSS, snowshoe spam source",
+		"127.0.0.4":  "XBL, exploited host",
+		"127.0.0.9":  "SBL DROP, hijacked netblock",
+		"127.0.0.10": "PBL, isp end user range",
+		"127.0.0.11": "PBL, spamhaus end user range",
+	},
+	"dbl.spamhaus.org": {
+		"127.0.1.2":   "spam domain",
+		"127.0.1.4":   "phishing domain",
+		"127.0.1.5":   "malware domain",
+		"127.0.1.6":   "botnet c&c domain",
+		"127.0.1.102": "abused legit spam domain",
+		"127.0.1.103": "abused legit spammed redirector domain",
+		"127.0.1.104": "abused legit phishing domain",
+		"127.0.1.105": "abused legit malware domain",
+		"127.0.1.106": "abused legit botnet c&c domain",
+	},
+	"bl.spamcop.net": {
+		"127.0.0.2": "spamcop reported spam source",
+	},
+	"b.barracudacentral.org": {
+		"127.0.0.2": "barracuda reputation, poor",
+	},
+}
+
+// DNSBLResult is the answer of a single blocklist
+type DNSBLResult struct {
+	Zone  string
+	Query string
+	// Listed the query name resolved to a 127.0.0.0/8 return code
+	Listed bool
+	Codes  []netip.Addr
+	// Meanings of the codes, see DNSBLCodes, the code itself when unknown
+	Meanings []string
+	// Reasons TXT records of listed entries
+	Reasons []string
+	// Err lookup failures, invalid answers or refused queries (127.255.255.0/24)
+	Err error
+}
+
+// CheckDNSBL queries addr against all zones concurrently, reversed octets (ip4) or nibbles (ip6)
+func (r *Resolver) CheckDNSBL(addr netip.Addr, zones []string) []DNSBLResult {
+	return r.checkBL(reversedIP(addr), zones)
+}
+
+// CheckRHSBL queries the domain against all (right hand side) zones concurrently
+func (r *Resolver) CheckRHSBL(domain string, zones []string) []DNSBLResult {
+	return r.checkBL(strings.TrimSuffix(domain, _dot), zones)
+}
+
+// checkBL ...
+func (r *Resolver) checkBL(prefix string, zones []string) []DNSBLResult {
+	results := make([]DNSBLResult, len(zones))
+	var bg sync.WaitGroup
+	for i, zone := range zones {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			results[i] = r.queryBL(prefix, strings.TrimSuffix(zone, _dot))
+		}()
+	}
+	bg.Wait()
+	return results
+}
+
+// queryBL ...
+func (r *Resolver) queryBL(prefix, zone string) DNSBLResult {
+	result := DNSBLResult{Zone: zone, Query: prefix + _dot + zone + _dot}
+	rsp, err := r.resolve(result.Query, dns.TypeA)
+	var rcodeErr *RcodeError
+	switch {
+	case errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError:
+		return result
+	case err != nil:
+		result.Err = errors.New(_errDNSBL + zone + _sep + err.Error())
+		return result
+	}
+	for _, code := range answerAddrs(rsp) {
+		switch {
+		case _dnsblRefused.Contains(code):
+			result.Err = errors.New(_errDNSBL + zone + _sep + "query refused: " + code.String())
+			return result
+		case !_dnsblListed.Contains(code):
+			result.Err = errors.New(_errDNSBL + zone + _sep + "invalid return code: " + code.String())
+			return result
+		}
+		meaning, ok := DNSBLCodes[strings.ToLower(zone)][code.String()]
+		if !ok {
+			meaning = code.String()
+		}
+		result.Codes = append(result.Codes, code)
+		result.Meanings = append(result.Meanings, meaning)
+	}
+	if result.Listed = len(result.Codes) > 0; !result.Listed {
+		return result
+	}
+	if txt, err := r.lookupTXT(result.Query); err == nil {
+		result.Reasons = txt
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package dnsresolver_test
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _dnsblZone ...
+const _dnsblZone = `2.2.0.192.bl	IN A	127.0.0.2
+2.2.0.192.bl	IN A	127.0.0.4
+2.2.0.192.bl	IN TXT	"listed, see https://bl.example.com/192.0.2.2"
+1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl IN A 127.0.0.3
+2.2.0.192.refused IN A	127.255.255.254
+2.2.0.192.invalid IN A	192.0.2.1
+spam.example.org.rhs IN A 127.0.1.2
+`
+
+// _dnsblZones ...
+var _dnsblZones = []string{"bl.example.com", "refused.example.com.", "invalid.example.com", "clean.example.com"}
+
+func TestCheckDNSBL(t *testing.T) {
+	s := newServer(t, _testZone+_dnsblZone)
+	r := s.Resolver()
+	dnsresolver.DNSBLCodes["bl.example.com"] = map[string]string{"127.0.0.2": "test spam source"}
+	t.Cleanup(func() { delete(dnsresolver.DNSBLCodes, "bl.example.com") })
+	results := r.CheckDNSBL(netip.MustParseAddr("192.0.2.2"), _dnsblZones)
+	if len(results) != len(_dnsblZones) {
+		t.Fatalf("got %d results, want %d", len(results), len(_dnsblZones))
+	}
+	bl := results[0]
+	if bl.Zone != "bl.example.com" || bl.Query != "2.2.0.192.bl.example.com." || !bl.Listed || bl.Err != nil ||
+		!slices.Equal(bl.Codes, []netip.Addr{netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.4")}) ||
+		!slices.Equal(bl.Meanings, []string{"test spam source", "127.0.0.4"}) ||
+		!slices.Equal(bl.Reasons, []string{"listed, see https://bl.example.com/192.0.2.2"}) {
+		t.Errorf("unexpected listing: %+v", bl)
+	}
+	if refused := results[1]; refused.Zone != "refused.example.com" || refused.Listed || refused.Err == nil ||
+		!strings.Contains(refused.Err.Error(), "query refused: 127.255.255.254") {
+		t.Errorf("refused query: %+v", refused)
+	}
+	if invalid := results[2]; invalid.Listed || invalid.Err == nil || !strings.Contains(invalid.Err.Error(), "invalid return code: 192.0.2.1") {
+		t.Errorf("invalid return code: %+v", invalid)
+	}
+	if clean := results[3]; clean.Listed || clean.Err != nil || len(clean.Codes) != 0 {
+		t.Errorf("not listed: %+v", clean)
+	}
+	results = r.CheckDNSBL(netip.MustParseAddr("2001:db8::1"), []string{"bl.example.com"})
+	if v6 := results[0]; !v6.Listed || v6.Err != nil || v6.Query != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.example.com." ||
+		!slices.Equal(v6.Codes, []netip.Addr{netip.MustParseAddr("127.0.0.3")}) || len(v6.Reasons) != 0 {
+		t.Errorf("ipv6 nibbles: %+v", v6)
+	}
+	if results = r.CheckDNSBL(netip.MustParseAddr("192.0.2.2"), nil); len(results) != 0 {
+		t.Errorf("no zones: got %+v", results)
+	}
+}
+
+func TestCheckRHSBL(t *testing.T) {
+	s := newServer(t, _testZone+_dnsblZone)
+	r := s.Resolver()
+	results := r.CheckRHSBL("spam.example.org.", []string{"rhs.example.com", "bl.example.com"})
+	if rhs := results[0]; rhs.Query != "spam.example.org.rhs.example.com." || !rhs.Listed || rhs.Err != nil ||
+		!slices.Equal(rhs.Meanings, []string{"127.0.1.2"}) {
+		t.Errorf("unexpected listing: %+v", rhs)
+	}
+	if results[1].Listed || results[1].Err != nil {
+		t.Errorf("not listed: %+v", results[1])
+	}
+	s.SetFault("spam.example.org.rhs.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	if results = r.CheckRHSBL("spam.example.org", []string{"rhs.example.com"}); results[0].Listed || results[0].Err == nil {
+		t.Errorf("server failure: %+v", results[0])
+	}
+}
+
+func TestCheckDNSBLConcurrentDoT(t *testing.T) {
+	s := newServer(t, _testZone+_dnsblZone)
+	r := s.ResolverDoT()
+	r.Timeout = 10 * time.Second // every lookup does its own handshake, slow under -race
+	var zones []string
+	for i := range 8 {
+		zones = append(zones, _dnsblZones[i%len(_dnsblZones)])
+	}
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, result := range r.CheckDNSBL(netip.MustParseAddr("192.0.2.2"), zones) {
+				listed, failed := j%len(_dnsblZones) == 0, j%len(_dnsblZones) == 1 || j%len(_dnsblZones) == 2
+				if result.Listed != listed || (result.Err != nil) != failed {
+					t.Errorf("run %d result %d %s: got listed %v err %v", i, j, result.Zone, result.Listed, result.Err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,415 @@
+// Package dnsresolvertest provides in-process dns servers (udp, tcp, DoT) to test
+// code built on dnsresolver without network access
+package dnsresolvertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// const
+const (
+	_empty        = ""
+	_localhost    = "127.0.0.1"
+	_timeout      = 2 * time.Second
+	_listenTries  = 16
+	_maxCNAME     = 8
+	_errServer    = "[dnsresolvertest] "
+	_errKeyPin    = "[dnsresolvertest] [tls keypin verification failed]"
+	_certValidity = 24 * time.Hour
+	_qr           = 1 << 15 // header bit of responses
+)
+
+// Fault is injected into the replies for a name
+type Fault struct {
+	// Delay before the reply is sent
+	Delay time.Duration
+	// Truncate udp replies (TC bit set, answer stripped), tcp and DoT answer in full
+	Truncate bool
+	// Rcode replaces the reply code, eg. dns.RcodeServerFailure
+	Rcode int
+	// Malformed sends a reply that does not unpack
+	Malformed bool
+	// Drop sends no reply at all
+	Drop bool
+}
+
+// Server is a local dns server listening on udp, tcp and tcp-tls (DoT)
+type Server struct {
+	// Addr is the udp and tcp listen address (ip:port)
+	Addr string
+	// TLSAddr is the DoT listen address (ip:port)
+	TLSAddr string
+	// KeyPin of the generated DoT certificate, see dnsresolver.Resolver.TLSKeyPin
+	KeyPin string
+	// Cert is the generated DoT certificate
+	Cert *x509.Certificate
+
+	mu      sync.RWMutex
+	handler dns.Handler
+	faults  map[string]Fault
+	queries int
+	servers []*dns.Server
+}
+
+// NewServer serves scripted zone data in master file format, origin is the default $ORIGIN
+func NewServer(zone, origin string) (*Server, error) {
+	z, err := parseZone(zone, origin)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerHandler(z)
+}
+
+// NewServerFunc serves answers from a handler func
+func NewServerFunc(fn func(w dns.ResponseWriter, m *dns.Msg)) (*Server, error) {
+	return NewServerHandler(dns.HandlerFunc(fn))
+}
+
+// NewServerHandler serves answers from a dns.Handler
+func NewServerHandler(h dns.Handler) (*Server, error) {
+	return NewServerHandlerAddr(h, net.JoinHostPort(_localhost, "0"))
+}
+
+// NewServerHandlerAddr serves answers from a dns.Handler on udp and tcp addr (ip:port, port 0
+// picks a free one), eg. several servers on one port of different loopback addresses
+func NewServerHandlerAddr(h dns.Handler, addr string) (*Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.New(_errServer + err.Error())
+	}
+	s := &Server{handler: h, faults: make(map[string]Fault)}
+	pc, l, err := listenUDPTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := selfSigned()
+	if err != nil {
+		pc.Close()
+		l.Close()
+		return nil, err
+	}
+	tl, err := tls.Listen("tcp", net.JoinHostPort(host, "0"), &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		pc.Close()
+		l.Close()
+		return nil, errors.New(_errServer + err.Error())
+	}
+	s.Addr, s.TLSAddr, s.Cert, s.KeyPin = pc.LocalAddr().String(), tl.Addr().String(), cert, keyPin(cert)
+	s.servers = []*dns.Server{
+		{PacketConn: pc, Handler: s, MsgAcceptFunc: accept},
+		{Listener: l, Handler: s, MsgAcceptFunc: accept},
+		{Listener: tl, Handler: s, Net: "tcp-tls", MsgAcceptFunc: accept},
+	}
+	for _, srv := range s.servers {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go func(srv *dns.Server) { _ = srv.ActivateAndServe() }(srv)
+		<-started
+	}
+	return s, nil
+}
+
+// Close shuts down all listeners
+func (s *Server) Close() error {
+	var err error
+	for _, srv := range s.servers {
+		if serr := srv.Shutdown(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// SetFault injects f into all replies for name, an empty name matches all queries
+func (s *Server) SetFault(name string, f Fault) {
+	s.mu.Lock()
+	s.faults[key(name)] = f
+	s.mu.Unlock()
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = make(map[string]Fault)
+	s.mu.Unlock()
+}
+
+// Queries returns the number of queries received
+func (s *Server) Queries() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queries
+}
+
+// Resolver returns a resolver using the udp (tcp fallback) listener
+func (s *Server) Resolver() *dnsresolver.Resolver {
+	return &dnsresolver.Resolver{
+		Name:    "dnsresolvertest",
+		Server:  s.Addr,
+		Timeout: _timeout,
+		Health:  &dnsresolver.Health{},
+	}
+}
+
+// ResolverDoT returns a resolver using the DoT listener, pinned to the generated certificate
+func (s *Server) ResolverDoT() *dnsresolver.Resolver {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Cert)
+	pin := s.KeyPin
+	return &dnsresolver.Resolver{
+		Name:      "dnsresolvertest-dot",
+		Server:    s.TLSAddr,
+		DoT:       true,
+		TLSKeyPin: pin,
+		TLSConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: _localhost,
+			MinVersion: tls.VersionTLS13,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 || keyPin(state.PeerCertificates[0]) != pin {
+					return errors.New(_errKeyPin)
+				}
+				return nil
+			},
+		},
+		Timeout: _timeout,
+		Health:  &dnsresolver.Health{},
+	}
+}
+
+// ServeDNS applies the injected faults around the configured handler
+func (s *Server) ServeDNS(w dns.ResponseWriter, m *dns.Msg) {
+	s.mu.Lock()
+	s.queries++
+	f, ok := Fault{}, false
+	if len(m.Question) > 0 {
+		f, ok = s.faults[key(m.Question[0].Name)]
+	}
+	if !ok {
+		f = s.faults[_empty]
+	}
+	s.mu.Unlock()
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	switch {
+	case f.Drop:
+		return
+	case f.Malformed:
+		buf := make([]byte, 14)
+		binary.BigEndian.PutUint16(buf, m.Id)
+		buf[2] = 0x80                                // QR
+		binary.BigEndian.PutUint16(buf[4:], 1)       // QDCOUNT
+		binary.BigEndian.PutUint16(buf[12:], 0xc00c) // qname compression pointer loop
+		_, _ = w.Write(buf)
+		return
+	case f.Rcode != dns.RcodeSuccess:
+		rsp := new(dns.Msg)
+		rsp.SetRcode(m, f.Rcode)
+		_ = w.WriteMsg(rsp)
+		return
+	case f.Truncate && w.LocalAddr().Network() == "udp":
+		rsp := new(dns.Msg)
+		rsp.SetReply(m)
+		rsp.Truncated = true
+		_ = w.WriteMsg(rsp)
+		return
+	}
+	s.handler.ServeDNS(w, m)
+}
+
+//
+// ZONE DATA
+//
+
+// zone answers authoritative from a static record set
+type zone struct {
+	records map[string][]dns.RR
+	all     []dns.RR
+	soa     *dns.SOA
+}
+
+// parseZone ...
+func parseZone(data, origin string) (*zone, error) {
+	z := &zone{records: make(map[string][]dns.RR)}
+	zp := dns.NewZoneParser(strings.NewReader(data), dns.Fqdn(origin), _empty)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := key(rr.Header().Name)
+		z.records[name] = append(z.records[name], rr)
+		z.all = append(z.all, rr)
+		if soa, ok := rr.(*dns.SOA); ok && z.soa == nil {
+			z.soa = soa
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, errors.New(_errServer + err.Error())
+	}
+	return z, nil
+}
+
+// ServeDNS answers including cname chains, NXDOMAIN and NODATA with SOA
+func (z *zone) ServeDNS(w dns.ResponseWriter, m *dns.Msg) {
+	rsp := new(dns.Msg)
+	rsp.SetReply(m)
+	rsp.Authoritative = true
+	if len(m.Question) != 1 {
+		rsp.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(rsp)
+		return
+	}
+	if m.Opcode != dns.OpcodeQuery {
+		rsp.Rcode = dns.RcodeNotImplemented
+		_ = w.WriteMsg(rsp)
+		return
+	}
+	q := m.Question[0]
+	if q.Qtype == dns.TypeAXFR && z.soa != nil && key(q.Name) == key(z.soa.Hdr.Name) {
+		z.transfer(w, m)
+		return
+	}
+	name := key(q.Name)
+	for i := 0; i < _maxCNAME; i++ {
+		rrs, ok := z.records[name]
+		if !ok {
+			if len(rsp.Answer) == 0 {
+				rsp.Rcode = dns.RcodeNameError
+			}
+			break
+		}
+		var cname *dns.CNAME
+		for _, rr := range rrs {
+			switch {
+			case rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY:
+				rsp.Answer = append(rsp.Answer, rr)
+			case rr.Header().Rrtype == dns.TypeCNAME:
+				cname = rr.(*dns.CNAME)
+			}
+		}
+		if cname == nil || q.Qtype == dns.TypeCNAME {
+			break
+		}
+		rsp.Answer = append(rsp.Answer, cname)
+		name = key(cname.Target)
+	}
+	if len(rsp.Answer) == 0 && z.soa != nil {
+		rsp.Ns = []dns.RR{z.soa}
+	}
+	_ = w.WriteMsg(rsp)
+}
+
+// transfer answers AXFR with all records enclosed in the SOA
+func (z *zone) transfer(w dns.ResponseWriter, m *dns.Msg) {
+	if w.LocalAddr().Network() == "udp" {
+		rsp := new(dns.Msg)
+		rsp.SetRcode(m, dns.RcodeRefused)
+		_ = w.WriteMsg(rsp)
+		return
+	}
+	rrs := []dns.RR{z.soa}
+	for _, rr := range z.all {
+		if rr != dns.RR(z.soa) {
+			rrs = append(rrs, rr)
+		}
+	}
+	ch := make(chan *dns.Envelope, 1)
+	ch <- &dns.Envelope{RR: append(rrs, z.soa)}
+	close(ch)
+	_ = new(dns.Transfer).Out(w, m, ch)
+	_ = w.Close()
+}
+
+//
+// LITTLE HELPER
+//
+
+// key ...
+func key(name string) string {
+	if name == _empty {
+		return _empty
+	}
+	return dns.CanonicalName(name)
+}
+
+// accept passes dynamic updates (RFC 2136) to the handler, everything else as dns.DefaultMsgAcceptFunc
+func accept(dh dns.Header) dns.MsgAcceptAction {
+	if dh.Bits&_qr == 0 && int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// listenUDPTCP binds udp and tcp on the same port of addr
+func listenUDPTCP(addr string) (net.PacketConn, net.Listener, error) {
+	var err error
+	for i := 0; i < _listenTries; i++ {
+		var pc net.PacketConn
+		if pc, err = net.ListenPacket("udp", addr); err != nil {
+			continue
+		}
+		var l net.Listener
+		if l, err = net.Listen("tcp", pc.LocalAddr().String()); err != nil {
+			pc.Close()
+			continue
+		}
+		return pc, l, nil
+	}
+	return nil, nil, errors.New(_errServer + err.Error())
+}
+
+// selfSigned generates a short lived certificate for the local listener
+func selfSigned() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.New(_errServer + err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, errors.New(_errServer + err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dnsresolvertest"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(_certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP(_localhost)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, errors.New(_errServer + err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, errors.New(_errServer + err.Error())
+	}
+	return cert, key, nil
+}
+
+// keyPin is the base64 sha256 hash of the certificate public key info
+func keyPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,171 @@
+package dnsresolvertest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _zone ...
+const _zone = `$TTL 300
+@	IN SOA	ns hostmaster 1 7200 3600 86400 60
+@	IN NS	ns
+ns	IN A	192.0.2.53
+www	IN A	192.0.2.80
+alias	IN CNAME www
+chain	IN CNAME alias
+`
+
+// newServer ...
+func newServer(t *testing.T) *dnsresolvertest.Server {
+	t.Helper()
+	s, err := dnsresolvertest.NewServer(_zone, "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// rcode returns the reply code of a lookup error, -1 for other errors
+func rcode(err error) int {
+	var rcodeErr *dnsresolver.RcodeError
+	if errors.As(err, &rcodeErr) {
+		return rcodeErr.Rcode
+	}
+	return -1
+}
+
+func TestZone(t *testing.T) {
+	s := newServer(t)
+	for _, r := range []*dnsresolver.Resolver{s.Resolver(), s.ResolverDoT()} {
+		for _, tc := range []struct {
+			name  string
+			rType uint16
+			want  string
+			rcode int
+		}{
+			{"www.example.com", dns.TypeA, "192.0.2.80", dns.RcodeSuccess},
+			{"chain.example.com", dns.TypeA, "192.0.2.80", dns.RcodeSuccess},
+			{"chain.example.com", dns.TypeCNAME, "alias.example.com.", dns.RcodeSuccess},
+			{"nx.example.com", dns.TypeA, "", dns.RcodeNameError},
+		} {
+			got, err := r.Lookup(tc.name, tc.rType)
+			if tc.rcode != dns.RcodeSuccess {
+				if rcode(err) != tc.rcode {
+					t.Errorf("%s %s: got %v %v, want %s", r.Name, tc.name, got, err, dns.RcodeToString[tc.rcode])
+				}
+				continue
+			}
+			if err != nil || len(got) == 0 || !strings.Contains(got[len(got)-1], tc.want) {
+				t.Errorf("%s %s: got %v %v, want %s", r.Name, tc.name, got, err, tc.want)
+			}
+		}
+		if _, err := r.Lookup("www.example.com", dns.TypeMX); err == nil {
+			t.Errorf("%s: NODATA: want error", r.Name)
+		}
+	}
+	if s.Queries() != 10 {
+		t.Errorf("queries %d, want 10", s.Queries())
+	}
+}
+
+func TestServerFunc(t *testing.T) {
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		rsp := new(dns.Msg)
+		rsp.SetReply(m)
+		rsp.Answer = append(rsp.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60}, Txt: []string{"scripted"}})
+		w.WriteMsg(rsp)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Resolver().Lookup("any.name", dns.TypeTXT); err != nil || len(got) != 1 || !strings.Contains(got[0], "scripted") {
+		t.Errorf("got %v %v", got, err)
+	}
+}
+
+func TestServerHandlerAddr(t *testing.T) {
+	inner := newServer(t)
+	s, err := dnsresolvertest.NewServerHandlerAddr(inner, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !strings.HasPrefix(s.Addr, "127.0.0.1:") || strings.HasSuffix(s.Addr, ":0") {
+		t.Errorf("addr: got %s", s.Addr)
+	}
+	if got, err := s.Resolver().Lookup("www.example.com", dns.TypeA); err != nil || len(got) != 1 {
+		t.Errorf("got %v %v", got, err)
+	}
+	if _, err = dnsresolvertest.NewServerHandlerAddr(inner, "bogus"); err == nil {
+		t.Error("invalid address: expected error")
+	}
+}
+
+func TestKeyPin(t *testing.T) {
+	s := newServer(t)
+	other := newServer(t)
+	r := s.ResolverDoT()
+	r.Server = other.TLSAddr // certificate of another server, pin mismatch
+	if _, err := r.Lookup("www.example.com", dns.TypeA); err == nil {
+		t.Error("keypin mismatch: want error")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := newServer(t)
+	for _, tc := range []struct {
+		fault dnsresolvertest.Fault
+		noTCP bool
+		ok    bool
+		rcode int
+	}{
+		{fault: dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure}, rcode: dns.RcodeServerFailure},
+		{fault: dnsresolvertest.Fault{Rcode: dns.RcodeRefused}, rcode: dns.RcodeRefused},
+		{fault: dnsresolvertest.Fault{Malformed: true}, rcode: -1},
+		{fault: dnsresolvertest.Fault{Drop: true}, rcode: -1},
+		{fault: dnsresolvertest.Fault{Delay: 300 * time.Millisecond}, rcode: -1},
+		{fault: dnsresolvertest.Fault{Delay: 20 * time.Millisecond}, ok: true},
+		{fault: dnsresolvertest.Fault{Truncate: true}, ok: true}, // retried via tcp
+		{fault: dnsresolvertest.Fault{Truncate: true}, noTCP: true, rcode: -1},
+	} {
+		s.SetFault("www.example.com", tc.fault)
+		r := s.Resolver()
+		r.Timeout, r.NoTCP = 100*time.Millisecond, tc.noTCP
+		start := time.Now()
+		got, err := r.Lookup("www.example.com", dns.TypeA)
+		switch {
+		case tc.ok && (err != nil || len(got) != 1):
+			t.Errorf("%+v: got %v %v", tc.fault, got, err)
+		case !tc.ok && (err == nil || rcode(err) != tc.rcode):
+			t.Errorf("%+v: got %v %v, want rcode %d", tc.fault, got, err, tc.rcode)
+		}
+		if d := time.Since(start); d > 350*time.Millisecond {
+			t.Errorf("%+v: took %v, Timeout not applied (udp and tcp)", tc.fault, d)
+		}
+		if _, err := r.Lookup("alias.example.com", dns.TypeA); err != nil {
+			t.Errorf("%+v: fault applied to other names: %v", tc.fault, err)
+		}
+	}
+	s.ClearFaults()
+	if _, err := s.Resolver().Lookup("www.example.com", dns.TypeA); err != nil {
+		t.Errorf("ClearFaults: %v", err)
+	}
+}
+
+func TestFaultAllNames(t *testing.T) {
+	s := newServer(t)
+	s.SetFault("", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	for _, name := range []string{"www.example.com", "ns.example.com"} {
+		if _, err := s.Resolver().Lookup(name, dns.TypeA); rcode(err) != dns.RcodeServerFailure {
+			t.Errorf("%s: got %v, want SERVFAIL", name, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package dnsresolver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_dnstapContentType = "protobuf:dnstap.Dnstap"
+	_fstrmEscape       = 0
+	_fstrmAccept       = 1
+	_fstrmStart        = 2
+	_fstrmStop         = 3
+	_fstrmReady        = 4
+	_fstrmFinish       = 5
+	_fstrmContentType  = 1
+	_fstrmControlMax   = 512
+	_dnstapTypeMessage = 1
+	_dnstapClientQuery = 5
+	_dnstapClientResp  = 6
+	_dnstapFwdQuery    = 7
+	_dnstapFwdResp     = 8
+	_dnstapInet        = 1
+	_dnstapInet6       = 2
+	_dnstapUDP         = 1
+	_dnstapTCP         = 2
+	_dnstapDoT         = 3
+	_errDnstap         = "[dnsinfo] [dnstap] "
+)
+
+// Dnstap writes dnstap query and response logs (protobuf via frame streams)
+type Dnstap struct {
+	// Identity of the sender (optional)
+	Identity string
+	// Version of the sender (optional)
+	Version string
+	// Forwarder logs FORWARDER_QUERY/RESPONSE instead of CLIENT_QUERY/RESPONSE messages
+	Forwarder bool
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	reader io.Reader
+	err    error
+}
+
+// NewDnstap starts an unidirectional frame stream on w
+func NewDnstap(w io.Writer) (*Dnstap, error) {
+	d := &Dnstap{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		d.closer = c
+	}
+	if err := d.control(_fstrmStart); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DnstapFile creates (truncates) a dnstap log file
+func DnstapFile(path string) (*Dnstap, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.New(_errDnstap + err.Error())
+	}
+	return NewDnstap(f)
+}
+
+// DnstapUnix connects to a dnstap collector listening on a unix socket, bidirectional frame stream
+func DnstapUnix(path string) (*Dnstap, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, errors.New(_errDnstap + err.Error())
+	}
+	d := &Dnstap{w: bufio.NewWriter(conn), closer: conn, reader: conn}
+	if err := d.control(_fstrmReady); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := d.expect(_fstrmAccept); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := d.control(_fstrmStart); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close stops the frame stream and closes the underlying writer, if it is an io.Closer
+func (d *Dnstap) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.controlLocked(_fstrmStop)
+	if err == nil && d.reader != nil {
+		err = d.expect(_fstrmFinish)
+	}
+	if d.closer != nil {
+		if cerr := d.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	d.err = errors.New(_errDnstap + "closed")
+	return err
+}
+
+// Err returns the first write error, logging stops after it
+func (d *Dnstap) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// query logs an outgoing query
+func (d *Dnstap) query(proto string, local, remote net.Addr, wire []byte, t time.Time) {
+	msgType := _dnstapClientQuery
+	if d.Forwarder {
+		msgType = _dnstapFwdQuery
+	}
+	d.log(msgType, proto, local, remote, wire, t, nil, time.Time{})
+}
+
+// response logs a received response
+func (d *Dnstap) response(proto string, local, remote net.Addr, tq time.Time, rsp *dns.Msg, tr time.Time) {
+	msgType := _dnstapClientResp
+	if d.Forwarder {
+		msgType = _dnstapFwdResp
+	}
+	d.log(msgType, proto, local, remote, nil, tq, rsp, tr)
+}
+
+// log encodes a dnstap.Message wrapped into a dnstap.Dnstap frame
+func (d *Dnstap) log(msgType int, proto string, local, remote net.Addr, query []byte, tq time.Time, rsp *dns.Msg, tr time.Time) {
+	var m []byte
+	m = pbVarint(m, 1, uint64(msgType))
+	la, ra := addrPort(local), addrPort(remote)
+	family := _dnstapInet
+	if ra.Addr().Is6() && !ra.Addr().Is4In6() {
+		family = _dnstapInet6
+	}
+	m = pbVarint(m, 2, uint64(family))
+	m = pbVarint(m, 3, uint64(dnstapProto(proto)))
+	if la.IsValid() {
+		m = pbBytes(m, 4, la.Addr().Unmap().AsSlice())
+		m = pbVarint(m, 6, uint64(la.Port()))
+	}
+	if ra.IsValid() {
+		m = pbBytes(m, 5, ra.Addr().Unmap().AsSlice())
+		m = pbVarint(m, 7, uint64(ra.Port()))
+	}
+	if !tq.IsZero() {
+		m = pbVarint(m, 8, uint64(tq.Unix()))
+		m = pbFixed32(m, 9, uint32(tq.Nanosecond()))
+	}
+	if query != nil {
+		m = pbBytes(m, 10, query)
+	}
+	if rsp != nil {
+		m = pbVarint(m, 12, uint64(tr.Unix()))
+		m = pbFixed32(m, 13, uint32(tr.Nanosecond()))
+		if buf, err := rsp.Pack(); err == nil {
+			m = pbBytes(m, 14, buf)
+		}
+	}
+	var frame []byte
+	if d.Identity != _empty {
+		frame = pbBytes(frame, 1, []byte(d.Identity))
+	}
+	if d.Version != _empty {
+		frame = pbBytes(frame, 2, []byte(d.Version))
+	}
+	frame = pbBytes(frame, 14, m)
+	frame = pbVarint(frame, 15, _dnstapTypeMessage)
+	d.write(frame)
+}
+
+// write sends a data frame
+func (d *Dnstap) write(frame []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return
+	}
+	if err := binary.Write(d.w, binary.BigEndian, uint32(len(frame))); err != nil {
+		d.err = errors.New(_errDnstap + err.Error())
+		return
+	}
+	if _, err := d.w.Write(frame); err != nil {
+		d.err = errors.New(_errDnstap + err.Error())
+		return
+	}
+	if err := d.w.Flush(); err != nil {
+		d.err = errors.New(_errDnstap + err.Error())
+	}
+}
+
+// control sends a control frame
+func (d *Dnstap) control(ctrlType uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.controlLocked(ctrlType)
+}
+
+// controlLocked ...
+func (d *Dnstap) controlLocked(ctrlType uint32) error {
+	if d.err != nil {
+		return d.err
+	}
+	ctrl := binary.BigEndian.AppendUint32(nil, ctrlType)
+	if ctrlType != _fstrmStop && ctrlType != _fstrmFinish {
+		ctrl = binary.BigEndian.AppendUint32(ctrl, _fstrmContentType)
+		ctrl = binary.BigEndian.AppendUint32(ctrl, uint32(len(_dnstapContentType)))
+		ctrl = append(ctrl, _dnstapContentType...)
+	}
+	buf := binary.BigEndian.AppendUint32(nil, _fstrmEscape)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(ctrl)))
+	buf = append(buf, ctrl...)
+	if _, err := d.w.Write(buf); err != nil {
+		return errors.New(_errDnstap + err.Error())
+	}
+	if err := d.w.Flush(); err != nil {
+		return errors.New(_errDnstap + err.Error())
+	}
+	return nil
+}
+
+// expect reads a control frame of ctrlType from a bidirectional stream
+func (d *Dnstap) expect(ctrlType uint32) error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(d.reader, hdr[:]); err != nil {
+		return errors.New(_errDnstap + err.Error())
+	}
+	escape, size, got := binary.BigEndian.Uint32(hdr[0:]), binary.BigEndian.Uint32(hdr[4:]), binary.BigEndian.Uint32(hdr[8:])
+	if escape != _fstrmEscape || size < 4 || size > _fstrmControlMax || got != ctrlType {
+		return errors.New(_errDnstap + "unexpected control frame")
+	}
+	if _, err := io.CopyN(io.Discard, d.reader, int64(size-4)); err != nil {
+		return errors.New(_errDnstap + err.Error())
+	}
+	return nil
+}
+
+// dnstapProto ...
+func dnstapProto(proto string) int {
+	switch {
+	case strings.HasPrefix(proto, _tcptls):
+		return _dnstapDoT
+	case strings.HasPrefix(proto, _tcp):
+		return _dnstapTCP
+	}
+	return _dnstapUDP
+}
+
+// addrPort ...
+func addrPort(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return ap
+}
+
+//
+// MINIMAL PROTOBUF ENCODER
+//
+
+// pbVarint ...
+func pbVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// pbFixed32 ...
+func pbFixed32(b []byte, field int, v uint32) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|5)
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// pbBytes ...
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,233 @@
+package dnsresolver_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// pbFields decodes a protobuf message into field number -> values (varint as uint64, bytes)
+func pbFields(t *testing.T, b []byte) map[int][]any {
+	t.Helper()
+	fields := make(map[int][]any)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("protobuf: bad key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("protobuf: bad varint")
+			}
+			fields[field], b = append(fields[field], v), b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatal("protobuf: bad length")
+			}
+			fields[field], b = append(fields[field], b[n:n+int(l)]), b[n+int(l):]
+		case 5:
+			fields[field], b = append(fields[field], uint64(binary.LittleEndian.Uint32(b))), b[4:]
+		default:
+			t.Fatalf("protobuf: wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+// readFrames returns the control frame types and data frames of a frame stream, up to STOP
+func readFrames(r io.Reader) (controls []uint32, data [][]byte, err error) {
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			if err == io.EOF {
+				return controls, data, nil
+			}
+			return nil, nil, err
+		}
+		escape := size == 0
+		if escape {
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return nil, nil, err
+			}
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, nil, err
+		}
+		if !escape {
+			data = append(data, frame)
+			continue
+		}
+		controls = append(controls, binary.BigEndian.Uint32(frame))
+		if controls[len(controls)-1] == 3 { // STOP
+			return controls, data, nil
+		}
+	}
+}
+
+// checkMessages verifies a query/response pair of msgTypes logged for server
+func checkMessages(t *testing.T, data [][]byte, server string, msgTypes ...uint64) {
+	t.Helper()
+	if len(data) != len(msgTypes) {
+		t.Fatalf("frames %d, want %d", len(data), len(msgTypes))
+	}
+	host, port, _ := net.SplitHostPort(server)
+	for i, frame := range data {
+		f := pbFields(t, frame)
+		if string(f[1][0].([]byte)) != "test" || f[15][0].(uint64) != 1 {
+			t.Fatalf("frame %d: identity %q type %v", i, f[1], f[15])
+		}
+		m := pbFields(t, f[14][0].([]byte))
+		if m[1][0].(uint64) != msgTypes[i] || m[2][0].(uint64) != 1 || m[3][0].(uint64) != 1 {
+			t.Errorf("frame %d: type %v family %v proto %v", i, m[1], m[2], m[3])
+		}
+		if net.IP(m[5][0].([]byte)).String() != host || strconv.FormatUint(m[7][0].(uint64), 10) != port {
+			t.Errorf("frame %d: remote %v %v, want %s", i, m[5], m[7], server)
+		}
+		if len(m[8]) != 1 {
+			t.Errorf("frame %d: no query time", i)
+		}
+		wire, rsp := m[10], i == 1
+		if rsp {
+			wire = m[14]
+			if len(m[12]) != 1 {
+				t.Errorf("frame %d: no response time", i)
+			}
+		}
+		msg := new(dns.Msg)
+		if len(wire) != 1 || msg.Unpack(wire[0].([]byte)) != nil {
+			t.Fatalf("frame %d: no dns message", i)
+		}
+		if msg.Question[0].Name != "www.example.com." || msg.Response != rsp {
+			t.Errorf("frame %d: got %v", i, msg)
+		}
+	}
+}
+
+func TestDnstapWriter(t *testing.T) {
+	s := newServer(t, _testZone)
+	for _, fwd := range []bool{false, true} {
+		var buf bytes.Buffer
+		d, err := dnsresolver.NewDnstap(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Identity, d.Forwarder = "test", fwd
+		r := s.Resolver()
+		r.Dnstap = d
+		if _, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		controls, data, err := readFrames(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(controls) != 2 || controls[0] != 2 || controls[1] != 3 {
+			t.Errorf("control frames %v, want START STOP", controls)
+		}
+		want := []uint64{5, 6}
+		if fwd {
+			want = []uint64{7, 8}
+		}
+		checkMessages(t, data, s.Addr, want...)
+	}
+}
+
+func TestDnstapUnix(t *testing.T) {
+	s := newServer(t, _testZone)
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type collected struct {
+		controls []uint32
+		data     [][]byte
+	}
+	done := make(chan collected, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- collected{}
+			return
+		}
+		defer conn.Close()
+		accept := func(ctrlType uint32) {
+			ctrl := binary.BigEndian.AppendUint32(nil, ctrlType)
+			conn.Write(append(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 0), uint32(len(ctrl))), ctrl...))
+		}
+		var c collected
+		ready, _, _ := readFrames(io.LimitReader(conn, 8+4+4+4+int64(len("protobuf:dnstap.Dnstap"))))
+		accept(1) // ACCEPT
+		controls, data, _ := readFrames(conn)
+		c.controls, c.data = append(ready, controls...), data
+		accept(5) // FINISH
+		done <- c
+	}()
+	d, err := dnsresolver.DnstapUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Identity = "test"
+	r := s.Resolver()
+	r.Dnstap = d
+	if _, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c := <-done
+	if len(c.controls) != 3 || c.controls[0] != 4 || c.controls[1] != 2 || c.controls[2] != 3 {
+		t.Errorf("control frames %v, want READY START STOP", c.controls)
+	}
+	checkMessages(t, c.data, s.Addr, 5, 6)
+	if d.Err() == nil {
+		t.Error("closed dnstap: want error")
+	}
+}
+
+func TestDnstapTSIG(t *testing.T) {
+	s := tsigServer(t, _tsigSecret, dns.RcodeSuccess)
+	var buf bytes.Buffer
+	d, err := dnsresolver.NewDnstap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Identity = "test"
+	r := s.Resolver()
+	r.Dnstap = d
+	r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Secret: _tsigSecret}
+	if _, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := readFrames(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessages(t, data, s.Addr, 5, 6)
+	// the query is logged as sent, signed with a valid mac
+	m := pbFields(t, pbFields(t, data[0])[14][0].([]byte))
+	if err := dns.TsigVerify(m[10][0].([]byte), _tsigSecret, "", false); err != nil {
+		t.Errorf("logged query: %v", err)
+	}
+}
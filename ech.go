@@ -0,0 +1,131 @@
+package dnsresolver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_errECH         = "[dnsinfo] [ech] "
+	_echDefaultALPN = "http/1.1" // default protocol of the HTTPS record, RFC 9460 section 7.1.2
+)
+
+// TLSConfigECH returns a copy of config (nil: defaults) for host (or host:port), with the
+// ECH config list and alpn of its HTTPS record, use a DoT resolver to keep the lookup private
+func (r *Resolver) TLSConfigECH(host string, config *tls.Config) (*tls.Config, error) {
+	bindings, err := r.bindingsECH(host)
+	if err != nil {
+		return nil, err
+	}
+	return configECH(host, bindings[0], config), nil
+}
+
+// DialECH connects via tls with Encrypted Client Hello to the endpoints of the HTTPS records
+// of addr (host:port), in priority order, a rejected ECH is retried once with the server
+// retry configs
+func (r *Resolver) DialECH(network, addr string, config *tls.Config) (*tls.Conn, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.New(_errECH + addr + _sep + err.Error())
+	}
+	bindings, err := r.bindingsECH(addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bindings {
+		var conn *tls.Conn
+		if conn, err = r.dialBinding(network, port, b, configECH(addr, b, config)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, errors.New(_errECH + addr + _sep + err.Error())
+}
+
+// dialBinding connects to the target of b, its port or the origin port, a rejected ECH is
+// retried once with the server retry configs
+func (r *Resolver) dialBinding(network, port string, b ServiceBinding, c *tls.Config) (*tls.Conn, error) {
+	if b.Port != 0 {
+		port = strconv.Itoa(int(b.Port))
+	}
+	addrs, err := r.targetAddrsECH(b)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: r.Timeout}
+	retried := false
+	for i := 0; i < len(addrs); i++ {
+		var conn *tls.Conn
+		conn, err = tls.DialWithDialer(dialer, network, net.JoinHostPort(addrs[i].String(), port), c)
+		if err == nil {
+			return conn, nil
+		}
+		var rejected *tls.ECHRejectionError
+		if errors.As(err, &rejected) {
+			if retried || len(rejected.RetryConfigList) == 0 {
+				return nil, err
+			}
+			c.EncryptedClientHelloConfigList = rejected.RetryConfigList
+			retried = true
+			i-- // same address, fresh configs
+		}
+	}
+	return nil, err
+}
+
+// targetAddrsECH resolves the effective TargetName of b (RFC 9460 section 2.5.2: "." is the
+// owner name, already replaced by lookupBindings), the address hints are the fallback
+func (r *Resolver) targetAddrsECH(b ServiceBinding) ([]netip.Addr, error) {
+	addrs, err := r.resolveAddrs(b.Target, []uint16{dns.TypeAAAA, dns.TypeA})
+	if err != nil || len(addrs) == 0 {
+		addrs = slices.Concat(b.IPv6Hint, b.IPv4Hint)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no address for " + b.Target)
+	}
+	return addrs, nil
+}
+
+// bindingsECH returns the HTTPS records of host that publish an ECH config, AliasMode
+// records followed, in priority order
+func (r *Resolver) bindingsECH(host string) ([]ServiceBinding, error) {
+	bindings, err := r.LookupHTTPS(host)
+	if err != nil {
+		return nil, errors.New(_errECH + host + _sep + err.Error())
+	}
+	bindings = slices.DeleteFunc(bindings, func(b ServiceBinding) bool { return len(b.ECH) == 0 })
+	if len(bindings) == 0 {
+		return nil, errors.New(_errECH + host + _sep + "no ech config published")
+	}
+	return bindings, nil
+}
+
+// configECH returns a copy of config with the ECH config list and alpn of b (plus the default
+// protocol unless excluded), the server name stays the origin host
+func configECH(host string, b ServiceBinding, config *tls.Config) *tls.Config {
+	c := &tls.Config{}
+	if config != nil {
+		c = config.Clone()
+	}
+	if c.ServerName == _empty {
+		c.ServerName = host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			c.ServerName = h
+		}
+	}
+	if len(c.NextProtos) == 0 {
+		c.NextProtos = slices.Clone(b.ALPN)
+		if !b.NoDefaultALPN && !slices.Contains(c.NextProtos, _echDefaultALPN) {
+			c.NextProtos = append(c.NextProtos, _echDefaultALPN)
+		}
+	}
+	c.MinVersion = tls.VersionTLS13
+	c.EncryptedClientHelloConfigList = b.ECH
+	return c
+}
@@ -0,0 +1,196 @@
+package dnsresolver_test
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// _echPublicName is the client facing name of the ECH configs
+const _echPublicName = "public.example.com"
+
+// echConfig returns a marshalled ECHConfig (X25519, HKDF-SHA256, AES-128-GCM) and its key
+func echConfig(t *testing.T, id byte) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.PublicKey().Bytes()
+	c := []byte{id, 0x00, 0x20}
+	c = binary.BigEndian.AppendUint16(c, uint16(len(pub)))
+	c = append(c, pub...)
+	c = append(c, 0x00, 0x04, 0x00, 0x01, 0x00, 0x01, 0)
+	c = append(c, byte(len(_echPublicName)))
+	c = append(c, _echPublicName...)
+	c = append(c, 0x00, 0x00)
+	config := binary.BigEndian.AppendUint16([]byte{0xfe, 0x0d}, uint16(len(c)))
+	return append(config, c...), key.Bytes()
+}
+
+// echList wraps configs into an ECHConfigList, base64 as in the svc param ech=
+func echList(configs ...[]byte) string {
+	var l []byte
+	for _, c := range configs {
+		l = append(l, c...)
+	}
+	return base64.StdEncoding.EncodeToString(append(binary.BigEndian.AppendUint16(nil, uint16(len(l))), l...))
+}
+
+// echServer is a tls listener with ECH keys, it reports the inner server names it accepted
+type echServer struct {
+	addr  string
+	port  string
+	roots *x509.CertPool
+	names chan string
+}
+
+// newECHServer serves with the given ECH configs and keys, the first one is sent as retry config
+func newECHServer(t *testing.T, config, key []byte) *echServer {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{_echPublicName, "*.example.com"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	s := &echServer{roots: x509.NewCertPool(), names: make(chan string, 16)}
+	s.roots.AddCert(cert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:             []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		MinVersion:               tls.VersionTLS13,
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{Config: config, PrivateKey: key, SendAsRetry: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.addr = l.Addr().String()
+	_, s.port, _ = net.SplitHostPort(s.addr)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tc := conn.(*tls.Conn)
+				if tc.Handshake() == nil && tc.ConnectionState().ECHAccepted {
+					s.names <- tc.ConnectionState().ServerName
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func TestDialECH(t *testing.T) {
+	config, key := echConfig(t, 1)
+	stale, _ := echConfig(t, 2)
+	s := newECHServer(t, config, key)
+	ech, staleECH := echList(config), echList(stale)
+	zone := _testZone + strings.NewReplacer("PORT", s.port, "ECH", ech, "STALE", staleECH).Replace(`
+direct			IN HTTPS	1 . port=PORT ech=ECH
+direct			IN A		127.0.0.1
+aliased			IN HTTPS	0 direct.example.com.
+endpoint		IN HTTPS	1 target.example.com. port=PORT ech=ECH
+target			IN A		127.0.0.1
+_8443._https.prefixed	IN HTTPS	1 . port=PORT ech=ECH
+_8443._https.prefixed	IN A		127.0.0.1
+hinted			IN HTTPS	1 . port=PORT ech=ECH ipv4hint=127.0.0.1
+fallback		IN HTTPS	1 down.example.com. port=1 ech=ECH
+fallback		IN HTTPS	2 target.example.com. port=PORT ech=ECH
+down			IN A		127.0.0.1
+retry			IN HTTPS	1 target.example.com. port=PORT ech=STALE
+`)
+	r := newServer(t, zone).Resolver()
+	for _, addr := range []string{
+		"direct.example.com:443",    // "." target is the owner name
+		"aliased.example.com:443",   // AliasMode, "." target is the alias target
+		"endpoint.example.com:443",  // alternative endpoint
+		"prefixed.example.com:8443", // "." target at a port prefixed owner name
+		"hinted.example.com:443",    // no address records, ipv4hint
+		"fallback.example.com:443",  // priority 1 endpoint down
+		"retry.example.com:443",     // stale config, server retry configs
+	} {
+		conn, err := r.DialECH("tcp", addr, &tls.Config{RootCAs: s.roots})
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		conn.Close()
+		host, _, _ := net.SplitHostPort(addr)
+		if name := <-s.names; name != host || !conn.ConnectionState().ECHAccepted {
+			t.Errorf("%s: inner server name %s, ech accepted %v", addr, name, conn.ConnectionState().ECHAccepted)
+		}
+	}
+}
+
+func TestDialECHErrors(t *testing.T) {
+	config, _ := echConfig(t, 1)
+	zone := _testZone + "noaddr IN HTTPS 1 . ech=" + echList(config) + "\nplain IN HTTPS 1 . alpn=h2\n"
+	r := newServer(t, zone).Resolver()
+	for _, addr := range []string{"noaddr.example.com:443", "plain.example.com:443", "www.example.com:443", "www.example.com"} {
+		if _, err := r.DialECH("tcp", addr, nil); err == nil || !strings.HasPrefix(err.Error(), "[dnsinfo] [ech] ") {
+			t.Errorf("%s: got %v, want ech error", addr, err)
+		}
+	}
+}
+
+func TestTLSConfigECH(t *testing.T) {
+	config, _ := echConfig(t, 1)
+	zone := _testZone + "svc IN HTTPS 2 b.example.com. alpn=h2 ech=" + echList(config) + "\nsvc IN HTTPS 1 a.example.com. alpn=h3\n"
+	r := newServer(t, zone).Resolver()
+	c, err := r.TLSConfigECH("svc.example.com:443", &tls.Config{NextProtos: []string{"http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServerName != "svc.example.com" || c.NextProtos[0] != "http/1.1" || c.MinVersion != tls.VersionTLS13 || echList(config) != base64.StdEncoding.EncodeToString(c.EncryptedClientHelloConfigList) {
+		t.Errorf("got server name %s protos %v", c.ServerName, c.NextProtos)
+	}
+}
+
+func TestTLSConfigECHDefaultALPN(t *testing.T) {
+	config, _ := echConfig(t, 1)
+	zone := _testZone + "h3 IN HTTPS 1 . alpn=h3 ech=" + echList(config) + "\nonly IN HTTPS 1 . alpn=h3 no-default-alpn ech=" + echList(config) + "\n"
+	r := newServer(t, zone).Resolver()
+	for _, tc := range []struct {
+		host string
+		want []string
+	}{
+		{"h3.example.com", []string{"h3", "http/1.1"}},
+		{"only.example.com", []string{"h3"}},
+	} {
+		c, err := r.TLSConfigECH(tc.host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(c.NextProtos, tc.want) {
+			t.Errorf("%s: got protos %v, want %v", tc.host, c.NextProtos, tc.want)
+		}
+	}
+}
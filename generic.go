@@ -1,14 +1,19 @@
 package dnsresolver
 
 import (
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 )
 
 // const
 const (
 	_ping               = "in-addr.arpa"
 	_resolvconf         = "/etc/resolv.conf"
+	_hostsfile          = "/etc/hosts"
 	_reverseIP4Suffix   = ".in-addr.arpa"
+	_reverseIP6Suffix   = ".ip6.arpa"
 	_whitespace         = ' '
 	_tab                = '\t'
 	_tabSep             = "\t"
@@ -21,17 +26,20 @@ const (
 	_tcptls             = "tcp-tls"
 	_tcp                = "tcp"
 	_udp                = "udp"
+	_local              = "local"
 	_six                = "6"
 	_four               = "4"
 	_dns                = "DNS "
 	_policyhost         = "policy host:"
 	_rfail              = "FAIL "
 	_noAnswer           = "no answer"
+	_noSource           = "no source answered"
 	_errLookup          = " lookup failed : "
 	_errUnsupportedType = "unsupported Type: "
 	_errKeyPin          = "[dnsinfo] [tls keypin verification failed] "
 	_errReverseAnswer   = "[dnsinfo] [reverse-lookup] invalid response from server "
 	_errReverseLookup   = "[dnsinfo] [reverse-lookup] not a valid IP4 address: "
+	_errServerDown      = "[dnsinfo] [health] server marked down: "
 )
 
 //
@@ -60,6 +68,62 @@ func isFile(filename string) bool {
 	return fi.Mode().IsRegular()
 }
 
+// reverseAddr parses a in-addr.arpa or ip6.arpa name back into its address
+func reverseAddr(name string) (netip.Addr, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), _dot)
+	switch {
+	case strings.HasSuffix(name, _reverseIP4Suffix):
+		s := strings.Split(strings.TrimSuffix(name, _reverseIP4Suffix), _dot)
+		if len(s) != 4 {
+			return _emptyAddr, false
+		}
+		addr, err := netip.ParseAddr(s[3] + _dot + s[2] + _dot + s[1] + _dot + s[0])
+		return addr, err == nil
+	case strings.HasSuffix(name, _reverseIP6Suffix):
+		s := strings.Split(strings.TrimSuffix(name, _reverseIP6Suffix), _dot)
+		if len(s) != 32 {
+			return _emptyAddr, false
+		}
+		var b [16]byte
+		for i := 0; i < 32; i++ {
+			n, err := strconv.ParseUint(s[31-i], 16, 8)
+			if err != nil || len(s[31-i]) != 1 {
+				return _emptyAddr, false
+			}
+			if i%2 == 0 {
+				b[i/2] = byte(n) << 4
+				continue
+			}
+			b[i/2] |= byte(n)
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return _emptyAddr, false
+}
+
+// reversedIP returns the reversed octets (ip4) or nibbles (ip6) of addr, without suffix
+func reversedIP(addr netip.Addr) string {
+	addr = addr.Unmap()
+	b := addr.AsSlice()
+	labels := make([]string, 0, 32)
+	for i := len(b) - 1; i >= 0; i-- {
+		if addr.Is4() {
+			labels = append(labels, strconv.Itoa(int(b[i])))
+			continue
+		}
+		labels = append(labels, strconv.FormatUint(uint64(b[i]&0x0f), 16), strconv.FormatUint(uint64(b[i]>>4), 16))
+	}
+	return strings.Join(labels, _dot)
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of addr
+func reverseName(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return reversedIP(addr) + _reverseIP4Suffix + _dot
+	}
+	return reversedIP(addr) + _reverseIP6Suffix + _dot
+}
+
 // removeEmptyLines
 func removeEmptyLines(textBlock string) string {
 	in := []byte(textBlock)
@@ -2,11 +2,13 @@ module paepcke.de/dnsresolver
 
 go 1.25.5
 
-require github.com/miekg/dns v1.1.72
+require (
+	github.com/miekg/dns v1.1.72
+	golang.org/x/net v0.48.0
+)
 
 require (
 	golang.org/x/mod v0.31.0 // indirect
-	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
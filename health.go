@@ -0,0 +1,215 @@
+package dnsresolver
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_healthInterval   = 30 * time.Second
+	_healthMaxFails   = 3
+	_healthBackoff    = time.Second
+	_healthBackoffMax = 5 * time.Minute
+)
+
+// Health tracks upstream servers, marks them down after repeated failures and
+// backs off exponentially before they get retried
+type Health struct {
+	// ProbeName is queried to check a server (default: in-addr.arpa)
+	ProbeName string
+	// ProbeType is the record type of the probe query (default: A)
+	ProbeType uint16
+	// Interval between background probes, also the validity of a successful check (0: 30s)
+	Interval time.Duration
+	// MaxFails consecutive failures mark a server down (0: 3)
+	MaxFails int
+	// Backoff is the initial down time, doubled on every further failure (0: 1s)
+	Backoff time.Duration
+	// BackoffMax caps the down time (0: 5m)
+	BackoffMax time.Duration
+
+	mu      sync.Mutex
+	servers map[string]*serverHealth
+	stop    chan struct{}
+}
+
+// ServerState is a snapshot of the health state of a server
+type ServerState struct {
+	Server    string
+	Up        bool
+	Fails     int
+	Checked   time.Time
+	DownUntil time.Time
+	Err       error
+}
+
+// serverHealth ...
+type serverHealth struct {
+	fails     int
+	checked   time.Time
+	downUntil time.Time
+	backoff   time.Duration
+	err       error
+}
+
+// DefaultHealth is used by all resolvers without Health set
+var DefaultHealth = &Health{}
+
+// health ...
+func (r *Resolver) health() *Health {
+	if r.Health != nil {
+		return r.Health
+	}
+	return DefaultHealth
+}
+
+// State returns the health state of server, down while within its backoff period as for failover
+func (h *Health) State(server string) ServerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(server)
+	return ServerState{
+		Server:    server,
+		Up:        !time.Now().Before(s.downUntil),
+		Fails:     s.fails,
+		Checked:   s.checked,
+		DownUntil: s.downUntil,
+		Err:       s.err,
+	}
+}
+
+// Watch probes all servers of resolver every Interval in the background, until Stop
+func (h *Health) Watch(r *Resolver) {
+	h.mu.Lock()
+	if h.stop == nil {
+		h.stop = make(chan struct{})
+	}
+	stop := h.stop
+	h.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(durationOr(h.Interval, _healthInterval))
+		defer ticker.Stop()
+		for {
+			for _, server := range r.servers() {
+				h.probe(r, server)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends all background probes started via Watch
+func (h *Health) Stop() {
+	h.mu.Lock()
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	h.mu.Unlock()
+}
+
+// check returns nil for a recently verified server, an error for a server within its
+// backoff period, everything else gets probed
+func (h *Health) check(r *Resolver, server string) error {
+	now := time.Now()
+	h.mu.Lock()
+	s := h.get(server)
+	fresh := s.err == nil && !s.checked.IsZero() && now.Sub(s.checked) < durationOr(h.Interval, _healthInterval)
+	down := now.Before(s.downUntil)
+	h.mu.Unlock()
+	switch {
+	case fresh:
+		return nil
+	case down:
+		return errors.New(_errServerDown + server)
+	}
+	return h.probe(r, server)
+}
+
+// probe ...
+func (h *Health) probe(r *Resolver, server string) error {
+	name, rType := h.ProbeName, h.ProbeType
+	if name == _empty {
+		name = _ping
+	}
+	if rType == dns.TypeNone {
+		rType = dns.TypeA
+	}
+	_, _, err := r.exchangeServer(server, name, rType)
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) {
+		err = nil // server answered
+	}
+	h.report(server, err)
+	return err
+}
+
+// report feeds a query or probe result into the server state
+func (h *Health) report(server string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(server)
+	s.checked, s.err = time.Now(), err
+	if err == nil {
+		s.fails, s.backoff, s.downUntil = 0, 0, time.Time{}
+		return
+	}
+	s.fails++
+	if s.fails < h.maxFails() {
+		return
+	}
+	switch {
+	case s.backoff == 0:
+		s.backoff = durationOr(h.Backoff, _healthBackoff)
+	default:
+		s.backoff *= 2
+	}
+	if backoffMax := durationOr(h.BackoffMax, _healthBackoffMax); s.backoff > backoffMax {
+		s.backoff = backoffMax
+	}
+	s.downUntil = s.checked.Add(s.backoff)
+}
+
+// usable filters out servers within their backoff period, keeping the configured order
+func (h *Health) usable(servers []string) []string {
+	now := time.Now()
+	up := make([]string, 0, len(servers))
+	h.mu.Lock()
+	for _, server := range servers {
+		if now.Before(h.get(server).downUntil) {
+			continue
+		}
+		up = append(up, server)
+	}
+	h.mu.Unlock()
+	return up
+}
+
+// get ...
+func (h *Health) get(server string) *serverHealth {
+	if h.servers == nil {
+		h.servers = make(map[string]*serverHealth)
+	}
+	s, ok := h.servers[server]
+	if !ok {
+		s = &serverHealth{}
+		h.servers[server] = s
+	}
+	return s
+}
+
+// maxFails ...
+func (h *Health) maxFails() int {
+	if h.MaxFails > 0 {
+		return h.MaxFails
+	}
+	return _healthMaxFails
+}
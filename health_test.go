@@ -0,0 +1,115 @@
+package dnsresolver_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _deadServer refuses tcp connections
+const _deadServer = "127.0.0.1:1"
+
+// failoverResolver queries the dead server first, s as fallback
+func failoverResolver(s *dnsresolvertest.Server, h *dnsresolver.Health) *dnsresolver.Resolver {
+	r := s.Resolver()
+	r.Server, r.Servers, r.NoUDP, r.Health = _deadServer, []string{s.Addr}, true, h
+	return r
+}
+
+func TestHealthFailover(t *testing.T) {
+	s := newServer(t, _testZone)
+	h := &dnsresolver.Health{MaxFails: 2, Backoff: time.Minute}
+	r := failoverResolver(s, h)
+	for range 4 {
+		if addrs, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil || len(addrs) != 1 {
+			t.Fatalf("got %v %v", addrs, err)
+		}
+	}
+	state := h.State(_deadServer)
+	if state.Up || state.Fails != 2 || state.Err == nil || !state.DownUntil.After(time.Now()) {
+		t.Errorf("dead server: got %+v, want down after 2 fails", state)
+	}
+	if state := h.State(s.Addr); !state.Up || state.Fails != 0 {
+		t.Errorf("fallback server: got %+v, want up", state)
+	}
+}
+
+func TestHealthBackoff(t *testing.T) {
+	s := newServer(t, _testZone)
+	h := &dnsresolver.Health{MaxFails: 1, Backoff: 20 * time.Millisecond, BackoffMax: 30 * time.Millisecond}
+	r := failoverResolver(s, h)
+	for i, want := range []time.Duration{20, 30, 30} {
+		if _, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil {
+			t.Fatal(err)
+		}
+		state := h.State(_deadServer)
+		if got := state.DownUntil.Sub(state.Checked); got != want*time.Millisecond {
+			t.Errorf("round %d: backoff %v, want %v", i, got, want*time.Millisecond)
+		}
+		time.Sleep(time.Until(state.DownUntil))
+		if state = h.State(_deadServer); !state.Up {
+			t.Errorf("round %d: got %+v, want up again after the backoff", i, state)
+		}
+	}
+}
+
+func TestHealthRecovery(t *testing.T) {
+	s := newServer(t, _testZone)
+	h := &dnsresolver.Health{MaxFails: 1, Backoff: 10 * time.Millisecond}
+	r := s.Resolver()
+	r.Health = h
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Malformed: true})
+	if _, err := r.LookupAddr("www.example.com", dns.TypeA); err == nil {
+		t.Fatal("malformed reply: want error")
+	}
+	if state := h.State(s.Addr); state.Up {
+		t.Fatalf("got %+v, want down", state)
+	}
+	s.ClearFaults()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if state := h.State(s.Addr); !state.Up || state.Fails != 0 || state.Err != nil {
+		t.Errorf("got %+v, want up again", state)
+	}
+}
+
+func TestHealthWatch(t *testing.T) {
+	var mu sync.Mutex
+	probes := make(map[string]int)
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		mu.Lock()
+		probes[m.Question[0].Name]++
+		mu.Unlock()
+		rsp := new(dns.Msg)
+		w.WriteMsg(rsp.SetRcode(m, dns.RcodeNameError))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	h := &dnsresolver.Health{ProbeName: "probe.example.", ProbeType: dns.TypeTXT, Interval: 20 * time.Millisecond}
+	h.Watch(s.Resolver())
+	time.Sleep(110 * time.Millisecond)
+	h.Stop()
+	mu.Lock()
+	n := probes["probe.example."]
+	mu.Unlock()
+	if n < 3 || len(probes) != 1 {
+		t.Errorf("probes %v, want >= 3 for probe.example.", probes)
+	}
+	if state := h.State(s.Addr); !state.Up || state.Checked.IsZero() {
+		t.Errorf("got %+v, want up (NXDOMAIN is an answer)", state)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if probes["probe.example."] > n+1 {
+		t.Errorf("probes continue after Stop: %d, had %d", probes["probe.example."], n)
+	}
+}
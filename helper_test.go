@@ -0,0 +1,35 @@
+package dnsresolver_test
+
+import (
+	"testing"
+
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// const
+const (
+	_testOrigin = "example.com."
+	_testZone   = `$TTL 300
+@		IN SOA	ns hostmaster 1 7200 3600 86400 60
+@		IN NS	ns
+@		IN A	192.0.2.1
+@		IN MX	10 mail
+ns		IN A	192.0.2.53
+mail		IN A	192.0.2.25
+www		IN A	192.0.2.80
+www		IN AAAA	2001:db8::80
+www		IN TXT	"hello"
+alias		IN CNAME www
+`
+)
+
+// newServer starts a harness server for zone (origin example.com.), closed on cleanup
+func newServer(t *testing.T, zone string) *dnsresolvertest.Server {
+	t.Helper()
+	s, err := dnsresolvertest.NewServer(zone, _testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,191 @@
+package dnsresolver
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_hostsTTL     = 0
+	_hostsRecheck = 5 * time.Second
+)
+
+// Source is a name source, consulted in Resolver.Sources order
+type Source interface {
+	// Lookup returns the local records for query, ok false hands over to the next source
+	Lookup(query string, rType uint16) (rrs []dns.RR, ok bool)
+}
+
+// MsgSource is a Source answering with complete responses, eg. NXDOMAIN with SOA, preferred
+// over Lookup by the Resolver
+type MsgSource interface {
+	Source
+	// LookupMsg returns the response for query, ok false hands over to the next source
+	LookupMsg(query string, rType uint16) (msg *dns.Msg, ok bool)
+}
+
+// SourceFunc adapts a plain func into a Source
+type SourceFunc func(query string, rType uint16) ([]dns.RR, bool)
+
+// Lookup ...
+func (f SourceFunc) Lookup(query string, rType uint16) ([]dns.RR, bool) {
+	return f(query, rType)
+}
+
+// sourceDNS is the placeholder for the upstream Resolver.Server
+type sourceDNS struct{}
+
+// Lookup ...
+func (sourceDNS) Lookup(string, uint16) ([]dns.RR, bool) { return nil, false }
+
+// var
+var (
+	// SourceDNS marks the position of the upstream dns server within Resolver.Sources
+	SourceDNS Source = sourceDNS{}
+	// SourceFiles answers from the system hosts file
+	SourceFiles Source = HostsFile(_hostsfile)
+	// SourcesSystem is the nsswitch.conf default order (hosts: files dns)
+	SourcesSystem = []Source{SourceFiles, SourceDNS}
+)
+
+// Hosts is a hosts(5) file source, parsed once and re-read on change
+type Hosts struct {
+	// Path to the hosts file
+	Path string
+
+	mu      sync.RWMutex
+	checked time.Time
+	modTime time.Time
+	size    int64
+	byName  map[string][]netip.Addr
+	byAddr  map[netip.Addr][]string
+}
+
+// HostsFile ...
+func HostsFile(path string) *Hosts {
+	return &Hosts{Path: path}
+}
+
+// Lookup answers A, AAAA and PTR queries from the hosts file
+func (h *Hosts) Lookup(query string, rType uint16) ([]dns.RR, bool) {
+	h.update()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	name := dns.CanonicalName(query)
+	var rrs []dns.RR
+	switch rType {
+	case dns.TypeA, dns.TypeAAAA:
+		for _, addr := range h.byName[name] {
+			switch {
+			case rType == dns.TypeA && addr.Is4():
+				rrs = append(rrs, &dns.A{Hdr: hostsHdr(name, rType), A: addr.AsSlice()})
+			case rType == dns.TypeAAAA && addr.Is6() && !addr.Is4In6():
+				rrs = append(rrs, &dns.AAAA{Hdr: hostsHdr(name, rType), AAAA: addr.AsSlice()})
+			}
+		}
+	case dns.TypePTR:
+		addr, ok := reverseAddr(name)
+		if !ok {
+			return nil, false
+		}
+		for _, host := range h.byAddr[addr] {
+			rrs = append(rrs, &dns.PTR{Hdr: hostsHdr(name, rType), Ptr: host})
+		}
+	}
+	return rrs, len(rrs) > 0
+}
+
+// update re-reads the hosts file when size or mtime changed, stat is rate limited
+func (h *Hosts) update() {
+	now := time.Now()
+	h.mu.RLock()
+	fresh := h.byName != nil && now.Sub(h.checked) < _hostsRecheck
+	h.mu.RUnlock()
+	if fresh {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checked = now
+	fi, err := os.Stat(h.Path)
+	if err != nil {
+		h.byName, h.byAddr = map[string][]netip.Addr{}, map[netip.Addr][]string{}
+		h.modTime, h.size = time.Time{}, 0
+		return
+	}
+	if h.byName != nil && fi.ModTime().Equal(h.modTime) && fi.Size() == h.size {
+		return
+	}
+	h.modTime, h.size = fi.ModTime(), fi.Size()
+	h.byName, h.byAddr = parseHosts(h.Path)
+}
+
+// parseHosts ...
+func parseHosts(path string) (map[string][]netip.Addr, map[netip.Addr][]string) {
+	byName, byAddr := map[string][]netip.Addr{}, map[netip.Addr][]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		return byName, byAddr
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		s := strings.Fields(line)
+		if len(s) < 2 {
+			continue
+		}
+		addr, err := netip.ParseAddr(s[0])
+		if err != nil {
+			continue
+		}
+		addr = addr.WithZone(_empty)
+		for _, host := range s[1:] {
+			if _, ok := dns.IsDomainName(host); !ok {
+				continue
+			}
+			name := dns.CanonicalName(host)
+			byName[name] = append(byName[name], addr)
+			byAddr[addr] = append(byAddr[addr], name)
+		}
+	}
+	return byName, byAddr
+}
+
+// hostsHdr ...
+func hostsHdr(name string, rType uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rType, Class: dns.ClassINET, Ttl: _hostsTTL}
+}
+
+// lookupSource asks src, responses of a MsgSource are kept, plain records wrapped by localMsg
+func lookupSource(src Source, query string, rType uint16) (*dns.Msg, bool) {
+	if ms, ok := src.(MsgSource); ok {
+		return ms.LookupMsg(query, rType)
+	}
+	rrs, ok := src.Lookup(query, rType)
+	if !ok {
+		return nil, false
+	}
+	return localMsg(query, rType, rrs), true
+}
+
+// localMsg wraps locally sourced records into a dns response
+func localMsg(query string, rType uint16, rrs []dns.RR) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(query), rType)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	msg.Answer = rrs
+	return msg
+}
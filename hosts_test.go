@@ -0,0 +1,94 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// hostsFile writes data to a temporary hosts file
+func hostsFile(t *testing.T, data string) *dnsresolver.Hosts {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dnsresolver.HostsFile(path)
+}
+
+func TestHostsLookup(t *testing.T) {
+	h := hostsFile(t, "# comment\n192.0.2.7 local.test alias.test # trailing\n2001:db8::7 local.test\nbogus line\n")
+	rrs, ok := h.Lookup("local.test", dns.TypeA)
+	if !ok || len(rrs) != 1 || rrs[0].(*dns.A).A.String() != "192.0.2.7" {
+		t.Errorf("A: got %v %v", rrs, ok)
+	}
+	if rrs, ok = h.Lookup("LOCAL.test.", dns.TypeAAAA); !ok || len(rrs) != 1 {
+		t.Errorf("AAAA: got %v %v", rrs, ok)
+	}
+	if rrs, ok = h.Lookup("7.2.0.192.in-addr.arpa", dns.TypePTR); !ok || len(rrs) != 2 {
+		t.Errorf("PTR: got %v %v", rrs, ok)
+	}
+	if _, ok = h.Lookup("missing.test", dns.TypeA); ok {
+		t.Error("missing name: want hand over")
+	}
+}
+
+func TestSourcesOrder(t *testing.T) {
+	s := newServer(t, _testZone)
+	files := hostsFile(t, "192.0.2.7 local.example.com\n192.0.2.8 www.example.com\n")
+	dead := &dnsresolver.Resolver{Server: "127.0.0.1:1", NoUDP: true, Timeout: time.Second, Health: &dnsresolver.Health{}}
+	tests := []struct {
+		name    string
+		r       *dnsresolver.Resolver
+		sources []dnsresolver.Source
+		query   string
+		want    string
+		rcode   int // expected RcodeError, 0: none
+	}{
+		{"dns nxdomain falls back to files", s.Resolver(), []dnsresolver.Source{dnsresolver.SourceDNS, files}, "local.example.com", "192.0.2.7", 0},
+		{"dns answer wins", s.Resolver(), []dnsresolver.Source{dnsresolver.SourceDNS, files}, "www.example.com", "192.0.2.80", 0},
+		{"files answer wins", s.Resolver(), []dnsresolver.Source{files, dnsresolver.SourceDNS}, "www.example.com", "192.0.2.8", 0},
+		{"dns down falls back to files", dead, []dnsresolver.Source{dnsresolver.SourceDNS, files}, "local.example.com", "192.0.2.7", 0},
+		{"all sources fail", s.Resolver(), []dnsresolver.Source{dnsresolver.SourceDNS, files}, "nx.example.com", "", dns.RcodeNameError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.r.Sources = tc.sources
+			addrs, err := tc.r.LookupAddr(tc.query, dns.TypeA)
+			if tc.rcode != 0 {
+				var rcodeErr *dnsresolver.RcodeError
+				if !errors.As(err, &rcodeErr) || rcodeErr.Rcode != tc.rcode {
+					t.Fatalf("got %v, want %s", err, dns.RcodeToString[tc.rcode])
+				}
+				return
+			}
+			if err != nil || !slices.Contains(addrs, netip.MustParseAddr(tc.want)) {
+				t.Fatalf("got %v %v, want %s", addrs, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSourceFunc(t *testing.T) {
+	calls := 0
+	src := dnsresolver.SourceFunc(func(query string, rType uint16) ([]dns.RR, bool) {
+		calls++
+		rr, _ := dns.NewRR(dns.Fqdn(query) + " 0 IN A 192.0.2.9")
+		return []dns.RR{rr}, rType == dns.TypeA
+	})
+	r := &dnsresolver.Resolver{Server: "127.0.0.1:1", Sources: []dnsresolver.Source{src}}
+	addrs, err := r.LookupAddr("func.test", dns.TypeA)
+	if err != nil || len(addrs) != 1 || calls != 1 {
+		t.Fatalf("got %v %v, calls %d", addrs, err, calls)
+	}
+	if _, err = r.LookupAddr("func.test", dns.TypeAAAA); err == nil {
+		t.Fatal("AAAA: want error, no source answered")
+	}
+}
@@ -0,0 +1,484 @@
+package dnsresolver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_rdata   = "rdata"
+	_errJSON = "[dnsinfo] [json] "
+)
+
+// jsonMsg is the RFC 8427 message object, server, transport, rttSeconds, error and EDNS are extensions
+type jsonMsg struct {
+	ID            uint16           `json:"ID"`
+	QR            bool             `json:"QR"`
+	Opcode        int              `json:"Opcode"`
+	AA            bool             `json:"AA"`
+	TC            bool             `json:"TC"`
+	RD            bool             `json:"RD"`
+	RA            bool             `json:"RA"`
+	AD            bool             `json:"AD"`
+	CD            bool             `json:"CD"`
+	RCODE         int              `json:"RCODE"`
+	QDCOUNT       int              `json:"QDCOUNT"`
+	ANCOUNT       int              `json:"ANCOUNT"`
+	NSCOUNT       int              `json:"NSCOUNT"`
+	ARCOUNT       int              `json:"ARCOUNT"`
+	QNAME         string           `json:"QNAME,omitempty"`
+	QTYPE         uint16           `json:"QTYPE,omitempty"`
+	QTYPEname     string           `json:"QTYPEname,omitempty"`
+	QCLASS        uint16           `json:"QCLASS,omitempty"`
+	QCLASSname    string           `json:"QCLASSname,omitempty"`
+	AnswerRRs     []map[string]any `json:"answerRRs,omitempty"`
+	AuthorityRRs  []map[string]any `json:"authorityRRs,omitempty"`
+	AdditionalRRs []map[string]any `json:"additionalRRs,omitempty"`
+	EDNS          *jsonEDNS        `json:"EDNS,omitempty"`
+	Server        string           `json:"server,omitempty"`
+	Transport     string           `json:"transport,omitempty"`
+	RTTSeconds    float64          `json:"rttSeconds,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// jsonEDNS summarizes the OPT record, the record itself is part of additionalRRs
+type jsonEDNS struct {
+	Version uint8            `json:"version"`
+	UDPSize uint16           `json:"udpSize"`
+	DO      bool             `json:"DO"`
+	Options []jsonEDNSOption `json:"options,omitempty"`
+}
+
+// jsonEDNSOption ...
+type jsonEDNSOption struct {
+	Code uint16 `json:"code"`
+	Name string `json:"name,omitempty"`
+	// Data presentation format of the option
+	Data string `json:"data"`
+}
+
+// jsonRR holds the fixed members of an RFC 8427 resource record object
+type jsonRR struct {
+	NAME      string `json:"NAME"`
+	TYPE      uint16 `json:"TYPE"`
+	TYPEname  string `json:"TYPEname"`
+	CLASS     uint16 `json:"CLASS"`
+	CLASSname string `json:"CLASSname"`
+	TTL       uint32 `json:"TTL"`
+	RDATAHEX  string `json:"RDATAHEX"`
+}
+
+// dohMsg is the DNS-over-HTTPS JSON layout (application/x-javascript, as served by Google)
+type dohMsg struct {
+	Status       int           `json:"Status"`
+	TC           bool          `json:"TC"`
+	RD           bool          `json:"RD"`
+	RA           bool          `json:"RA"`
+	AD           bool          `json:"AD"`
+	CD           bool          `json:"CD"`
+	Question     []dohQuestion `json:"Question"`
+	Answer       []dohRR       `json:"Answer,omitempty"`
+	Authority    []dohRR       `json:"Authority,omitempty"`
+	Additional   []dohRR       `json:"Additional,omitempty"`
+	ClientSubnet string        `json:"edns_client_subnet,omitempty"`
+	Comment      string        `json:"Comment,omitempty"`
+	Server       string        `json:"server,omitempty"`
+	Transport    string        `json:"transport,omitempty"`
+	RTTSeconds   float64       `json:"rttSeconds,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// dohQuestion ...
+type dohQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// dohRR ...
+type dohRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// MarshalJSON encodes the response as RFC 8427 message object, all sections, EDNS options,
+// server, transport, rtt and the error of failed results included, the policy hit is not
+// encoded, QNAME and QTYPE of results without response are taken from Query and Type
+func (res Result) MarshalJSON() ([]byte, error) {
+	msg := res.msg()
+	m := jsonMsg{
+		ID:         msg.Id,
+		QR:         msg.Response,
+		Opcode:     msg.Opcode,
+		AA:         msg.Authoritative,
+		TC:         msg.Truncated,
+		RD:         msg.RecursionDesired,
+		RA:         msg.RecursionAvailable,
+		AD:         msg.AuthenticatedData,
+		CD:         msg.CheckingDisabled,
+		RCODE:      msg.Rcode,
+		QDCOUNT:    len(msg.Question),
+		ANCOUNT:    len(msg.Answer),
+		NSCOUNT:    len(msg.Ns),
+		ARCOUNT:    len(msg.Extra),
+		Server:     res.Server,
+		Transport:  res.Proto,
+		RTTSeconds: res.RTT.Seconds(),
+		Error:      errorText(res.Err),
+	}
+	if q := res.question(); q.Name != _empty {
+		m.QNAME, m.QTYPE, m.QTYPEname = q.Name, q.Qtype, dns.Type(q.Qtype).String()
+		m.QCLASS, m.QCLASSname = q.Qclass, dns.Class(q.Qclass).String()
+	}
+	var err error
+	for _, section := range []struct {
+		rrs []dns.RR
+		out *[]map[string]any
+	}{{msg.Answer, &m.AnswerRRs}, {msg.Ns, &m.AuthorityRRs}, {msg.Extra, &m.AdditionalRRs}} {
+		for _, rr := range section.rrs {
+			var obj map[string]any
+			if obj, err = jsonRRObject(rr); err != nil {
+				return nil, err
+			}
+			*section.out = append(*section.out, obj)
+		}
+	}
+	if opt := msg.IsEdns0(); opt != nil {
+		m.EDNS = &jsonEDNS{Version: opt.Version(), UDPSize: opt.UDPSize(), DO: opt.Do()}
+		for _, o := range opt.Option {
+			m.EDNS.Options = append(m.EDNS.Options, jsonEDNSOption{Code: o.Option(), Name: ednsOptionName(o.Option()), Data: o.String()})
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes an RFC 8427 message object, records are rebuilt from RDATAHEX, the
+// presentation format (rdata<TYPE>) is used when RDATAHEX is absent, the error is restored
+func (res *Result) UnmarshalJSON(data []byte) error {
+	var m struct {
+		jsonMsg
+		AnswerRRs     []json.RawMessage `json:"answerRRs"`
+		AuthorityRRs  []json.RawMessage `json:"authorityRRs"`
+		AdditionalRRs []json.RawMessage `json:"additionalRRs"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return errors.New(_errJSON + err.Error())
+	}
+	msg := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:                 m.ID,
+			Response:           m.QR,
+			Opcode:             m.Opcode,
+			Authoritative:      m.AA,
+			Truncated:          m.TC,
+			RecursionDesired:   m.RD,
+			RecursionAvailable: m.RA,
+			AuthenticatedData:  m.AD,
+			CheckingDisabled:   m.CD,
+			Rcode:              m.RCODE,
+		},
+	}
+	if m.QNAME != _empty && (m.QDCOUNT > 0 || m.Error == _empty) { // failed results carry no question
+		qclass := m.QCLASS
+		if qclass == 0 {
+			qclass = dns.ClassINET
+		}
+		msg.Question = []dns.Question{{Name: dns.Fqdn(m.QNAME), Qtype: m.QTYPE, Qclass: qclass}}
+	}
+	for _, section := range []struct {
+		objs []json.RawMessage
+		out  *[]dns.RR
+	}{{m.AnswerRRs, &msg.Answer}, {m.AuthorityRRs, &msg.Ns}, {m.AdditionalRRs, &msg.Extra}} {
+		for _, obj := range section.objs {
+			rr, err := parseJSONRR(obj)
+			if err != nil {
+				return err
+			}
+			*section.out = append(*section.out, rr)
+		}
+	}
+	res.setMsg(msg, m.QNAME, m.QTYPE, m.Server, m.Transport, m.RTTSeconds, m.Error)
+	return nil
+}
+
+// MarshalDoHJSON encodes the response in the DNS-over-HTTPS JSON layout, the OPT record is
+// reduced to edns_client_subnet, failed results as for MarshalJSON
+func (res Result) MarshalDoHJSON() ([]byte, error) {
+	msg := res.msg()
+	m := dohMsg{
+		Status:     msg.Rcode,
+		TC:         msg.Truncated,
+		RD:         msg.RecursionDesired,
+		RA:         msg.RecursionAvailable,
+		AD:         msg.AuthenticatedData,
+		CD:         msg.CheckingDisabled,
+		Question:   []dohQuestion{},
+		Server:     res.Server,
+		Transport:  res.Proto,
+		RTTSeconds: res.RTT.Seconds(),
+		Error:      errorText(res.Err),
+	}
+	for _, q := range msg.Question {
+		m.Question = append(m.Question, dohQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	if q := res.question(); len(msg.Question) == 0 && q.Name != _empty {
+		m.Question = append(m.Question, dohQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	m.Answer, m.Authority, m.Additional = dohRRs(msg.Answer), dohRRs(msg.Ns), dohRRs(msg.Extra)
+	if opt := msg.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				m.ClientSubnet = subnet.Address.String() + "/" + strconv.Itoa(int(subnet.SourceNetmask))
+			}
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalDoHJSON decodes the DNS-over-HTTPS JSON layout, the error is restored
+func (res *Result) UnmarshalDoHJSON(data []byte) error {
+	var m dohMsg
+	if err := json.Unmarshal(data, &m); err != nil {
+		return errors.New(_errJSON + err.Error())
+	}
+	msg := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Response:           true,
+			Truncated:          m.TC,
+			RecursionDesired:   m.RD,
+			RecursionAvailable: m.RA,
+			AuthenticatedData:  m.AD,
+			CheckingDisabled:   m.CD,
+			Rcode:              m.Status,
+		},
+	}
+	for _, q := range m.Question {
+		msg.Question = append(msg.Question, dns.Question{Name: dns.Fqdn(q.Name), Qtype: q.Type, Qclass: dns.ClassINET})
+	}
+	for _, section := range []struct {
+		rrs []dohRR
+		out *[]dns.RR
+	}{{m.Answer, &msg.Answer}, {m.Authority, &msg.Ns}, {m.Additional, &msg.Extra}} {
+		for _, d := range section.rrs {
+			rr, err := parseDoHRR(d)
+			if err != nil {
+				return err
+			}
+			*section.out = append(*section.out, rr)
+		}
+	}
+	if m.ClientSubnet != _empty {
+		prefix, err := netip.ParsePrefix(m.ClientSubnet)
+		if err != nil {
+			return errors.New(_errJSON + "edns_client_subnet" + _sep + err.Error())
+		}
+		subnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(prefix.Bits()), Address: prefix.Addr().AsSlice()}
+		if prefix.Addr().Is6() {
+			subnet.Family = 2
+		}
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		msg.IsEdns0().Option = append(msg.IsEdns0().Option, subnet)
+	}
+	var qname string
+	var qtype uint16
+	if len(msg.Question) > 0 {
+		qname, qtype = msg.Question[0].Name, msg.Question[0].Qtype
+	}
+	res.setMsg(msg, qname, qtype, m.Server, m.Transport, m.RTTSeconds, m.Error)
+	return nil
+}
+
+// msg returns the response, an empty one for failed results
+func (res Result) msg() *dns.Msg {
+	if res.Msg == nil {
+		return &dns.Msg{}
+	}
+	return res.Msg
+}
+
+// question returns the question of the response, built from Query and Type for failed results
+func (res Result) question() dns.Question {
+	if msg := res.msg(); len(msg.Question) > 0 {
+		return msg.Question[0]
+	}
+	if res.Query == _empty {
+		return dns.Question{}
+	}
+	return dns.Question{Name: dns.Fqdn(res.Query), Qtype: res.Type, Qclass: dns.ClassINET}
+}
+
+// setMsg fills the result from a decoded response, answer records of failed results are dropped
+func (res *Result) setMsg(msg *dns.Msg, qname string, qtype uint16, server, proto string, rtt float64, errText string) {
+	*res = Result{Query: strings.TrimSuffix(qname, _dot), Type: qtype, Msg: msg, Server: server, Proto: proto, RTT: time.Duration(rtt * float64(time.Second))}
+	if errText != _empty {
+		res.Err = resultError(res, errText)
+		return
+	}
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == res.Type {
+			res.Answer = append(res.Answer, rr)
+		}
+	}
+}
+
+// resultError restores the error of a decoded result, rcode errors as RcodeError
+func resultError(res *Result, text string) error {
+	if res.Msg.Rcode != dns.RcodeSuccess {
+		if err := rcodeError(res.Query, res.Type, res.Msg.Rcode, res.Server, res.Proto, false); err.Error() == text {
+			return err
+		}
+	}
+	return errors.New(text)
+}
+
+// errorText ...
+func errorText(err error) string {
+	if err == nil {
+		return _empty
+	}
+	return err.Error()
+}
+
+// jsonRRObject returns the RFC 8427 resource record object, RDATAHEX is packed uncompressed
+func jsonRRObject(rr dns.RR) (map[string]any, error) {
+	h := rr.Header()
+	buf := make([]byte, dns.Len(rr)+len(h.Name)+2)
+	hdrEnd, err := dns.PackDomainName(h.Name, buf, 0, nil, false)
+	if err == nil {
+		var end int
+		if end, err = dns.PackRR(rr, buf, 0, nil, false); err == nil {
+			buf = buf[hdrEnd+10 : end]
+		}
+	}
+	if err != nil {
+		return nil, errors.New(_errJSON + h.Name + _sep + dns.Type(h.Rrtype).String() + _sep + err.Error())
+	}
+	obj := map[string]any{
+		"NAME":      h.Name,
+		"TYPE":      h.Rrtype,
+		"TYPEname":  dns.Type(h.Rrtype).String(),
+		"CLASS":     h.Class,
+		"CLASSname": dns.Class(h.Class).String(),
+		"TTL":       h.Ttl,
+		"RDLENGTH":  len(buf),
+		"RDATAHEX":  strings.ToUpper(hex.EncodeToString(buf)),
+	}
+	if h.Rrtype != dns.TypeOPT {
+		obj[_rdata+dns.Type(h.Rrtype).String()] = rdataString(rr)
+	}
+	return obj, nil
+}
+
+// parseJSONRR ...
+func parseJSONRR(data json.RawMessage) (dns.RR, error) {
+	var v jsonRR
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, errors.New(_errJSON + err.Error())
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, errors.New(_errJSON + err.Error())
+	}
+	if v.CLASS == 0 {
+		v.CLASS = dns.ClassINET
+	}
+	if v.TYPE == 0 {
+		v.TYPE = dns.StringToType[v.TYPEname]
+	}
+	name := dns.Fqdn(v.NAME)
+	if _, ok := members["RDATAHEX"]; ok {
+		rdata, err := hex.DecodeString(v.RDATAHEX)
+		if err != nil {
+			return nil, errors.New(_errJSON + name + _sep + "RDATAHEX" + _sep + err.Error())
+		}
+		h := dns.RR_Header{Name: name, Rrtype: v.TYPE, Class: v.CLASS, Ttl: v.TTL, Rdlength: uint16(len(rdata))}
+		rr, _, err := dns.UnpackRRWithHeader(h, rdata, 0)
+		if err != nil {
+			return nil, errors.New(_errJSON + name + _sep + dns.Type(v.TYPE).String() + _sep + err.Error())
+		}
+		return rr, nil
+	}
+	var rdata string
+	if raw, ok := members[_rdata+dns.Type(v.TYPE).String()]; ok {
+		if err := json.Unmarshal(raw, &rdata); err != nil {
+			return nil, errors.New(_errJSON + name + _sep + err.Error())
+		}
+	}
+	return newRR(name, v.TTL, dns.Class(v.CLASS).String(), v.TYPE, rdata)
+}
+
+// dohRRs ...
+func dohRRs(rrs []dns.RR) []dohRR {
+	var out []dohRR
+	for _, rr := range rrs {
+		if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+			out = append(out, dohRR{Name: h.Name, Type: h.Rrtype, TTL: h.Ttl, Data: rdataString(rr)})
+		}
+	}
+	return out
+}
+
+// parseDoHRR accepts quoted as well as plain (single string) TXT data
+func parseDoHRR(d dohRR) (dns.RR, error) {
+	if d.Type == dns.TypeTXT && !strings.HasPrefix(d.Data, `"`) {
+		d.Data = strconv.Quote(d.Data)
+	}
+	return newRR(dns.Fqdn(d.Name), d.TTL, dns.Class(dns.ClassINET).String(), d.Type, d.Data)
+}
+
+// newRR parses a record from its presentation format
+func newRR(name string, ttl uint32, class string, rType uint16, rdata string) (dns.RR, error) {
+	rr, err := dns.NewRR(name + " " + strconv.FormatUint(uint64(ttl), 10) + " " + class + " " + dns.Type(rType).String() + " " + rdata)
+	if err != nil {
+		return nil, errors.New(_errJSON + name + _sep + dns.Type(rType).String() + _sep + err.Error())
+	}
+	if rr == nil {
+		return nil, errors.New(_errJSON + name + _sep + dns.Type(rType).String() + _sep + "empty record")
+	}
+	return rr, nil
+}
+
+// rdataString returns the presentation format of the record data, without header
+func rdataString(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// ednsOptionName ...
+func ednsOptionName(code uint16) string {
+	switch code {
+	case dns.EDNS0LLQ:
+		return "LLQ"
+	case dns.EDNS0UL:
+		return "UL"
+	case dns.EDNS0NSID:
+		return "NSID"
+	case dns.EDNS0DAU:
+		return "DAU"
+	case dns.EDNS0DHU:
+		return "DHU"
+	case dns.EDNS0N3U:
+		return "N3U"
+	case dns.EDNS0SUBNET:
+		return "ECS"
+	case dns.EDNS0EXPIRE:
+		return "EXPIRE"
+	case dns.EDNS0COOKIE:
+		return "COOKIE"
+	case dns.EDNS0TCPKEEPALIVE:
+		return "KEEPALIVE"
+	case dns.EDNS0PADDING:
+		return "PADDING"
+	case dns.EDNS0EDE:
+		return "EDE"
+	}
+	return _empty
+}
@@ -0,0 +1,193 @@
+package dnsresolver_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// exchangeResults returns the ExchangeSeq results of query by type
+func exchangeResults(t *testing.T, r *dnsresolver.Resolver, query string, rTypes ...uint16) map[uint16]dnsresolver.Result {
+	t.Helper()
+	results := make(map[uint16]dnsresolver.Result)
+	for res, err := range r.ExchangeSeq(query, rTypes) {
+		if res.Err != err {
+			t.Fatalf("%s: result error %v, yielded %v", dns.TypeToString[res.Type], res.Err, err)
+		}
+		results[res.Type] = res
+	}
+	return results
+}
+
+// ednsResult returns a response with all sections and EDNS options
+func ednsResult(t *testing.T) dnsresolver.Result {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response, msg.Authoritative, msg.RecursionAvailable, msg.AuthenticatedData = true, true, true, true
+	for _, s := range []string{"www.example.com. 300 IN CNAME web.example.com.", "web.example.com. 300 IN A 192.0.2.80"} {
+		msg.Answer = append(msg.Answer, rr(t, s))
+	}
+	msg.Ns = []dns.RR{rr(t, "example.com. 300 IN NS ns.example.com.")}
+	msg.Extra = []dns.RR{rr(t, "ns.example.com. 300 IN A 192.0.2.53")}
+	msg.SetEdns0(1232, true)
+	msg.IsEdns0().Option = []dns.EDNS0{
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e7331"},
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+	}
+	return dnsresolver.Result{Query: "www.example.com", Type: dns.TypeA, Msg: msg, Answer: msg.Answer[1:],
+		Server: "192.0.2.53:53", Proto: "udp", RTT: 1234567 * time.Nanosecond}
+}
+
+// sameResult compares the decoded result with the encoded one
+func sameResult(t *testing.T, got, want dnsresolver.Result) {
+	t.Helper()
+	if got.Query != want.Query || got.Type != want.Type || got.Server != want.Server || got.Proto != want.Proto ||
+		(got.RTT-want.RTT).Abs() > time.Microsecond || len(got.Answer) != len(want.Answer) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if (got.Err == nil) != (want.Err == nil) || (got.Err != nil && got.Err.Error() != want.Err.Error()) {
+		t.Errorf("error: got %v, want %v", got.Err, want.Err)
+	}
+}
+
+func TestResultJSONRoundTrip(t *testing.T) {
+	want := ednsResult(t)
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{`"QNAME":"www.example.com."`, `"QTYPEname":"A"`, `"ANCOUNT":2`, `"rdataCNAME":"web.example.com."`,
+		`"EDNS":{"version":0,"udpSize":1232,"DO":true`, `"name":"NSID"`, `"name":"ECS"`, `"server":"192.0.2.53:53"`} {
+		if !strings.Contains(string(data), member) {
+			t.Errorf("missing %s in %s", member, data)
+		}
+	}
+	if strings.Contains(string(data), `"error"`) {
+		t.Errorf("error member in successful result: %s", data)
+	}
+	var got dnsresolver.Result
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	sameResult(t, got, want)
+	if got.Msg.String() != want.Msg.String() {
+		t.Errorf("message:\n%s\nwant:\n%s", got.Msg, want.Msg)
+	}
+}
+
+func TestResultJSONExchange(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	for rType, want := range exchangeResults(t, r, "alias.example.com", dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeMX) {
+		for name, codec := range map[string]struct {
+			marshal   func(dnsresolver.Result) ([]byte, error)
+			unmarshal func(*dnsresolver.Result, []byte) error
+		}{
+			"rfc8427": {func(res dnsresolver.Result) ([]byte, error) { return json.Marshal(res) }, func(res *dnsresolver.Result, data []byte) error { return json.Unmarshal(data, res) }},
+			"doh":     {dnsresolver.Result.MarshalDoHJSON, (*dnsresolver.Result).UnmarshalDoHJSON},
+		} {
+			data, err := codec.marshal(want)
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, dns.TypeToString[rType], err)
+			}
+			var got dnsresolver.Result
+			if err = codec.unmarshal(&got, data); err != nil {
+				t.Fatalf("%s %s: %v", name, dns.TypeToString[rType], err)
+			}
+			sameResult(t, got, want)
+			if len(got.Msg.Answer) != len(want.Msg.Answer) || got.Msg.Rcode != want.Msg.Rcode {
+				t.Errorf("%s %s: got %s, want %s", name, dns.TypeToString[rType], got.Msg, want.Msg)
+			}
+		}
+	}
+}
+
+func TestResultJSONFailed(t *testing.T) {
+	transport := errors.New("A [dnsinfo] [lookup] 192.0.2.53:53 udp i/o timeout")
+	s := newServer(t, _testZone)
+	s.SetFault("", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	servfail := exchangeResults(t, s.Resolver(), "www.example.com", dns.TypeA)[dns.TypeA]
+	for _, want := range []dnsresolver.Result{
+		{Query: "www.example.com", Type: dns.TypeA, Msg: &dns.Msg{}, Server: "192.0.2.53:53", Proto: "udp", Err: transport},
+		{Query: "www.example.com", Type: dns.TypeAAAA, Err: transport}, // no message at all
+		servfail,
+	} {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, member := range []string{`"QNAME":"www.example.com."`, `"QTYPEname":"` + dns.TypeToString[want.Type] + `"`, `"error":"`} {
+			if !strings.Contains(string(data), member) {
+				t.Errorf("missing %s in %s", member, data)
+			}
+		}
+		var got dnsresolver.Result
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		sameResult(t, got, want)
+		if data, err = want.MarshalDoHJSON(); err != nil {
+			t.Fatal(err)
+		}
+		got = dnsresolver.Result{}
+		if err = got.UnmarshalDoHJSON(data); err != nil {
+			t.Fatal(err)
+		}
+		sameResult(t, got, want)
+	}
+	if servfail.Err == nil {
+		t.Fatal("SERVFAIL: expected error")
+	}
+	var got dnsresolver.Result
+	data, _ := json.Marshal(servfail)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	var rcodeErr *dnsresolver.RcodeError
+	if !errors.As(got.Err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeServerFailure || got.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("SERVFAIL: got %v (%T), want RcodeError", got.Err, got.Err)
+	}
+}
+
+func TestResultJSONDecode(t *testing.T) {
+	var res dnsresolver.Result
+	rfc8427 := `{"ID":1,"QR":true,"RCODE":0,"QDCOUNT":1,"QNAME":"example.com","QTYPE":16,
+		"answerRRs":[{"NAME":"example.com","TYPEname":"TXT","TTL":60,"rdataTXT":"\"v=spf1 -all\""}]}`
+	if err := json.Unmarshal([]byte(rfc8427), &res); err != nil || res.Query != "example.com" || res.Type != dns.TypeTXT ||
+		len(res.Answer) != 1 || res.Answer[0].(*dns.TXT).Txt[0] != "v=spf1 -all" || res.Err != nil {
+		t.Errorf("rfc 8427 presentation format: got %+v %v", res, err)
+	}
+	doh := `{"Status":3,"RD":true,"RA":true,"Question":[{"name":"nx.example.com.","type":1}],
+		"Authority":[{"name":"example.com.","type":6,"TTL":60,"data":"ns.example.com. hostmaster.example.com. 1 7200 3600 86400 60"}]}`
+	if err := res.UnmarshalDoHJSON([]byte(doh)); err != nil || res.Query != "nx.example.com" || res.Msg.Rcode != dns.RcodeNameError ||
+		len(res.Msg.Ns) != 1 || res.Err != nil {
+		t.Errorf("doh: got %+v %v", res, err)
+	}
+	plain := `{"Status":0,"Question":[{"name":"example.com.","type":16}],"Answer":[{"name":"example.com.","type":16,"TTL":60,"data":"hello world"}]}`
+	if err := res.UnmarshalDoHJSON([]byte(plain)); err != nil || len(res.Answer) != 1 || res.Answer[0].(*dns.TXT).Txt[0] != "hello world" {
+		t.Errorf("doh plain txt: got %+v %v", res, err)
+	}
+	for _, data := range []string{
+		`{"QNAME":`,
+		`{"answerRRs":[{"NAME":"example.com","TYPE":1,"RDATAHEX":"XYZ"}]}`,
+		`{"answerRRs":[{"NAME":"example.com","TYPE":1,"RDATAHEX":"C000"}]}`,
+		`{"answerRRs":[{"NAME":"example.com","TYPE":1,"rdataA":"not an address"}]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &res); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	for _, data := range []string{`{"Status":`, `{"Status":0,"edns_client_subnet":"bogus"}`, `{"Answer":[{"name":"example.com.","type":1,"data":"bogus"}]}`} {
+		if err := res.UnmarshalDoHJSON([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package dnsresolver
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// const
+const (
+	_dmarcPrefix   = "_dmarc."
+	_dkimPrefix    = "._domainkey."
+	_bimiPrefix    = "._bimi."
+	_mtastsPrefix  = "_mta-sts."
+	_tlsrptPrefix  = "_smtp._tls."
+	_dmarcVersion  = "DMARC1"
+	_dkimVersion   = "DKIM1"
+	_bimiVersion   = "BIMI1"
+	_mtastsVersion = "STSv1"
+	_tlsrptVersion = "TLSRPTv1"
+	_bimiSelector  = "default"
+	_noRecord      = "no record"
+	_errDMARC      = "[dnsinfo] [dmarc] "
+	_errDKIM       = "[dnsinfo] [dkim] "
+	_errBIMI       = "[dnsinfo] [bimi] "
+	_errMTASTS     = "[dnsinfo] [mta-sts] "
+	_errTLSRPT     = "[dnsinfo] [tls-rpt] "
+)
+
+// errMultipleRecords ...
+var errMultipleRecords = errors.New("multiple records")
+
+// DMARC is a parsed DMARC policy record, RFC 7489
+type DMARC struct {
+	// Domain the record was found at, OrgDomain via the organizational domain fallback
+	Domain    string
+	OrgDomain bool
+	// Policy p and SubdomainPolicy sp (default: p), none, quarantine or reject
+	Policy          string
+	SubdomainPolicy string
+	// Percent pct of messages the policy applies to (default: 100)
+	Percent int
+	// ADKIM, ASPF alignment modes, r (relaxed, default) or s (strict)
+	ADKIM string
+	ASPF  string
+	// RUA aggregate and RUF failure report uris
+	RUA []string
+	RUF []string
+	// FailureOptions fo (default: 0)
+	FailureOptions string
+	// ReportInterval ri in seconds (default: 86400)
+	ReportInterval int
+	Raw            string
+}
+
+// DKIMKey is a parsed DKIM public key record, RFC 6376 section 3.6.1
+type DKIMKey struct {
+	Selector string
+	Domain   string
+	// KeyType k, rsa (default) or ed25519 (RFC 8463)
+	KeyType string
+	// PublicKey *rsa.PublicKey or ed25519.PublicKey, nil for revoked keys
+	PublicKey crypto.PublicKey
+	Revoked   bool
+	// HashAlgorithms h, empty allows all
+	HashAlgorithms []string
+	// ServiceTypes s (default: *)
+	ServiceTypes []string
+	// Flags t, y (testing) and s (strict identity)
+	Flags []string
+	Notes string
+	Raw   string
+}
+
+// BIMI is a parsed BIMI assertion record
+type BIMI struct {
+	Selector string
+	// Domain the record was found at, OrgDomain via the organizational domain fallback
+	Domain    string
+	OrgDomain bool
+	// Location l of the svg logo, Authority a of the evidence document (https uris)
+	Location  string
+	Authority string
+	// Declined, empty l and a, the domain opts out of BIMI
+	Declined bool
+	Raw      string
+}
+
+// MTASTS is a parsed MTA-STS policy indicator record, RFC 8461 section 3.1
+type MTASTS struct {
+	Domain string
+	// ID of the current policy, changes on policy updates
+	ID  string
+	Raw string
+}
+
+// TLSRPT is a parsed SMTP TLS reporting record, RFC 8460 section 3
+type TLSRPT struct {
+	Domain string
+	// RUA report uris, mailto: or https:
+	RUA []string
+	Raw string
+}
+
+// LookupDMARC resolves the DMARC policy of domain, falling back to the organizational
+// domain (public suffix list) when domain publishes none, several records end the policy
+// discovery with an error, RFC 7489 section 6.6.3
+func (r *Resolver) LookupDMARC(domain string) (*DMARC, error) {
+	domain = strings.TrimSuffix(domain, _dot)
+	raw, err := r.lookupDMARC(domain)
+	org := false
+	if err == nil && raw == _empty {
+		if orgDomain := OrgDomain(domain); orgDomain != domain {
+			domain, org = orgDomain, true
+			raw, err = r.lookupDMARC(domain)
+		}
+	}
+	switch {
+	case err != nil:
+		return nil, errors.New(_errDMARC + domain + _sep + err.Error())
+	case raw == _empty:
+		return nil, errors.New(_errDMARC + domain + _sep + _noRecord)
+	}
+	d, err := ParseDMARC(raw)
+	if err != nil {
+		return nil, err
+	}
+	d.Domain, d.OrgDomain = domain, org
+	return d, nil
+}
+
+// lookupDMARC returns the DMARC record of domain
+func (r *Resolver) lookupDMARC(domain string) (string, error) {
+	return r.lookupTagRecord(_dmarcPrefix+domain, _dmarcVersion)
+}
+
+// ParseDMARC ...
+func ParseDMARC(raw string) (*DMARC, error) {
+	tags, err := parseTags(raw, _dmarcVersion)
+	if err != nil {
+		return nil, errors.New(_errDMARC + err.Error())
+	}
+	d := &DMARC{Percent: 100, ADKIM: "r", ASPF: "r", FailureOptions: "0", ReportInterval: 86400, Raw: raw}
+	d.Policy = strings.ToLower(tags["p"])
+	d.SubdomainPolicy = d.Policy
+	if sp, ok := tags["sp"]; ok {
+		d.SubdomainPolicy = strings.ToLower(sp)
+	}
+	if d.RUA, err = parseURIs(tags["rua"], "mailto"); err != nil {
+		return nil, errors.New(_errDMARC + "invalid rua: " + err.Error())
+	}
+	for _, p := range []string{d.Policy, d.SubdomainPolicy} {
+		switch {
+		case p == "none" || p == "quarantine" || p == "reject":
+		case len(d.RUA) > 0: // the record acts as p=none as a whole, RFC 7489 section 6.6.3
+			d.Policy, d.SubdomainPolicy = "none", "none"
+		case p == _empty:
+			return nil, errors.New(_errDMARC + "missing policy")
+		default:
+			return nil, errors.New(_errDMARC + "invalid policy: " + p)
+		}
+	}
+	if pct, ok := tags["pct"]; ok {
+		if d.Percent, err = strconv.Atoi(pct); err != nil || d.Percent < 0 || d.Percent > 100 {
+			return nil, errors.New(_errDMARC + "invalid pct: " + pct)
+		}
+	}
+	for tag, mode := range map[string]*string{"adkim": &d.ADKIM, "aspf": &d.ASPF} {
+		if v, ok := tags[tag]; ok {
+			if v = strings.ToLower(v); v != "r" && v != "s" {
+				return nil, errors.New(_errDMARC + "invalid " + tag + ": " + v)
+			}
+			*mode = v
+		}
+	}
+	if ri, ok := tags["ri"]; ok {
+		if d.ReportInterval, err = strconv.Atoi(ri); err != nil || d.ReportInterval < 0 {
+			return nil, errors.New(_errDMARC + "invalid ri: " + ri)
+		}
+	}
+	if fo, ok := tags["fo"]; ok {
+		for _, o := range strings.Split(fo, ":") {
+			if o != "0" && o != "1" && o != "d" && o != "s" {
+				return nil, errors.New(_errDMARC + "invalid fo: " + fo)
+			}
+		}
+		d.FailureOptions = fo
+	}
+	if d.RUF, err = parseURIs(tags["ruf"], "mailto"); err != nil {
+		return nil, errors.New(_errDMARC + "invalid ruf: " + err.Error())
+	}
+	return d, nil
+}
+
+// LookupDKIM resolves the DKIM public key of selector at domain
+func (r *Resolver) LookupDKIM(selector, domain string) (*DKIMKey, error) {
+	domain = strings.TrimSuffix(domain, _dot)
+	name := selector + _dkimPrefix + domain
+	txts, err := r.lookupTXT(name)
+	switch {
+	case err != nil:
+		return nil, errors.New(_errDKIM + name + _sep + err.Error())
+	case len(txts) == 0:
+		return nil, errors.New(_errDKIM + name + _sep + _noRecord)
+	case len(txts) > 1:
+		return nil, errors.New(_errDKIM + name + _sep + "multiple records")
+	}
+	k, err := ParseDKIM(txts[0])
+	if err != nil {
+		return nil, err
+	}
+	k.Selector, k.Domain = selector, domain
+	return k, nil
+}
+
+// ParseDKIM ...
+func ParseDKIM(raw string) (*DKIMKey, error) {
+	tags, err := parseTags(raw, _empty)
+	if err != nil {
+		return nil, errors.New(_errDKIM + err.Error())
+	}
+	if v, ok := tags["v"]; ok && v != _dkimVersion {
+		return nil, errors.New(_errDKIM + "invalid version: " + v)
+	}
+	k := &DKIMKey{KeyType: "rsa", ServiceTypes: []string{"*"}, Notes: tags["n"], Raw: raw}
+	if kt, ok := tags["k"]; ok {
+		k.KeyType = strings.ToLower(kt)
+	}
+	k.HashAlgorithms = splitList(tags["h"], ":")
+	if s, ok := tags["s"]; ok {
+		k.ServiceTypes = splitList(s, ":")
+	}
+	k.Flags = splitList(tags["t"], ":")
+	p, ok := tags["p"]
+	if !ok {
+		return nil, errors.New(_errDKIM + "missing p tag")
+	}
+	if p = strings.Join(strings.Fields(p), _empty); p == _empty {
+		k.Revoked = true
+		return k, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, errors.New(_errDKIM + "invalid p tag: " + err.Error())
+	}
+	switch k.KeyType {
+	case "rsa":
+		if k.PublicKey, err = x509.ParsePKIXPublicKey(der); err != nil {
+			if k.PublicKey, err = x509.ParsePKCS1PublicKey(der); err != nil {
+				return nil, errors.New(_errDKIM + "invalid rsa key: " + err.Error())
+			}
+		}
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, errors.New(_errDKIM + "invalid ed25519 key size: " + strconv.Itoa(len(der)))
+		}
+		k.PublicKey = ed25519.PublicKey(der)
+	default:
+		return nil, errors.New(_errDKIM + "unsupported key type: " + k.KeyType)
+	}
+	return k, nil
+}
+
+// LookupBIMI resolves the BIMI record of selector (empty: default) at domain, falling
+// back to the organizational domain
+func (r *Resolver) LookupBIMI(selector, domain string) (*BIMI, error) {
+	if selector == _empty {
+		selector = _bimiSelector
+	}
+	domain = strings.TrimSuffix(domain, _dot)
+	raw, err := r.lookupTagRecord(selector+_bimiPrefix+domain, _bimiVersion)
+	org := false
+	if err == nil && raw == _empty {
+		if orgDomain := OrgDomain(domain); orgDomain != domain {
+			domain, org = orgDomain, true
+			raw, err = r.lookupTagRecord(selector+_bimiPrefix+domain, _bimiVersion)
+		}
+	}
+	switch {
+	case err != nil:
+		return nil, errors.New(_errBIMI + domain + _sep + err.Error())
+	case raw == _empty:
+		return nil, errors.New(_errBIMI + domain + _sep + _noRecord)
+	}
+	b, err := ParseBIMI(raw)
+	if err != nil {
+		return nil, err
+	}
+	b.Selector, b.Domain, b.OrgDomain = selector, domain, org
+	return b, nil
+}
+
+// ParseBIMI ...
+func ParseBIMI(raw string) (*BIMI, error) {
+	tags, err := parseTags(raw, _bimiVersion)
+	if err != nil {
+		return nil, errors.New(_errBIMI + err.Error())
+	}
+	b := &BIMI{Location: tags["l"], Authority: tags["a"], Raw: raw}
+	for _, uri := range []string{b.Location, b.Authority} {
+		if uri == _empty {
+			continue
+		}
+		if u, err := url.Parse(uri); err != nil || u.Scheme != "https" || u.Host == _empty {
+			return nil, errors.New(_errBIMI + "invalid https uri: " + uri)
+		}
+	}
+	b.Declined = b.Location == _empty && b.Authority == _empty
+	return b, nil
+}
+
+// LookupMTASTS resolves the MTA-STS policy indicator of domain
+func (r *Resolver) LookupMTASTS(domain string) (*MTASTS, error) {
+	domain = strings.TrimSuffix(domain, _dot)
+	raw, err := r.lookupTagRecord(_mtastsPrefix+domain, _mtastsVersion)
+	switch {
+	case err != nil:
+		return nil, errors.New(_errMTASTS + domain + _sep + err.Error())
+	case raw == _empty:
+		return nil, errors.New(_errMTASTS + domain + _sep + _noRecord)
+	}
+	m, err := ParseMTASTS(raw)
+	if err != nil {
+		return nil, err
+	}
+	m.Domain = domain
+	return m, nil
+}
+
+// ParseMTASTS ...
+func ParseMTASTS(raw string) (*MTASTS, error) {
+	tags, err := parseTags(raw, _mtastsVersion)
+	if err != nil {
+		return nil, errors.New(_errMTASTS + err.Error())
+	}
+	id := tags["id"]
+	if id == _empty || len(id) > 32 || strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != _empty {
+		return nil, errors.New(_errMTASTS + "invalid id: " + id)
+	}
+	return &MTASTS{ID: id, Raw: raw}, nil
+}
+
+// LookupTLSRPT resolves the SMTP TLS reporting record of domain
+func (r *Resolver) LookupTLSRPT(domain string) (*TLSRPT, error) {
+	domain = strings.TrimSuffix(domain, _dot)
+	raw, err := r.lookupTagRecord(_tlsrptPrefix+domain, _tlsrptVersion)
+	switch {
+	case err != nil:
+		return nil, errors.New(_errTLSRPT + domain + _sep + err.Error())
+	case raw == _empty:
+		return nil, errors.New(_errTLSRPT + domain + _sep + _noRecord)
+	}
+	t, err := ParseTLSRPT(raw)
+	if err != nil {
+		return nil, err
+	}
+	t.Domain = domain
+	return t, nil
+}
+
+// ParseTLSRPT ...
+func ParseTLSRPT(raw string) (*TLSRPT, error) {
+	tags, err := parseTags(raw, _tlsrptVersion)
+	if err != nil {
+		return nil, errors.New(_errTLSRPT + err.Error())
+	}
+	rua, err := parseURIs(tags["rua"], "mailto", "https")
+	if err != nil || len(rua) == 0 {
+		return nil, errors.New(_errTLSRPT + "invalid rua: " + tags["rua"])
+	}
+	return &TLSRPT{RUA: rua, Raw: raw}, nil
+}
+
+// OrgDomain returns the organizational domain (registered domain, public suffix list + 1 label)
+func OrgDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, _dot))
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+	return org
+}
+
+// lookupTXT returns the TXT records of name, segments joined, NXDOMAIN is no error
+func (r *Resolver) lookupTXT(name string) ([]string, error) {
+	rsp, err := r.resolve(name, dns.TypeTXT)
+	var rcodeErr *RcodeError
+	if err != nil && !(errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError) {
+		return nil, err
+	}
+	var txts []string
+	for _, rr := range rsp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, _empty))
+		}
+	}
+	return txts, nil
+}
+
+// lookupTagRecord returns the single TXT record of name starting with v=version, empty if none
+func (r *Resolver) lookupTagRecord(name, version string) (string, error) {
+	txts, err := r.lookupTXT(name)
+	if err != nil {
+		return _empty, err
+	}
+	var records []string
+	for _, txt := range txts {
+		if v, _, _ := strings.Cut(txt, ";"); strings.Join(strings.Fields(v), _empty) == "v="+version {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return _empty, nil
+	case 1:
+		return records[0], nil
+	}
+	return _empty, errMultipleRecords
+}
+
+// parseTags parses a tag=value; list, RFC 6376 section 3.2, version (optional) has to be the first tag
+func parseTags(raw, version string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for i, spec := range strings.Split(raw, ";") {
+		if strings.TrimSpace(spec) == _empty {
+			continue
+		}
+		tag, value, ok := strings.Cut(spec, "=")
+		tag, value = strings.TrimSpace(tag), strings.TrimSpace(value)
+		if !ok || tag == _empty {
+			return nil, errors.New("invalid tag: " + spec)
+		}
+		if _, dup := tags[tag]; dup {
+			return nil, errors.New("duplicate tag: " + tag)
+		}
+		if version != _empty && i == 0 && (tag != "v" || value != version) {
+			return nil, errors.New("first tag has to be v=" + version)
+		}
+		tags[tag] = value
+	}
+	if version != _empty && tags["v"] != version {
+		return nil, errors.New("missing v=" + version)
+	}
+	return tags, nil
+}
+
+// parseURIs parses a comma separated uri list, restricted to schemes
+func parseURIs(list string, schemes ...string) ([]string, error) {
+	var uris []string
+	for _, uri := range splitList(list, ",") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		ok := false
+		for _, scheme := range schemes {
+			ok = ok || strings.EqualFold(u.Scheme, scheme)
+		}
+		if !ok {
+			return nil, errors.New("unsupported uri scheme: " + uri)
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+// splitList splits and trims a list, empty entries dropped
+func splitList(list, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(list, sep) {
+		if item = strings.TrimSpace(item); item != _empty {
+			items = append(items, item)
+		}
+	}
+	return items
+}
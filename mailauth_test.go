@@ -0,0 +1,200 @@
+package dnsresolver_test
+
+import (
+	"crypto/ed25519"
+	"slices"
+	"strings"
+	"testing"
+
+	"paepcke.de/dnsresolver"
+)
+
+// _mailZone ...
+const _mailZone = `_dmarc		IN TXT	"v=DMARC1; p=reject; sp=quarantine; pct=50; adkim=s; rua=mailto:agg@example.com; ruf=mailto:fail@example.com; fo=1:d; ri=3600"
+_dmarc.own	IN TXT	"v=DMARC1; p=none"
+_dmarc.multi	IN TXT	"v=DMARC1; p=none"
+_dmarc.multi	IN TXT	"v=DMARC1; p=reject"
+_dmarc.other	IN TXT	"not a dmarc record"
+ed._domainkey	IN TXT	"v=DKIM1; k=ed25519; h=sha256; t=y:s; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+revoked._domainkey IN TXT "v=DKIM1; p="
+default._bimi	IN TXT	"v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem"
+declined._bimi	IN TXT	"v=BIMI1; l=; a="
+_mta-sts	IN TXT	"v=STSv1; id=20261019T000000"
+_smtp._tls	IN TXT	"v=TLSRPTv1; rua=mailto:tls@example.com,https://report.example.com/tls"
+`
+
+func TestLookupDMARC(t *testing.T) {
+	s := newServer(t, _testZone+_mailZone)
+	r := s.Resolver()
+	d, err := r.LookupDMARC("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Domain != "example.com" || d.OrgDomain || d.Policy != "reject" || d.SubdomainPolicy != "quarantine" ||
+		d.Percent != 50 || d.ADKIM != "s" || d.ASPF != "r" || d.FailureOptions != "1:d" || d.ReportInterval != 3600 ||
+		!slices.Equal(d.RUA, []string{"mailto:agg@example.com"}) || !slices.Equal(d.RUF, []string{"mailto:fail@example.com"}) {
+		t.Errorf("unexpected record: %+v", d)
+	}
+	if d, err = r.LookupDMARC("own.example.com."); err != nil || d.Domain != "own.example.com" || d.OrgDomain || d.Policy != "none" {
+		t.Errorf("own record: %+v %v", d, err)
+	}
+	for _, domain := range []string{"www.example.com", "other.example.com", "nx.deep.example.com"} {
+		if d, err = r.LookupDMARC(domain); err != nil || d.Domain != "example.com" || !d.OrgDomain || d.Policy != "reject" {
+			t.Errorf("%s: org domain fallback: %+v %v", domain, d, err)
+		}
+	}
+	if d, err = r.LookupDMARC("multi.example.com"); err == nil || !strings.Contains(err.Error(), "multiple records") {
+		t.Errorf("multiple records end the policy discovery: %+v %v", d, err)
+	}
+	if _, err = r.LookupDMARC("example.org"); err == nil {
+		t.Error("unknown zone: expected error")
+	}
+}
+
+func TestParseDMARC(t *testing.T) {
+	for _, tc := range []struct {
+		raw    string
+		policy string
+		sp     string
+	}{
+		{"v=DMARC1; p=quarantine", "quarantine", "quarantine"},
+		{"v=DMARC1;p=REJECT;sp=none", "reject", "none"},
+		{"v=DMARC1; rua=mailto:agg@example.com", "none", "none"},
+		{"v=DMARC1; p=bogus; rua=mailto:agg@example.com", "none", "none"},
+		{"v=DMARC1; p=reject; sp=bogus; rua=mailto:agg@example.com", "none", "none"},
+		{"v=DMARC1; p=bogus; sp=reject; rua=mailto:agg@example.com", "none", "none"},
+	} {
+		d, err := dnsresolver.ParseDMARC(tc.raw)
+		if err != nil || d.Policy != tc.policy || d.SubdomainPolicy != tc.sp {
+			t.Errorf("%q: got %+v %v, want p=%s sp=%s", tc.raw, d, err, tc.policy, tc.sp)
+		}
+	}
+	for _, raw := range []string{
+		"v=DMARC1",
+		"v=DMARC1; rua=",
+		"v=DMARC1; p=bogus",
+		"v=DMARC1; p=none; sp=bogus",
+		"v=DMARC1; p=bogus; rua=https://example.com/agg",
+		"p=none; v=DMARC1",
+		"v=DMARC2; p=none",
+		"v=DMARC1; p=none; p=reject",
+		"v=DMARC1; p=none; pct=101",
+		"v=DMARC1; p=none; adkim=x",
+		"v=DMARC1; p=none; ri=-1",
+		"v=DMARC1; p=none; fo=2",
+		"v=DMARC1; p=none; ruf=http://example.com",
+		"v=DMARC1; p",
+	} {
+		if d, err := dnsresolver.ParseDMARC(raw); err == nil {
+			t.Errorf("%q: expected error, got %+v", raw, d)
+		}
+	}
+}
+
+func TestLookupDKIM(t *testing.T) {
+	s := newServer(t, _testZone+_mailZone)
+	r := s.Resolver()
+	k, err := r.LookupDKIM("ed", "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Selector != "ed" || k.Domain != "example.com" || k.KeyType != "ed25519" || k.Revoked ||
+		!slices.Equal(k.HashAlgorithms, []string{"sha256"}) || !slices.Equal(k.Flags, []string{"y", "s"}) ||
+		!slices.Equal(k.ServiceTypes, []string{"*"}) {
+		t.Errorf("unexpected key: %+v", k)
+	}
+	if pub, ok := k.PublicKey.(ed25519.PublicKey); !ok || len(pub) != ed25519.PublicKeySize {
+		t.Errorf("unexpected public key %T", k.PublicKey)
+	}
+	if k, err = r.LookupDKIM("revoked", "example.com"); err != nil || !k.Revoked || k.PublicKey != nil {
+		t.Errorf("revoked key: %+v %v", k, err)
+	}
+	if _, err = r.LookupDKIM("missing", "example.com"); err == nil || !strings.Contains(err.Error(), "no record") {
+		t.Errorf("missing key: got %v", err)
+	}
+	for _, raw := range []string{
+		"v=DKIM2; p=",
+		"v=DKIM1; k=rsa",
+		"v=DKIM1; p=!!!",
+		"v=DKIM1; p=AAAA",
+		"v=DKIM1; k=ed25519; p=AAAA",
+		"v=DKIM1; k=dsa; p=AAAA",
+	} {
+		if k, err := dnsresolver.ParseDKIM(raw); err == nil {
+			t.Errorf("%q: expected error, got %+v", raw, k)
+		}
+	}
+}
+
+func TestLookupBIMI(t *testing.T) {
+	s := newServer(t, _testZone+_mailZone)
+	r := s.Resolver()
+	b, err := r.LookupBIMI("", "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Selector != "default" || b.Domain != "example.com" || !b.OrgDomain || b.Declined ||
+		b.Location != "https://example.com/logo.svg" || b.Authority != "https://example.com/vmc.pem" {
+		t.Errorf("unexpected record: %+v", b)
+	}
+	if b, err = r.LookupBIMI("declined", "example.com"); err != nil || !b.Declined || b.OrgDomain {
+		t.Errorf("declined record: %+v %v", b, err)
+	}
+	if _, err = r.LookupBIMI("missing", "example.com"); err == nil {
+		t.Error("missing record: expected error")
+	}
+	for _, raw := range []string{"v=BIMI1; l=http://example.com/logo.svg", "v=BIMI1; a=https://", "l=; v=BIMI1"} {
+		if b, err := dnsresolver.ParseBIMI(raw); err == nil {
+			t.Errorf("%q: expected error, got %+v", raw, b)
+		}
+	}
+}
+
+func TestLookupMTASTS(t *testing.T) {
+	s := newServer(t, _testZone+_mailZone)
+	r := s.Resolver()
+	m, err := r.LookupMTASTS("example.com")
+	if err != nil || m.Domain != "example.com" || m.ID != "20261019T000000" {
+		t.Errorf("unexpected record: %+v %v", m, err)
+	}
+	if _, err = r.LookupMTASTS("www.example.com"); err == nil {
+		t.Error("missing record: expected error")
+	}
+	for _, raw := range []string{"v=STSv1", "v=STSv1; id=", "v=STSv1; id=a-b", "v=STSv1; id=" + strings.Repeat("a", 33)} {
+		if m, err := dnsresolver.ParseMTASTS(raw); err == nil {
+			t.Errorf("%q: expected error, got %+v", raw, m)
+		}
+	}
+}
+
+func TestLookupTLSRPT(t *testing.T) {
+	s := newServer(t, _testZone+_mailZone)
+	r := s.Resolver()
+	tr, err := r.LookupTLSRPT("example.com")
+	if err != nil || tr.Domain != "example.com" ||
+		!slices.Equal(tr.RUA, []string{"mailto:tls@example.com", "https://report.example.com/tls"}) {
+		t.Errorf("unexpected record: %+v %v", tr, err)
+	}
+	if _, err = r.LookupTLSRPT("www.example.com"); err == nil {
+		t.Error("missing record: expected error")
+	}
+	for _, raw := range []string{"v=TLSRPTv1", "v=TLSRPTv1; rua=", "v=TLSRPTv1; rua=ftp://example.com"} {
+		if tr, err := dnsresolver.ParseTLSRPT(raw); err == nil {
+			t.Errorf("%q: expected error, got %+v", raw, tr)
+		}
+	}
+}
+
+func TestOrgDomain(t *testing.T) {
+	for in, want := range map[string]string{
+		"example.com":       "example.com",
+		"a.b.example.com.":  "example.com",
+		"WWW.Example.CO.UK": "example.co.uk",
+		"co.uk":             "co.uk",
+		"localhost":         "localhost",
+	} {
+		if got := dnsresolver.OrgDomain(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
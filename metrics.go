@@ -0,0 +1,225 @@
+package dnsresolver
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Metrics receives resolver events, see PrometheusMetrics for a ready to scrape adapter
+type Metrics interface {
+	// Query is called for every upstream exchange, err set on transport failures
+	Query(server, proto string, rType uint16, rcode int, rtt time.Duration, err error)
+	// Fallback is called for every udp to tcp retry
+	Fallback(server string)
+	// KeyPinFailure is called for every DoT connection rejected by the keypin check
+	KeyPinFailure(server string)
+	// Cache is called for every cache lookup
+	Cache(hit bool)
+	// ConnReuse is called for every query sent via an already open connection
+	ConnReuse(server string)
+}
+
+// noMetrics discards all events, used by resolvers without Metrics set
+type noMetrics struct{}
+
+// Query ...
+func (noMetrics) Query(string, string, uint16, int, time.Duration, error) {}
+
+// Fallback ...
+func (noMetrics) Fallback(string) {}
+
+// KeyPinFailure ...
+func (noMetrics) KeyPinFailure(string) {}
+
+// Cache ...
+func (noMetrics) Cache(bool) {}
+
+// ConnReuse ...
+func (noMetrics) ConnReuse(string) {}
+
+// metrics ...
+func (r *Resolver) metrics() Metrics {
+	if r.Metrics != nil {
+		return r.Metrics
+	}
+	return noMetrics{}
+}
+
+// const
+const (
+	_metricPrefix   = "dnsresolver_"
+	_metricQueries  = _metricPrefix + "queries_total"
+	_metricFailures = _metricPrefix + "query_failures_total"
+	_metricLatency  = _metricPrefix + "query_duration_seconds"
+	_metricFallback = _metricPrefix + "tcp_fallbacks_total"
+	_metricKeyPin   = _metricPrefix + "keypin_failures_total"
+	_metricCache    = _metricPrefix + "cache_lookups_total"
+	_metricReuse    = _metricPrefix + "conn_reuse_total"
+)
+
+// _latencyBuckets in seconds
+var _latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics collects resolver metrics and renders them in the prometheus
+// text exposition format, it can be shared between resolvers
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]uint64
+	histograms map[string]*histogram
+}
+
+// histogram ...
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewPrometheusMetrics ...
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{}
+}
+
+// Query ...
+func (p *PrometheusMetrics) Query(server, proto string, rType uint16, rcode int, rtt time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.inc(_metricFailures, labels("server", server, "proto", proto, "type", dns.TypeToString[rType]))
+		return
+	}
+	p.inc(_metricQueries, labels("server", server, "proto", proto, "type", dns.TypeToString[rType], "rcode", dns.RcodeToString[rcode]))
+	p.observe(labels("server", server, "proto", proto), rtt.Seconds())
+}
+
+// Fallback ...
+func (p *PrometheusMetrics) Fallback(server string) {
+	p.mu.Lock()
+	p.inc(_metricFallback, labels("server", server))
+	p.mu.Unlock()
+}
+
+// KeyPinFailure ...
+func (p *PrometheusMetrics) KeyPinFailure(server string) {
+	p.mu.Lock()
+	p.inc(_metricKeyPin, labels("server", server))
+	p.mu.Unlock()
+}
+
+// Cache ...
+func (p *PrometheusMetrics) Cache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	p.mu.Lock()
+	p.inc(_metricCache, labels("result", result))
+	p.mu.Unlock()
+}
+
+// ConnReuse ...
+func (p *PrometheusMetrics) ConnReuse(server string) {
+	p.mu.Lock()
+	p.inc(_metricReuse, labels("server", server))
+	p.mu.Unlock()
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var s strings.Builder
+	p.mu.Lock()
+	for _, name := range sortedKeys(p.counters) {
+		s.WriteString("# TYPE " + name + " counter\n")
+		series := p.counters[name]
+		for _, l := range sortedKeys(series) {
+			s.WriteString(name + l + " " + strconv.FormatUint(series[l], 10) + _linefeed)
+		}
+	}
+	if len(p.histograms) > 0 {
+		s.WriteString("# TYPE " + _metricLatency + " histogram\n")
+	}
+	for _, l := range sortedKeys(p.histograms) {
+		h, inner := p.histograms[l], strings.TrimSuffix(l, "}")
+		var cumulative uint64
+		for i, le := range _latencyBuckets {
+			cumulative += h.buckets[i]
+			s.WriteString(_metricLatency + "_bucket" + inner + ",le=\"" + strconv.FormatFloat(le, 'g', -1, 64) + "\"} " + strconv.FormatUint(cumulative, 10) + _linefeed)
+		}
+		s.WriteString(_metricLatency + "_bucket" + inner + ",le=\"+Inf\"} " + strconv.FormatUint(h.count, 10) + _linefeed)
+		s.WriteString(_metricLatency + "_sum" + l + " " + strconv.FormatFloat(h.sum, 'g', -1, 64) + _linefeed)
+		s.WriteString(_metricLatency + "_count" + l + " " + strconv.FormatUint(h.count, 10) + _linefeed)
+	}
+	p.mu.Unlock()
+	n, err := io.WriteString(w, s.String())
+	return int64(n), err
+}
+
+// ServeHTTP exposes the metrics as scrape target
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+// inc ...
+func (p *PrometheusMetrics) inc(name, labels string) {
+	if p.counters == nil {
+		p.counters = make(map[string]map[string]uint64)
+	}
+	if p.counters[name] == nil {
+		p.counters[name] = make(map[string]uint64)
+	}
+	p.counters[name][labels]++
+}
+
+// observe ...
+func (p *PrometheusMetrics) observe(labels string, v float64) {
+	if p.histograms == nil {
+		p.histograms = make(map[string]*histogram)
+	}
+	h, ok := p.histograms[labels]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(_latencyBuckets))}
+		p.histograms[labels] = h
+	}
+	h.count++
+	h.sum += v
+	for i, le := range _latencyBuckets {
+		if v <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+}
+
+// labels renders key value pairs as prometheus label set
+func labels(kv ...string) string {
+	var s strings.Builder
+	s.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			s.WriteByte(',')
+		}
+		s.WriteString(kv[i] + "=\"")
+		s.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, _linefeed, `\n`).Replace(kv[i+1]))
+		s.WriteByte('"')
+	}
+	s.WriteByte('}')
+	return s.String()
+}
+
+// sortedKeys ...
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
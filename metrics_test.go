@@ -0,0 +1,162 @@
+package dnsresolver_test
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// scrape renders m in the text exposition format
+func scrape(t *testing.T, m *dnsresolver.PrometheusMetrics) string {
+	t.Helper()
+	var s strings.Builder
+	if _, err := m.WriteTo(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s.String()
+}
+
+func TestMetricsQueries(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := s.Resolver()
+	r.Metrics = m
+	r.LookupAddr("www.example.com", dns.TypeA)
+	r.LookupAddr("www.example.com", dns.TypeA)
+	r.Lookup("nx.example.com", dns.TypeAAAA)
+	out := scrape(t, m)
+	for _, want := range []string{
+		"# TYPE dnsresolver_queries_total counter\n",
+		`dnsresolver_queries_total{server="` + s.Addr + `",proto="udp",type="A",rcode="NOERROR"} 2` + "\n",
+		`dnsresolver_queries_total{server="` + s.Addr + `",proto="udp",type="AAAA",rcode="NXDOMAIN"} 1` + "\n",
+		"# TYPE dnsresolver_query_duration_seconds histogram\n",
+		`dnsresolver_query_duration_seconds_bucket{server="` + s.Addr + `",proto="udp",le="+Inf"} 3` + "\n",
+		`dnsresolver_query_duration_seconds_count{server="` + s.Addr + `",proto="udp"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsFailures(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := s.Resolver()
+	r.Metrics, r.NoTCP = m, true
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Malformed: true})
+	if _, err := r.LookupAddr("www.example.com", dns.TypeA); err == nil {
+		t.Fatal("malformed reply: want error")
+	}
+	want := `dnsresolver_query_failures_total{server="` + s.Addr + `",proto="udp",type="A"} 1` + "\n"
+	if out := scrape(t, m); !strings.Contains(out, want) {
+		t.Errorf("missing %q in\n%s", want, out)
+	}
+}
+
+func TestMetricsFallback(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := s.Resolver()
+	r.Metrics = m
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Truncate: true})
+	if addrs, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil || len(addrs) != 1 {
+		t.Fatalf("got %v %v", addrs, err)
+	}
+	out := scrape(t, m)
+	for _, want := range []string{
+		`dnsresolver_tcp_fallbacks_total{server="` + s.Addr + `"} 1` + "\n",
+		`dnsresolver_queries_total{server="` + s.Addr + `",proto="tcp",type="A",rcode="NOERROR"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsCache(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := cachedResolver(s, dnsresolver.NewCache())
+	r.Metrics = m
+	for range 3 {
+		r.LookupAddr("www.example.com", dns.TypeA)
+	}
+	out := scrape(t, m)
+	for _, want := range []string{
+		`dnsresolver_cache_lookups_total{result="hit"} 2` + "\n",
+		`dnsresolver_cache_lookups_total{result="miss"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := dnsresolver.NewPrometheusMetrics()
+	m.Fallback(`a"b\c`)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	if want := `dnsresolver_tcp_fallbacks_total{server="a\"b\\c"} 1` + "\n"; w.Body.String() != "# TYPE dnsresolver_tcp_fallbacks_total counter\n"+want {
+		t.Errorf("got\n%s", w.Body.String())
+	}
+}
+
+func TestMetricsConnReuse(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := s.Resolver()
+	r.Metrics = m
+	b := dnsresolver.NewBulk(r)
+	b.Workers = 1
+	for res := range b.Run(slices.Values([]dnsresolver.BulkQuery{
+		{Name: "www.example.com", Type: dns.TypeA},
+		{Name: "www.example.com", Type: dns.TypeAAAA},
+		{Name: "mail.example.com", Type: dns.TypeA},
+	})) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+	}
+	want := `dnsresolver_conn_reuse_total{server="` + s.Addr + `"} 2` + "\n"
+	if out := scrape(t, m); !strings.Contains(out, want) {
+		t.Errorf("missing %q in\n%s", want, out)
+	}
+}
+
+func TestMetricsConnReuseExchangeSeq(t *testing.T) {
+	s := newServer(t, _testZone)
+	m := dnsresolver.NewPrometheusMetrics()
+	r := s.Resolver()
+	r.Metrics = m
+	types := []uint16{
+		dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeMX, dns.TypeNS, dns.TypeSOA,
+		dns.TypeCNAME, dns.TypeSRV, dns.TypePTR, dns.TypeCAA, dns.TypeHTTPS, dns.TypeSVCB,
+	}
+	for res, err := range r.ExchangeSeq("www.example.com", types) {
+		if err != nil {
+			t.Fatalf("%s: %v", dns.TypeToString[res.Type], err)
+		}
+	}
+	prefix := `dnsresolver_conn_reuse_total{server="` + s.Addr + `"} `
+	out := scrape(t, m)
+	i := strings.Index(out, prefix)
+	if i < 0 {
+		t.Fatalf("missing %q in\n%s", prefix, out)
+	}
+	line, _, _ := strings.Cut(out[i+len(prefix):], "\n")
+	// at most 8 workers dial, every further query reuses a worker connection
+	if n, err := strconv.Atoi(line); err != nil || n < len(types)-8 {
+		t.Errorf("got %q reused queries, want at least %d", line, len(types)-8)
+	}
+}
@@ -0,0 +1,131 @@
+package dnsresolver
+
+import (
+	"errors"
+	"net/netip"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_smtpPort = 25
+	_errMX    = "[dnsinfo] [mx] "
+)
+
+// DANEPolicy is the RFC 7672 TLS policy of a mail exchanger
+type DANEPolicy int
+
+// DANE policies
+const (
+	// DANENone no secure TLSA records, opportunistic TLS
+	DANENone DANEPolicy = iota
+	// DANEUsable authenticate the server via the usable TLSA records (DANE-TA, DANE-EE)
+	DANEUsable
+	// DANEUnusable TLSA records present but none usable, or the lookup failed: mandatory
+	// TLS without authentication, defer delivery when Err is set
+	DANEUnusable
+)
+
+// MX is the mail routing of a domain, see Resolver.LookupMX
+type MX struct {
+	Domain string
+	// Hosts by preference, equal preferences keep the server order
+	Hosts []MXHost
+	// Null MX (RFC 7505), the domain accepts no mail, Hosts is empty
+	Null bool
+	// Implicit no MX records, the domain itself is the only host (RFC 5321 section 5.1)
+	Implicit bool
+	// Secure MX answer, DNSSEC authenticated (requires Resolver.DNSSEC)
+	Secure bool
+}
+
+// MXHost is a mail exchanger with its addresses and DANE policy
+type MXHost struct {
+	Host       string
+	Preference uint16
+	Addrs      []netip.Addr
+	DANE       DANEPolicy
+	// TLSA usable records, for VerifyDANE
+	TLSA []*dns.TLSA
+	// Err of the address or TLSA lookup
+	Err error
+}
+
+// LookupMX resolves the mail exchangers of domain by preference, with their addresses and,
+// for secure answers (see Resolver.DNSSEC), the DANE policy of each host
+func (r *Resolver) LookupMX(domain string) (*MX, error) {
+	domain = dns.Fqdn(domain)
+	mx := &MX{Domain: domain}
+	rsp, err := r.resolve(domain, dns.TypeMX)
+	if err != nil {
+		return nil, errors.New(_errMX + domain + _sep + err.Error())
+	}
+	mx.Secure = r.DNSSEC && rsp.AuthenticatedData
+	for _, rr := range rsp.Answer {
+		if v, ok := rr.(*dns.MX); ok {
+			mx.Hosts = append(mx.Hosts, MXHost{Host: v.Mx, Preference: v.Preference})
+		}
+	}
+	switch {
+	case len(mx.Hosts) == 1 && mx.Hosts[0].Host == _dot:
+		mx.Hosts, mx.Null = nil, true
+		return mx, nil
+	case len(mx.Hosts) == 0:
+		mx.Hosts, mx.Implicit = []MXHost{{Host: domain}}, true
+	}
+	sort.SliceStable(mx.Hosts, func(i, j int) bool { return mx.Hosts[i].Preference < mx.Hosts[j].Preference })
+	for i := range mx.Hosts {
+		r.mxHost(&mx.Hosts[i], mx.Secure)
+	}
+	if mx.Implicit && mx.Hosts[0].Err != nil {
+		return nil, errors.New(_errMX + domain + _sep + _noAnswer)
+	}
+	return mx, nil
+}
+
+// mxHost resolves the addresses and, for secure MX and address rrsets, the TLSA policy of host
+func (r *Resolver) mxHost(h *MXHost, secure bool) {
+	var addrsSecure bool
+	if h.Addrs, addrsSecure, h.Err = r.resolveAddrsSecure(h.Host, []uint16{dns.TypeAAAA, dns.TypeA}); h.Err != nil {
+		return
+	}
+	if !secure || !addrsSecure {
+		return // RFC 7672 section 2.2.1 and 2.2.2, no DANE for insecure MX or address rrsets
+	}
+	records, tlsaSecure, err := r.lookupTLSA(tlsaName(h.Host, _smtpPort, _tcp))
+	switch {
+	case err != nil:
+		h.DANE, h.Err = DANEUnusable, err
+	case !tlsaSecure || len(records) == 0:
+		h.DANE = DANENone
+	default:
+		for _, tlsa := range records {
+			if smtpUsable(tlsa) {
+				h.TLSA = append(h.TLSA, tlsa)
+			}
+		}
+		h.DANE = DANEUnusable
+		if len(h.TLSA) > 0 {
+			h.DANE = DANEUsable
+		}
+	}
+}
+
+// smtpUsable reports DANE-TA and DANE-EE records with known parameters, RFC 7672 section 3.1
+func smtpUsable(tlsa *dns.TLSA) bool {
+	return (tlsa.Usage == _daneUsageDANETA || tlsa.Usage == _daneUsageDANEEE) &&
+		tlsa.Selector <= _daneSelSPKI && tlsa.MatchingType <= _daneMatchSHA512
+}
+
+// String ...
+func (p DANEPolicy) String() string {
+	switch p {
+	case DANEUsable:
+		return "usable"
+	case DANEUnusable:
+		return "unusable"
+	}
+	return "none"
+}
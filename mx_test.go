@@ -0,0 +1,138 @@
+package dnsresolver_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _mxZone ...
+const _mxZone = `mx		IN MX	20 mx2
+mx		IN MX	10 mx1
+mx		IN MX	30 mx3
+mx1		IN A	192.0.2.31
+mx2		IN AAAA	2001:db8::32
+mx3		IN A	192.0.2.33
+_25._tcp.mx1	IN TLSA	3 1 1 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+_25._tcp.mx2	IN TLSA	1 1 1 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+null		IN MX	0 .
+implicit	IN A	192.0.2.40
+noaddr		IN TXT	"no mail"
+`
+
+// adWriter marks all answers as DNSSEC authenticated
+type adWriter struct {
+	dns.ResponseWriter
+}
+
+// WriteMsg ...
+func (w adWriter) WriteMsg(m *dns.Msg) error {
+	m.AuthenticatedData = true
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// newSecureServer serves zone with the AD bit set, as a validating resolver would
+func newSecureServer(t *testing.T, zone string) (*dnsresolvertest.Server, *dnsresolvertest.Server) {
+	t.Helper()
+	inner := newServer(t, zone)
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		inner.ServeDNS(adWriter{w}, m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, inner
+}
+
+func TestLookupMX(t *testing.T) {
+	s := newServer(t, _testZone+_mxZone)
+	mx, err := s.Resolver().LookupMX("mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mx.Null || mx.Implicit || mx.Secure || len(mx.Hosts) != 3 {
+		t.Fatalf("got %+v", mx)
+	}
+	for i, want := range []string{"mx1.example.com.", "mx2.example.com.", "mx3.example.com."} {
+		h := mx.Hosts[i]
+		if h.Host != want || len(h.Addrs) != 1 || h.Err != nil || h.DANE != dnsresolver.DANENone {
+			t.Errorf("host %d: got %+v, want %s", i, h, want)
+		}
+	}
+}
+
+func TestLookupMXNullAndImplicit(t *testing.T) {
+	s := newServer(t, _testZone+_mxZone)
+	r := s.Resolver()
+	if mx, err := r.LookupMX("null.example.com"); err != nil || !mx.Null || len(mx.Hosts) != 0 {
+		t.Errorf("null: got %+v %v", mx, err)
+	}
+	mx, err := r.LookupMX("implicit.example.com")
+	if err != nil || !mx.Implicit || len(mx.Hosts) != 1 || mx.Hosts[0].Host != "implicit.example.com." || mx.Hosts[0].Addrs[0].String() != "192.0.2.40" {
+		t.Errorf("implicit: got %+v %v", mx, err)
+	}
+	for _, domain := range []string{"nx.example.com", "noaddr.example.com"} {
+		if _, err := r.LookupMX(domain); err == nil {
+			t.Errorf("%s: want error", domain)
+		}
+	}
+}
+
+func TestLookupMXDANE(t *testing.T) {
+	s, inner := newSecureServer(t, _testZone+_mxZone)
+	r := s.Resolver()
+	r.DNSSEC = true
+	inner.SetFault("_25._tcp.mx3.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	mx, err := r.LookupMX("mx.example.com")
+	if err != nil || !mx.Secure || len(mx.Hosts) != 3 {
+		t.Fatalf("got %+v %v", mx, err)
+	}
+	for i, want := range []struct {
+		policy dnsresolver.DANEPolicy
+		tlsa   int
+		err    bool
+	}{
+		{dnsresolver.DANEUsable, 1, false},   // DANE-EE
+		{dnsresolver.DANEUnusable, 0, false}, // PKIX-EE only
+		{dnsresolver.DANEUnusable, 0, true},  // TLSA lookup failed
+	} {
+		h := mx.Hosts[i]
+		if h.DANE != want.policy || len(h.TLSA) != want.tlsa || (h.Err != nil) != want.err {
+			t.Errorf("%s: got %s %d %v, want %s", h.Host, h.DANE, len(h.TLSA), h.Err, want.policy)
+		}
+	}
+	r.DNSSEC = false // insecure MX rrset, no DANE
+	if mx, err := r.LookupMX("mx.example.com"); err != nil || mx.Secure || mx.Hosts[0].DANE != dnsresolver.DANENone {
+		t.Errorf("insecure: got %+v %v", mx, err)
+	}
+}
+
+func TestLookupMXDANEInsecureAddrs(t *testing.T) {
+	inner := newServer(t, _testZone+_mxZone)
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		if q := m.Question[0]; q.Name == "mx1.example.com." && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) {
+			inner.ServeDNS(w, m) // insecure address rrset, AD unset
+			return
+		}
+		inner.ServeDNS(adWriter{w}, m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	r := s.Resolver()
+	r.DNSSEC = true
+	mx, err := r.LookupMX("mx.example.com")
+	if err != nil || !mx.Secure || len(mx.Hosts) != 3 {
+		t.Fatalf("got %+v %v", mx, err)
+	}
+	if h := mx.Hosts[0]; h.Host != "mx1.example.com." || len(h.Addrs) != 1 || h.DANE != dnsresolver.DANENone || len(h.TLSA) != 0 || h.Err != nil {
+		t.Errorf("%s: got %s %d %v, want none for insecure addresses", h.Host, h.DANE, len(h.TLSA), h.Err)
+	}
+	if h := mx.Hosts[1]; h.DANE != dnsresolver.DANEUnusable {
+		t.Errorf("%s: got %s, want unusable", h.Host, h.DANE)
+	}
+}
@@ -43,15 +43,13 @@ func resolverProviderName(name string, dot bool) *Resolver {
 	return resolver
 }
 
-// proto ...
+// proto returns the network of the dns.Conn, free of side effects (called concurrently)
 func (r *Resolver) proto() string {
 	prefix, suffix := _udp, _empty
 	switch {
 	case r.NoUDP && r.NoTCP && !r.DoT:
 		panic("[dnsinfo] [resolver] [internal] [error] [unable to continue] udp, tcp and DoT(tcp-tls) disabled" + r.Server)
 	case r.DoT:
-		r.NoTCP = true
-		r.NoUDP = true
 		prefix = _tcptls
 	case r.NoUDP:
 		prefix = _tcp
@@ -1,35 +1,33 @@
 package dnsresolver
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"iter"
 	"net/netip"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
 // const
 const (
-	_dnsPort = ":53"
-	_dotPort = ":853"
+	_dnsPort    = ":53"
+	_dotPort    = ":853"
+	_dnsPortNum = 53  // _dnsPort as number
+	_dotPortNum = 853 // _dotPort as number
+	_seqWorkers = 8   // concurrent queries of exchangeSeq
 )
 
 // var
 var (
-	_emptyAddr           = netip.Addr{}
-	_emptyAddrs          = []netip.Addr{}
-	_emptyStrings        = []string{}
-	resolverReachableMap sync.Map
+	_emptyAddr    = netip.Addr{}
+	_emptyAddrs   = []netip.Addr{}
+	_emptyStrings = []string{}
 )
 
-// response ...
-type response struct {
-	rtype   uint16
-	raw     string
-	summary string
-}
-
 // rTypeAll returns a list of all DNS Record Types
 func rTypeAll() []uint16 {
 	all := make([]uint16, len(dns.TypeToString))
@@ -39,175 +37,377 @@ func rTypeAll() []uint16 {
 	return all
 }
 
-// exchangeAll
+// isQueryType reports if rType can be asked for, ANY, bogous and non-request types can not
+func isQueryType(rType uint16) bool {
+	switch rType {
+	case dns.TypeANY:
+		return false
+	case dns.TypeNone, dns.TypeReserved, dns.TypeNULL, dns.TypeUNSPEC:
+		return false
+	case dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTKEY, dns.TypeTSIG, dns.TypeAXFR, dns.TypeIXFR:
+		return false
+	}
+	return true
+}
+
+// exchangeAll collects the results of exchangeSeq, types without content are left out
 func (r *Resolver) exchangeAll(query string, raw, summary bool, rTypes []uint16) (*Answer, error) {
-	var (
-		bg              sync.WaitGroup
-		responseChannel = make(chan response, 25)
-	)
-	go func() {
-		var err error
-		var conn *dns.Conn
-		proto := r.proto()
-		if r.DoT {
-			if r.TLSKeyPin != _empty && r.TLSConfig.VerifyConnection == nil { // sanitycheck, gate - do not recover
-				panic("[dnsinfo] [internal] [security] [keypin:active] no tlsconfig.VerifyConnection func set")
-			}
-			conn, err = dns.DialTimeoutWithTLS(proto, r.Server, r.TLSConfig, r.Timeout)
-		} else {
-			conn, err = dns.DialTimeout(proto, r.Server, r.Timeout)
+	answer := &Answer{
+		Raw:     make(map[uint16]string, len(rTypes)),
+		Summary: make(map[uint16]string, len(rTypes)),
+		Policy:  make(map[uint16]*PolicyHit),
+		Records: make(map[uint16][]dns.RR),
+	}
+	for res, err := range r.exchangeSeq(query, rTypes) {
+		var policy string
+		if res.Policy != nil {
+			policy = res.Policy.String() + _linefeed
+			answer.Policy[res.Type] = res.Policy
 		}
 		if err != nil {
-			responseChannel <- response{dns.TypeNone, _empty, _rfail + err.Error() + _linefeed}
-			close(responseChannel)
-			return
+			answer.Raw[res.Type] = removeEmptyLines(res.Msg.String())
+			answer.Summary[res.Type] = policy + _rfail + err.Error() + _linefeed
+			continue
 		}
-		for _, rType := range rTypes {
-			rType := rType
-			switch rType {
-			case dns.TypeANY:
-				continue // skip ANY
-			case dns.TypeNone, dns.TypeReserved, dns.TypeNULL, dns.TypeUNSPEC:
-				continue // skip bogous types
-			case dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTKEY, dns.TypeTSIG, dns.TypeAXFR, dns.TypeIXFR:
-				continue // skip non-request types
+		if len(res.Msg.Answer) > 0 {
+			answer.Records[res.Type] = res.Msg.Answer
+		}
+		send := res.Policy != nil
+		var rawdat string
+		if raw && len(res.Msg.Answer) > 0 {
+			send = true
+			rawdat = removeEmptyLines(res.Msg.String())
+		}
+		var sum strings.Builder
+		sum.WriteString(policy)
+		if summary {
+			for _, rr := range res.Answer {
+				send = true
+				sum.WriteString(_dns)
+				sum.WriteString(dns.TypeToString[res.Type])
+				sum.WriteString(_sep)
+				sum.WriteString(rr.String())
+				sum.WriteString(_linefeed)
 			}
-			bg.Add(1)
-			r.querySend(conn, proto, query, rType, raw, summary, &responseChannel, &bg)
 		}
-		bg.Wait()
-		close(responseChannel)
-	}()
-	rawMap := make(map[uint16]string, len(rTypes))
-	summaryMap := make(map[uint16]string, len(rTypes))
-	for r := range responseChannel {
-		switch r.rtype {
-		case dns.TypeNone:
-		case dns.TypeReserved:
+		if send {
+			answer.Raw[res.Type], answer.Summary[res.Type] = rawdat, sum.String()
 		}
-		rawMap[r.rtype] = r.raw
-		summaryMap[r.rtype] = r.summary
 	}
-	return &Answer{rawMap, summaryMap}, nil
+	return answer, nil
 }
 
-// querySend ...
-func (r *Resolver) querySend(conn *dns.Conn, proto, query string, rType uint16, raw, summary bool, responseChannel *chan response, bg *sync.WaitGroup) {
-	defer bg.Done()
-	// _,_ = conn, proto
-	rsp, err := r.resolveViaConn(conn, proto, query, rType)
-	if err != nil {
-		*responseChannel <- response{rType, removeEmptyLines(rsp.String()), _rfail + err.Error() + _linefeed}
-		return
-	}
-	sendResponse := false
-	var rawdat strings.Builder
-	if len(rsp.Answer) > 0 {
-		if raw {
-			sendResponse = true
-			rawdat.WriteString(removeEmptyLines(rsp.String()))
+// exchangeSeq resolves the types concurrently via resolveResult (sources, policy, cache and
+// server failover), results are yielded in completion order, see ExchangeSeq
+func (r *Resolver) exchangeSeq(query string, rTypes []uint16) iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		var types []uint16
+		for _, rType := range rTypes {
+			if isQueryType(rType) {
+				types = append(types, rType)
+			}
 		}
-		var sum strings.Builder
-		for _, a := range rsp.Answer {
-			line := a.String()
-			s := strings.Fields(line)
-			if len(s) > 3 && s[3] == dns.TypeToString[rType] {
-				if summary {
-					sendResponse = true
-					sum.WriteString(_dns)
-					sum.WriteString(dns.TypeToString[rType])
-					sum.WriteString(_sep)
-					sum.WriteString(line)
-					sum.WriteString(_linefeed)
+		type outcome struct {
+			res Result
+			err error
+		}
+		jobs, results, done := make(chan uint16), make(chan outcome), make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(jobs)
+			for _, rType := range types {
+				select {
+				case jobs <- rType:
+				case <-done:
+					return
 				}
 			}
+		}()
+		for range min(_seqWorkers, len(types)) {
+			go func() {
+				worker := *r
+				worker.pool = &connPool{}
+				defer worker.pool.close()
+				for rType := range jobs {
+					res, err := worker.resolveResult(query, rType)
+					res.Err = err
+					if err == nil {
+						for _, rr := range res.Msg.Answer {
+							if rr.Header().Rrtype == rType {
+								res.Answer = append(res.Answer, rr)
+							}
+						}
+					}
+					select {
+					case results <- outcome{res, err}:
+					case <-done:
+						return
+					}
+				}
+			}()
 		}
-		if sendResponse {
-			*responseChannel <- response{rType, rawdat.String(), sum.String()}
+		for range types {
+			o := <-results
+			if !yield(o.res, o.err) {
+				return
+			}
 		}
 	}
 }
 
-// resolve ...
+// resolve returns the response of resolveResult
 func (r *Resolver) resolve(query string, rType uint16) (*dns.Msg, error) {
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(query), rType)
+	res, err := r.resolveResult(query, rType)
+	return res.Msg, err
+}
+
+// resolveResult walks the configured sources, nil sources query the dns server only, transport
+// failures and NXDOMAIN hand over to the next source, the last error is returned when all fail
+func (r *Resolver) resolveResult(query string, rType uint16) (Result, error) {
+	if len(r.Sources) == 0 {
+		return r.resolveUpstream(query, rType)
+	}
+	res, err := Result{Query: query, Type: rType, Msg: &dns.Msg{}}, errors.New(_errLookup+_noSource)
+	for _, src := range r.Sources {
+		if src == SourceDNS {
+			if res, err = r.resolveUpstream(query, rType); !isSourceMiss(err) {
+				return res, err
+			}
+			continue
+		}
+		start := time.Now()
+		if msg, ok := lookupSource(src, query, rType); ok {
+			res = Result{Query: query, Type: rType, Msg: msg, Proto: _local, RTT: time.Since(start)}
+			if msg.Rcode == dns.RcodeSuccess {
+				return res, nil
+			}
+			if err = rcodeError(query, rType, msg.Rcode, _empty, _local, false); !isSourceMiss(err) {
+				return res, err
+			}
+		}
+	}
+	return res, err
+}
+
+// isSourceMiss reports errors to continue with the next source (nsswitch NOTFOUND, UNAVAIL, TRYAGAIN)
+func isSourceMiss(err error) bool {
+	var rcodeErr *RcodeError
+	return isUpstreamFailure(err) || (errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError)
+}
+
+// resolveUpstream resolves via dns, subject to the response policy
+func (r *Resolver) resolveUpstream(query string, rType uint16) (Result, error) {
+	start := time.Now()
+	var server, proto string
+	rsp, hit, err := r.applyPolicy(query, rType, func() (rsp *dns.Msg, err error) {
+		rsp, server, proto, err = r.resolveDNS(query, rType)
+		return rsp, err
+	})
+	if proto == _empty {
+		proto = r.proto()
+	}
+	return Result{Query: query, Type: rType, Msg: rsp, Server: server, Proto: proto, RTT: time.Since(start), Policy: hit}, err
+}
+
+// resolveDNS queries the dns server, answers from and feeds the cache when configured,
+// returns the server and protocol that answered
+func (r *Resolver) resolveDNS(query string, rType uint16) (*dns.Msg, string, string, error) {
+	if r.Cache == nil {
+		return r.exchangeDNS(query, rType)
+	}
+	return r.resolveCached(query, rType)
+}
+
+// exchangeDNS asks the first usable server, moves on to the next one on transport failures,
+// returns the server and protocol that answered last
+func (r *Resolver) exchangeDNS(query string, rType uint16) (*dns.Msg, string, string, error) {
+	h := r.health()
+	servers := h.usable(r.servers())
+	if len(servers) == 0 {
+		return &dns.Msg{}, _empty, _empty, errors.New(_errServerDown + strings.Join(r.servers(), _sep))
+	}
+	var rsp *dns.Msg
+	var server, proto string
 	var err error
-	var conn *dns.Conn
-	proto := r.proto()
-	if r.DoT {
-		if r.TLSKeyPin != _empty && r.TLSConfig.VerifyConnection == nil { // sanitycheck
-			panic("[dnsinfo] [internal] [security] [keypin:active] no tlsconfig.VerifyConnection func set")
+	for _, server = range servers {
+		rsp, proto, err = r.exchangeServer(server, query, rType)
+		var rcodeErr *RcodeError
+		var tsigErr *TSIGError
+		if err == nil || errors.As(err, &rcodeErr) || errors.As(err, &tsigErr) {
+			h.report(server, nil)
+			return rsp, server, proto, err
 		}
-		conn, err = dns.DialTimeoutWithTLS(proto, r.Server, r.TLSConfig, r.Timeout)
-	} else {
-		conn, err = dns.DialTimeout(proto, r.Server, r.Timeout)
+		h.report(server, err)
 	}
-	if err != nil {
-		return &dns.Msg{}, errors.New(_errLookup + r.Server + _sep + proto + _sep + err.Error())
+	return rsp, server, proto, err
+}
+
+// exchangeServer returns the response and the protocol it came over
+func (r *Resolver) exchangeServer(server, query string, rType uint16) (*dns.Msg, string, error) {
+	if r.pool != nil {
+		return r.pool.exchange(r, server, query, rType)
 	}
-	dnsClient := new(dns.Client)
-	rsp, _, err := dnsClient.ExchangeWithConn(msg, conn)
+	proto := r.proto()
+	conn, err := r.dial(server, proto)
 	if err != nil {
-		if proto == _udp && !r.NoTCP { // udp faild, retry tcp
-			proto = _tcp
-			conn, err = dns.DialTimeout(proto, r.Server, r.Timeout)
-			if err != nil {
-				q := dns.TypeToString[rType]
-				return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
-			}
-			rsp, _, err = dnsClient.ExchangeWithConn(msg, conn)
+		return &dns.Msg{}, proto, errors.New(_errLookup + server + _sep + proto + _sep + err.Error())
+	}
+	defer conn.Close()
+	return r.resolveViaConn(conn, proto, server, query, rType)
+}
+
+// connPool keeps one open connection, redialed on server change and after transport errors
+type connPool struct {
+	conn   *dns.Conn
+	proto  string
+	server string
+}
+
+// exchange resolves via the pooled connection, a failed reused connection is redialed once
+func (p *connPool) exchange(r *Resolver, server, query string, rType uint16) (*dns.Msg, string, error) {
+	if p.server != server {
+		p.close()
+	}
+	for {
+		reused := p.conn != nil
+		if reused {
+			r.metrics().ConnReuse(server)
+		} else {
+			proto := r.proto()
+			conn, err := r.dial(server, proto)
 			if err != nil {
-				q := dns.TypeToString[rType]
-				return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
-			}
-			if rsp.Rcode != dns.RcodeSuccess {
-				q := dns.TypeToString[rType]
-				return rsp, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + dns.RcodeToString[rsp.Rcode])
+				return &dns.Msg{}, proto, errors.New(_errLookup + server + _sep + proto + _sep + err.Error())
 			}
-			return rsp, nil
+			p.conn, p.proto, p.server = conn, proto, server
+		}
+		rsp, proto, err := r.resolveViaConn(p.conn, p.proto, server, query, rType)
+		var rcodeErr *RcodeError
+		var tsigErr *TSIGError
+		if err == nil || errors.As(err, &rcodeErr) || errors.As(err, &tsigErr) {
+			return rsp, proto, err
+		}
+		p.close()
+		if !reused {
+			return rsp, proto, err
 		}
-		q := dns.TypeToString[rType]
-		return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
 	}
-	if rsp.Rcode != dns.RcodeSuccess {
-		return rsp, errors.New(_errLookup + r.Server + _sep + proto + _sep + dns.RcodeToString[rsp.Rcode])
+}
+
+// close ...
+func (p *connPool) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// dial ...
+func (r *Resolver) dial(server, proto string) (*dns.Conn, error) {
+	if r.DoT {
+		config := r.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if r.TLSKeyPin != _empty && config.VerifyConnection == nil { // sanitycheck, gate - do not recover
+			panic("[dnsinfo] [internal] [security] [keypin:active] no tlsconfig.VerifyConnection func set")
+		}
+		conn, err := dns.DialTimeoutWithTLS(proto, server, config, r.Timeout)
+		if errors.Is(err, errKeyPin) {
+			r.metrics().KeyPinFailure(server)
+		}
+		return conn, err
 	}
-	return rsp, nil
+	return dns.DialTimeout(proto, server, r.Timeout)
+}
+
+// servers returns Server followed by the fallback Servers
+func (r *Resolver) servers() []string {
+	return append([]string{r.Server}, r.Servers...)
 }
 
-// resolve ...
-func (r *Resolver) resolveViaConn(conn *dns.Conn, proto, query string, rType uint16) (*dns.Msg, error) {
+// server returns the first usable server
+func (r *Resolver) server() string {
+	if servers := r.health().usable(r.servers()); len(servers) > 0 {
+		return servers[0]
+	}
+	return r.Server
+}
+
+// resolveViaConn returns the response and the protocol it came over, tcp after a udp fallback
+func (r *Resolver) resolveViaConn(conn *dns.Conn, proto, server, query string, rType uint16) (*dns.Msg, string, error) {
 	msg := new(dns.Msg)
 	msg.SetQuestion(dns.Fqdn(query), rType)
-	dnsClient := new(dns.Client)
-	rsp, _, err := dnsClient.ExchangeWithConn(msg, conn)
-	if err != nil {
-		if proto == _udp && !r.NoTCP { // udp faild, retry tcp
+	if r.DNSSEC {
+		msg.SetEdns0(dns.DefaultMsgSize, true)
+		msg.AuthenticatedData = true
+	}
+	rsp, err := r.exchangeConn(conn, proto, server, msg)
+	var tsigErr *TSIGError
+	if errors.As(err, &tsigErr) {
+		return &dns.Msg{}, proto, err
+	}
+	if err != nil || rsp.Truncated {
+		if proto == _udp && !r.NoTCP { // udp faild or truncated, retry tcp
+			r.metrics().Fallback(server)
 			proto = _tcp
-			conn, err = dns.DialTimeout(proto, r.Server, r.Timeout)
+			conn, err = dns.DialTimeout(proto, server, r.Timeout)
 			if err != nil {
 				q := dns.TypeToString[rType]
-				return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
+				return &dns.Msg{}, proto, errors.New(q + _errLookup + server + _sep + proto + _sep + err.Error())
 			}
-			rsp, _, err = dnsClient.ExchangeWithConn(msg, conn)
+			defer conn.Close()
+			rsp, err = r.exchangeConn(conn, proto, server, msg)
 			if err != nil {
 				q := dns.TypeToString[rType]
-				return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
+				return &dns.Msg{}, proto, errors.New(q + _errLookup + server + _sep + proto + _sep + err.Error())
 			}
 			if rsp.Rcode != dns.RcodeSuccess {
-				q := dns.TypeToString[rType]
-				return rsp, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + dns.RcodeToString[rsp.Rcode])
+				return rsp, proto, rcodeError(query, rType, rsp.Rcode, server, proto, false)
 			}
-			return rsp, nil
+			return rsp, proto, nil
+		}
+		if err != nil {
+			q := dns.TypeToString[rType]
+			return &dns.Msg{}, proto, errors.New(q + _errLookup + server + _sep + proto + _sep + err.Error())
 		}
-		q := dns.TypeToString[rType]
-		return &dns.Msg{}, errors.New(q + _errLookup + r.Server + _sep + proto + _sep + err.Error())
 	}
 	if rsp.Rcode != dns.RcodeSuccess {
-		return rsp, errors.New(_errLookup + r.Server + _sep + proto + _sep + dns.RcodeToString[rsp.Rcode])
+		return rsp, proto, rcodeError(query, rType, rsp.Rcode, server, proto, false)
+	}
+	return rsp, proto, nil
+}
+
+// exchangeConn sends msg via conn, feeds metrics and dnstap
+func (r *Resolver) exchangeConn(conn *dns.Conn, proto, server string, msg *dns.Msg) (*dns.Msg, error) {
+	dnsClient := &dns.Client{Timeout: r.Timeout}
+	if r.TSIG != nil {
+		dnsClient.TsigSecret = r.TSIG.secret()
+		r.TSIG.sign(msg)
+	}
+	tq := time.Now()
+	if r.Dnstap != nil {
+		r.Dnstap.query(proto, conn.LocalAddr(), conn.RemoteAddr(), r.wire(msg), tq)
+	}
+	rsp, rtt, err := dnsClient.ExchangeWithConn(msg, conn)
+	if r.TSIG != nil {
+		err = r.TSIG.verify(server, rsp, err)
+	}
+	r.metrics().Query(server, proto, queryType(msg), rcodeOf(rsp), rtt, err)
+	if r.Dnstap != nil && err == nil {
+		r.Dnstap.response(proto, conn.LocalAddr(), conn.RemoteAddr(), tq, rsp, time.Now())
 	}
-	return rsp, nil
+	return rsp, err
+}
+
+// wire returns msg as sent, with the TSIG mac when signed
+func (r *Resolver) wire(msg *dns.Msg) []byte {
+	var buf []byte
+	if r.TSIG != nil && msg.IsTsig() != nil {
+		buf, _, _ = dns.TsigGenerate(msg.Copy(), r.TSIG.Secret, _empty, false)
+	} else {
+		buf, _ = msg.Pack()
+	}
+	return buf
 }
 
 // resolvePlain ...
@@ -215,7 +415,7 @@ func (r *Resolver) resolvePlain(query string, rType uint16) ([]string, error) {
 	var all []string
 	rsp, err := r.resolve(query, rType)
 	if err != nil {
-		return _emptyStrings, errors.New(_errLookup + err.Error())
+		return _emptyStrings, fmt.Errorf("%s%w", _errLookup, err)
 	}
 	for _, a := range rsp.Answer {
 		line := a.String()
@@ -232,15 +432,21 @@ func (r *Resolver) resolvePlain(query string, rType uint16) ([]string, error) {
 
 // resolveAddr ...
 func (r *Resolver) resolveAddr(query string, rType uint16) ([]netip.Addr, error) {
+	addrs, _, err := r.resolveAddrSecure(query, rType)
+	return addrs, err
+}
+
+// resolveAddrSecure also reports a DNSSEC authenticated answer (AD bit)
+func (r *Resolver) resolveAddrSecure(query string, rType uint16) ([]netip.Addr, bool, error) {
 	switch rType {
 	case dns.TypeA:
 	case dns.TypeAAAA:
 	default:
-		return _emptyAddrs, errors.New(_errLookup + _errUnsupportedType + dns.TypeToString[rType])
+		return _emptyAddrs, false, errors.New(_errLookup + _errUnsupportedType + dns.TypeToString[rType])
 	}
 	rsp, err := r.resolve(query, rType)
 	if err != nil {
-		return _emptyAddrs, errors.New(_errLookup + err.Error())
+		return _emptyAddrs, false, fmt.Errorf("%s%w", _errLookup, err)
 	}
 	var all []netip.Addr
 	for _, a := range rsp.Answer {
@@ -253,27 +459,36 @@ func (r *Resolver) resolveAddr(query string, rType uint16) ([]netip.Addr, error)
 		}
 	}
 	if len(all) == 0 {
-		return _emptyAddrs, errors.New(_errLookup + _noAnswer)
+		return _emptyAddrs, false, errors.New(_errLookup + _noAnswer)
 	}
-	return all, nil
+	return all, rsp.AuthenticatedData, nil
 }
 
 // resolveAddrs ...
 func (r *Resolver) resolveAddrs(query string, rTypes []uint16) ([]netip.Addr, error) {
+	addrs, _, err := r.resolveAddrsSecure(query, rTypes)
+	return addrs, err
+}
+
+// resolveAddrsSecure also reports whether all answers were DNSSEC authenticated (AD bit)
+func (r *Resolver) resolveAddrsSecure(query string, rTypes []uint16) ([]netip.Addr, bool, error) {
 	var err error
 	var failCounter int
 	var all, subset []netip.Addr
+	secure := true
 	for _, rType := range rTypes {
-		if subset, err = r.resolveAddr(query, rType); err != nil {
+		var ad bool
+		if subset, ad, err = r.resolveAddrSecure(query, rType); err != nil {
 			failCounter++
 			continue
 		}
+		secure = secure && ad
 		all = append(all, subset...)
 	}
 	if failCounter == len(rTypes) {
-		return _emptyAddrs, err
+		return _emptyAddrs, false, err
 	}
-	return all, nil
+	return all, secure, nil
 }
 
 // reverseIP4 ...
@@ -290,13 +505,37 @@ func (r *Resolver) reverseIP4(addrIP4 netip.Addr, ip4 string) (string, error) {
 	if !addrIP4.IsValid() || addrIP4.IsUnspecified() || !addrIP4.Is4() {
 		return _empty, errors.New(_errReverseLookup + ip4)
 	}
-	s := strings.Split(ip4, _dot)
-	if len(s) != 4 {
-		return _empty, errors.New(_errReverseLookup + ip4)
-	}
-	resp, err := r.resolvePlain(s[3]+_dot+s[2]+_dot+s[1]+_dot+s[0]+_reverseIP4Suffix, dns.TypePTR)
+	resp, err := r.resolvePlain(reverseName(addrIP4), dns.TypePTR)
 	if err != nil {
-		return _empty, errors.New(_errReverseLookup + ip4 + _sep + err.Error())
+		return _empty, fmt.Errorf("%s%s%s%w", _errReverseLookup, ip4, _sep, err)
 	}
 	return resp[0], nil
 }
+
+// rcodeError ...
+func rcodeError(query string, rType uint16, rcode int, server, proto string, cached bool) *RcodeError {
+	return &RcodeError{
+		Query:  strings.TrimSuffix(query, _dot),
+		Type:   rType,
+		Rcode:  rcode,
+		Server: server,
+		Proto:  proto,
+		Cached: cached,
+	}
+}
+
+// queryType returns the question type, TypeNone for updates (zone section, SOA)
+func queryType(msg *dns.Msg) uint16 {
+	if msg.Opcode == dns.OpcodeUpdate {
+		return dns.TypeNone
+	}
+	return msg.Question[0].Qtype
+}
+
+// rcodeOf ...
+func rcodeOf(rsp *dns.Msg) int {
+	if rsp == nil {
+		return dns.RcodeServerFailure
+	}
+	return rsp.Rcode
+}
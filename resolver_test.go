@@ -0,0 +1,158 @@
+package dnsresolver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _slowType is answered with a delay by newSlowServer
+const _slowType = dns.TypeA
+
+// newSlowServer serves zone, answers to _slowType are delayed by delay
+func newSlowServer(t *testing.T, zone string, delay time.Duration) *dnsresolvertest.Server {
+	t.Helper()
+	inner := newServer(t, zone)
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		if m.Question[0].Qtype == _slowType {
+			time.Sleep(delay)
+		}
+		inner.ServeDNS(w, m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestExchangeSeqCompletionOrder(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	s := newSlowServer(t, _testZone, delay)
+	r := s.Resolver()
+	types := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeMX, dns.TypeANY}
+	start := time.Now()
+	var got []uint16
+	for res, err := range r.ExchangeSeq("www.example.com", types) {
+		if err != nil {
+			t.Fatalf("%s: %v", dns.TypeToString[res.Type], err)
+		}
+		if res.Query != "www.example.com" || res.Server != s.Addr || res.Proto != "udp" || res.Msg == nil {
+			t.Errorf("%s: unexpected result %+v", dns.TypeToString[res.Type], res)
+		}
+		for _, rr := range res.Answer {
+			if rr.Header().Rrtype != res.Type {
+				t.Errorf("%s: foreign record in answer: %s", dns.TypeToString[res.Type], rr)
+			}
+		}
+		got = append(got, res.Type)
+	}
+	if len(got) != 4 || got[len(got)-1] != _slowType {
+		t.Errorf("got %v, want 4 results (ANY skipped), the delayed A last", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*delay {
+		t.Errorf("took %s, slow type has to block no other", elapsed)
+	}
+}
+
+func TestExchangeSeqBreak(t *testing.T) {
+	s := newSlowServer(t, _testZone, 100*time.Millisecond)
+	r := s.Resolver()
+	n := 0
+	for range r.ExchangeSeq("www.example.com", []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT}) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("got %d results after break, want 1", n)
+	}
+	for res := range r.ExchangeSeq("www.example.com", nil) {
+		t.Errorf("no types: got %+v", res)
+	}
+}
+
+func TestExchangeSeqFailover(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := failoverResolver(s, &dnsresolver.Health{MaxFails: 1, Backoff: time.Minute})
+	for res, err := range r.ExchangeSeq("www.example.com", []uint16{dns.TypeA, dns.TypeAAAA}) {
+		if err != nil || res.Server != s.Addr || len(res.Answer) != 1 {
+			t.Errorf("%s: got %+v %v, want answer from %s", dns.TypeToString[res.Type], res, err, s.Addr)
+		}
+	}
+}
+
+func TestExchangeSeqCache(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := cachedResolver(s, dnsresolver.NewCache())
+	types := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT}
+	for range r.ExchangeSeq("www.example.com", types) {
+	}
+	queries := s.Queries()
+	for res, err := range r.ExchangeSeq("www.example.com", types) {
+		if err != nil || len(res.Answer) != 1 || res.Server != s.Addr {
+			t.Errorf("%s: got %+v %v", dns.TypeToString[res.Type], res, err)
+		}
+	}
+	if s.Queries() != queries {
+		t.Errorf("queries: got %d, want %d, answers have to come from the cache", s.Queries(), queries)
+	}
+}
+
+func TestExchangeSeqSources(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	local, _ := dns.NewRR("www.example.com. 60 IN TXT \"local\"")
+	r.Sources = []dnsresolver.Source{dnsresolver.SourceFunc(func(query string, rType uint16) ([]dns.RR, bool) {
+		if rType == dns.TypeTXT {
+			return []dns.RR{local}, true
+		}
+		return nil, false
+	}), dnsresolver.SourceDNS}
+	for res, err := range r.ExchangeSeq("www.example.com", []uint16{dns.TypeA, dns.TypeTXT}) {
+		switch {
+		case err != nil || len(res.Answer) != 1:
+			t.Errorf("%s: got %+v %v", dns.TypeToString[res.Type], res, err)
+		case res.Type == dns.TypeTXT && (res.Answer[0] != local || res.Server != ""):
+			t.Errorf("TXT: got %+v, want the local record", res)
+		case res.Type == dns.TypeA && res.Server != s.Addr:
+			t.Errorf("A: got %+v, want the upstream answer", res)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	answer, err := r.Exchange("www.example.com", true, true, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeMX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Records[dns.TypeA]) == 0 || len(answer.Records[dns.TypeAAAA]) == 0 || answer.Summary[dns.TypeA] == "" {
+		t.Errorf("missing records: %+v", answer)
+	}
+	if _, ok := answer.Summary[dns.TypeMX]; ok {
+		t.Errorf("empty MX answer has to be left out: %q", answer.Summary[dns.TypeMX])
+	}
+	s.SetFault("", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	if answer, err = r.Exchange("www.example.com", false, true, []uint16{dns.TypeA, dns.TypeAAAA}); err != nil ||
+		len(answer.Summary) != 2 || len(answer.Records) != 0 {
+		t.Errorf("failed types have to be reported each: %+v %v", answer, err)
+	}
+}
+
+func TestExchangeSeqFallbackProto(t *testing.T) {
+	s := newServer(t, _testZone)
+	s.SetFault("www.example.com", dnsresolvertest.Fault{Truncate: true})
+	for _, r := range []*dnsresolver.Resolver{s.Resolver(), cachedResolver(s, dnsresolver.NewCache())} {
+		for range 2 { // cached resolver: miss, then hit
+			for res, err := range r.ExchangeSeq("www.example.com", []uint16{dns.TypeA, dns.TypeTXT}) {
+				if err != nil || res.Proto != "tcp" || len(res.Answer) != 1 {
+					t.Errorf("%s: got %s %v %v, want the answer via tcp fallback", dns.TypeToString[res.Type], res.Proto, res.Answer, err)
+				}
+			}
+		}
+	}
+}
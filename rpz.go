@@ -0,0 +1,506 @@
+package dnsresolver
+
+import (
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_rpzIP         = "rpz-ip"
+	_rpzNSIP       = "rpz-nsip"
+	_rpzNSDNAME    = "rpz-nsdname"
+	_rpzClientIP   = "rpz-client-ip"
+	_rpzPassthru   = "rpz-passthru."
+	_rpzDrop       = "rpz-drop."
+	_rpzTCPOnly    = "rpz-tcp-only."
+	_rpzWildcard   = "*."
+	_rpzZZ         = "zz"
+	_errPolicy     = "[dnsinfo] [rpz] "
+	_errPolicyZone = "[dnsinfo] [rpz] [zone] "
+)
+
+// PolicyAction ...
+type PolicyAction int
+
+// policy actions
+const (
+	PolicyNXDOMAIN  PolicyAction = iota + 1 // CNAME .
+	PolicyNODATA                            // CNAME *.
+	PolicyPassthru                          // CNAME rpz-passthru.
+	PolicyDrop                              // CNAME rpz-drop.
+	PolicyLocalData                         // any other record
+)
+
+// PolicyTrigger ...
+type PolicyTrigger int
+
+// policy triggers, in precedence order
+const (
+	TriggerQNAME PolicyTrigger = iota + 1
+	TriggerIP
+	TriggerNSDNAME
+	TriggerNSIP
+)
+
+// Policy is a client side dns firewall fed by response policy zones (RPZ)
+type Policy struct {
+	// Zones are evaluated in order, the first zone with a matching rule wins
+	Zones []*PolicyZone
+	// OnHit is called for every policy hit (optional)
+	OnHit func(PolicyHit)
+}
+
+// PolicyZone holds the rules of a single response policy zone
+type PolicyZone struct {
+	// Name of the zone (origin)
+	Name string
+
+	qname   map[string]*policyRule
+	nsdname map[string]*policyRule
+	ip      []policyPrefix
+	nsip    []policyPrefix
+}
+
+// PolicyHit reports a matching policy rule
+type PolicyHit struct {
+	Query   string
+	Type    uint16
+	Zone    string
+	Trigger PolicyTrigger
+	Rule    string
+	Action  PolicyAction
+}
+
+// PolicyError is returned for queries answered by NXDOMAIN, NODATA or DROP policy actions
+type PolicyError struct {
+	Hit PolicyHit
+}
+
+// policyRule ...
+type policyRule struct {
+	owner  string
+	action PolicyAction
+	data   []dns.RR
+}
+
+// policyPrefix ...
+type policyPrefix struct {
+	prefix netip.Prefix
+	rule   *policyRule
+}
+
+// Error ...
+func (e *PolicyError) Error() string {
+	return _errPolicy + _policyhost + e.Hit.Query + _sep + e.Hit.Action.String() + _sep + e.Hit.Zone + _sep + e.Hit.Rule
+}
+
+// String ...
+func (a PolicyAction) String() string {
+	switch a {
+	case PolicyNXDOMAIN:
+		return "NXDOMAIN"
+	case PolicyNODATA:
+		return "NODATA"
+	case PolicyPassthru:
+		return "PASSTHRU"
+	case PolicyDrop:
+		return "DROP"
+	case PolicyLocalData:
+		return "LOCAL-DATA"
+	}
+	return "UNKNOWN"
+}
+
+// String ...
+func (t PolicyTrigger) String() string {
+	switch t {
+	case TriggerQNAME:
+		return "QNAME"
+	case TriggerIP:
+		return "IP"
+	case TriggerNSDNAME:
+		return "NSDNAME"
+	case TriggerNSIP:
+		return "NSIP"
+	}
+	return "UNKNOWN"
+}
+
+// String ...
+func (h *PolicyHit) String() string {
+	return _policyhost + h.Query + _sep + dns.TypeToString[h.Type] + _sep + h.Trigger.String() + _sep + h.Action.String() + _sep + h.Zone + _sep + h.Rule
+}
+
+//
+// ZONE LOADING
+//
+
+// LoadPolicyZone parses a response policy zone in master file format
+func LoadPolicyZone(r io.Reader, origin string) (*PolicyZone, error) {
+	z := newPolicyZone(origin)
+	zp := dns.NewZoneParser(r, z.Name, _empty)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if err := z.add(rr); err != nil {
+			return nil, err
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, errors.New(_errPolicyZone + z.Name + _sep + err.Error())
+	}
+	return z, nil
+}
+
+// PolicyZoneFile ...
+func PolicyZoneFile(path, origin string) (*PolicyZone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New(_errPolicyZone + err.Error())
+	}
+	defer f.Close()
+	return LoadPolicyZone(f, origin)
+}
+
+// PolicyZoneTransfer loads a response policy zone via AXFR from the resolver server
+func (r *Resolver) PolicyZoneTransfer(zone string) (*PolicyZone, error) {
+	z := newPolicyZone(zone)
+	for rr, err := range r.Transfer(zone) {
+		if err != nil {
+			return nil, errors.New(_errPolicyZone + err.Error())
+		}
+		if err := z.add(rr); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// newPolicyZone ...
+func newPolicyZone(origin string) *PolicyZone {
+	return &PolicyZone{
+		Name:    dns.CanonicalName(origin),
+		qname:   make(map[string]*policyRule),
+		nsdname: make(map[string]*policyRule),
+	}
+}
+
+// add sorts a policy zone record into its trigger table
+func (z *PolicyZone) add(rr dns.RR) error {
+	owner := dns.CanonicalName(rr.Header().Name)
+	if !dns.IsSubDomain(z.Name, owner) {
+		return errors.New(_errPolicyZone + z.Name + _sep + "out of zone record: " + owner)
+	}
+	switch rr.Header().Rrtype {
+	case dns.TypeSOA, dns.TypeNS:
+		if owner == z.Name {
+			return nil
+		}
+	}
+	rel := strings.TrimSuffix(strings.TrimSuffix(owner, z.Name), _dot)
+	labels := dns.SplitDomainName(rel)
+	if len(labels) == 0 {
+		return nil
+	}
+	switch labels[len(labels)-1] {
+	case _rpzIP, _rpzNSIP:
+		prefix, err := rpzPrefix(labels[:len(labels)-1])
+		if err != nil {
+			return errors.New(_errPolicyZone + z.Name + _sep + owner + _sep + err.Error())
+		}
+		table := &z.ip
+		if labels[len(labels)-1] == _rpzNSIP {
+			table = &z.nsip
+		}
+		for _, p := range *table {
+			if p.prefix == prefix {
+				p.rule.add(rr)
+				return nil
+			}
+		}
+		rule := &policyRule{owner: owner}
+		rule.add(rr)
+		*table = append(*table, policyPrefix{prefix, rule})
+	case _rpzNSDNAME:
+		z.rule(z.nsdname, dns.Fqdn(strings.Join(labels[:len(labels)-1], _dot)), owner).add(rr)
+	case _rpzClientIP:
+		// client ip triggers make no sense for a stub resolver
+	default:
+		z.rule(z.qname, dns.Fqdn(rel), owner).add(rr)
+	}
+	return nil
+}
+
+// rule ...
+func (z *PolicyZone) rule(table map[string]*policyRule, key, owner string) *policyRule {
+	rule, ok := table[key]
+	if !ok {
+		rule = &policyRule{owner: owner}
+		table[key] = rule
+	}
+	return rule
+}
+
+// add derives the action from a rule record, local data accumulates
+func (p *policyRule) add(rr dns.RR) {
+	if cname, ok := rr.(*dns.CNAME); ok {
+		switch strings.ToLower(cname.Target) {
+		case _dot:
+			p.action = PolicyNXDOMAIN
+			return
+		case _rpzWildcard:
+			p.action = PolicyNODATA
+			return
+		case _rpzPassthru, _rpzTCPOnly:
+			p.action = PolicyPassthru
+			return
+		case _rpzDrop:
+			p.action = PolicyDrop
+			return
+		}
+	}
+	p.action = PolicyLocalData
+	p.data = append(p.data, rr)
+}
+
+// rpzPrefix decodes the reversed rpz-ip prefix notation, eg. 24.0.2.0.192 or 48.zz.db8.2001
+func rpzPrefix(labels []string) (netip.Prefix, error) {
+	if len(labels) < 2 {
+		return netip.Prefix{}, errors.New("invalid ip trigger")
+	}
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netip.Prefix{}, errors.New("invalid prefix length")
+	}
+	addr := labels[1:]
+	for i, j := 0, len(addr)-1; i < j; i, j = i+1, j-1 {
+		addr[i], addr[j] = addr[j], addr[i]
+	}
+	var ip netip.Addr
+	zz := slices.IndexFunc(addr, func(label string) bool { return strings.EqualFold(label, _rpzZZ) })
+	switch {
+	case zz >= 0: // zz marks the :: of the compressed ipv6 notation
+		ip, err = netip.ParseAddr(strings.Join(addr[:zz], ":") + "::" + strings.Join(addr[zz+1:], ":"))
+	case len(addr) == 4:
+		ip, err = netip.ParseAddr(strings.Join(addr, _dot))
+	default:
+		ip, err = netip.ParseAddr(strings.Join(addr, ":"))
+	}
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil || prefix.Bits() != bits {
+		return netip.Prefix{}, errors.New("invalid prefix length")
+	}
+	return prefix, nil
+}
+
+//
+// MATCHING
+//
+
+// matchName returns the exact or the longest wildcard rule for name
+func matchName(table map[string]*policyRule, name string) *policyRule {
+	if rule, ok := table[name]; ok {
+		return rule
+	}
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		if rule, ok := table[_rpzWildcard+strings.Join(labels[i:], _dot)+_dot]; ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchPrefix returns the longest prefix rule covering any addr
+func matchPrefix(table []policyPrefix, addrs []netip.Addr) *policyRule {
+	var best *policyPrefix
+	for _, addr := range addrs {
+		for i := range table {
+			p := &table[i]
+			if p.prefix.Contains(addr.Unmap()) && (best == nil || p.prefix.Bits() > best.prefix.Bits()) {
+				best = p
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.rule
+}
+
+// applyPolicy wraps exchange into the policy evaluation, the hit is nil without matching rule
+func (r *Resolver) applyPolicy(query string, rType uint16, exchange func() (*dns.Msg, error)) (*dns.Msg, *PolicyHit, error) {
+	p := r.Policy
+	if p == nil || len(p.Zones) == 0 {
+		rsp, err := exchange()
+		return rsp, nil, err
+	}
+	name := dns.CanonicalName(query)
+	var (
+		rsp     *dns.Msg
+		err     error
+		queried bool
+		ns      []string
+		nsAddrs []netip.Addr
+		nsDone  bool
+	)
+	for _, z := range p.Zones {
+		trigger, rule := TriggerQNAME, matchName(z.qname, name)
+		if rule == nil && len(z.ip) > 0 {
+			if !queried {
+				rsp, err = exchange()
+				queried = true
+			}
+			if err == nil {
+				trigger, rule = TriggerIP, matchPrefix(z.ip, answerAddrs(rsp))
+			}
+		}
+		if rule == nil && (len(z.nsdname) > 0 || len(z.nsip) > 0) {
+			if !nsDone {
+				ns, nsAddrs = r.policyNS(name)
+				nsDone = true
+			}
+			for _, host := range ns {
+				if rule = matchName(z.nsdname, host); rule != nil {
+					trigger = TriggerNSDNAME
+					break
+				}
+			}
+			if rule == nil {
+				trigger, rule = TriggerNSIP, matchPrefix(z.nsip, nsAddrs)
+			}
+		}
+		if rule == nil {
+			continue
+		}
+		hit := &PolicyHit{Query: strings.TrimSuffix(name, _dot), Type: rType, Zone: z.Name, Trigger: trigger, Rule: rule.owner, Action: rule.action}
+		if p.OnHit != nil {
+			p.OnHit(*hit)
+		}
+		if rule.action == PolicyPassthru {
+			if !queried {
+				rsp, err = exchange()
+			}
+			return rsp, hit, err
+		}
+		rsp, err = r.policyAnswer(name, rType, rule, hit)
+		return rsp, hit, err
+	}
+	if !queried {
+		rsp, err = exchange()
+	}
+	return rsp, nil, err
+}
+
+// policyAnswer synthesizes the response for a policy action
+func (r *Resolver) policyAnswer(name string, rType uint16, rule *policyRule, hit *PolicyHit) (*dns.Msg, error) {
+	msg := localMsg(name, rType, nil)
+	msg.Authoritative = false
+	switch rule.action {
+	case PolicyDrop:
+		return &dns.Msg{}, &PolicyError{*hit}
+	case PolicyNXDOMAIN:
+		msg.Rcode = dns.RcodeNameError
+		policyEDE(msg, dns.ExtendedErrorCodeBlocked, hit)
+		return msg, &PolicyError{*hit}
+	case PolicyNODATA:
+		policyEDE(msg, dns.ExtendedErrorCodeBlocked, hit)
+		return msg, &PolicyError{*hit}
+	}
+	var cname *dns.CNAME
+	for _, rr := range rule.data {
+		switch {
+		case rr.Header().Rrtype == rType:
+		case rr.Header().Rrtype == dns.TypeCNAME:
+			cname = rr.(*dns.CNAME)
+		default:
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		msg.Answer = append(msg.Answer, rr)
+	}
+	if len(msg.Answer) == 1 && cname != nil && rType != dns.TypeCNAME {
+		// rewrite to the local-data target, resolved without policy to avoid loops
+		resolver := *r
+		resolver.Policy = nil
+		if target, err := resolver.resolve(cname.Target, rType); err == nil {
+			msg.Answer = append(msg.Answer, target.Answer...)
+		}
+	}
+	policyEDE(msg, dns.ExtendedErrorCodeForgedAnswer, hit)
+	return msg, nil
+}
+
+// policyNS returns the nameserver names and addresses responsible for name
+func (r *Resolver) policyNS(name string) ([]string, []netip.Addr) {
+	resolver := *r
+	resolver.Policy = nil
+	labels := dns.SplitDomainName(name)
+	for i := range labels {
+		zone := dns.Fqdn(strings.Join(labels[i:], _dot))
+		rsp, _, _, err := resolver.resolveDNS(zone, dns.TypeNS)
+		if err != nil {
+			continue
+		}
+		var hosts []string
+		for _, rr := range rsp.Answer {
+			if ns, ok := rr.(*dns.NS); ok {
+				hosts = append(hosts, dns.CanonicalName(ns.Ns))
+			}
+		}
+		if len(hosts) == 0 {
+			continue
+		}
+		var addrs []netip.Addr
+		for _, host := range hosts {
+			if a, err := resolver.resolveAddrs(host, []uint16{dns.TypeA, dns.TypeAAAA}); err == nil {
+				addrs = append(addrs, a...)
+			}
+		}
+		return hosts, addrs
+	}
+	return nil, nil
+}
+
+// answerAddrs ...
+func answerAddrs(rsp *dns.Msg) []netip.Addr {
+	return rrAddrs(rsp.Answer)
+}
+
+// rrAddrs returns the addresses of all A and AAAA records
+func rrAddrs(rrs []dns.RR) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range rrs {
+		switch v := rr.(type) {
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(v.A); ok {
+				addrs = append(addrs, addr.Unmap())
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(v.AAAA); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// policyEDE attaches an extended dns error (RFC 8914) naming the policy zone
+func policyEDE(msg *dns.Msg, code uint16, hit *PolicyHit) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: _policyhost + hit.Zone + _sep + hit.Rule})
+}
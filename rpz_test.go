@@ -0,0 +1,119 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// _policyZone is a response policy zone covering all actions and triggers
+const _policyZone = `$TTL 300
+@			IN SOA	localhost. root.localhost. 1 3600 600 86400 60
+@			IN NS	localhost.
+blocked.example.com	IN CNAME .
+*.wild.example.com	IN CNAME .
+empty.example.com	IN CNAME *.
+dropped.example.com	IN CNAME rpz-drop.
+passed.example.com	IN CNAME rpz-passthru.
+local.example.com	IN A	198.51.100.1
+rewrite.example.com	IN CNAME www.example.com.
+32.1.2.0.192.rpz-ip	IN CNAME .
+`
+
+// policyResolver answers from s with the policy zone applied
+func policyResolver(t *testing.T, zone string, onHit func(dnsresolver.PolicyHit)) *dnsresolver.Resolver {
+	t.Helper()
+	s := newServer(t, _testZone+zone)
+	z, err := dnsresolver.LoadPolicyZone(strings.NewReader(_policyZone), "rpz.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.Resolver()
+	r.Policy = &dnsresolver.Policy{Zones: []*dnsresolver.PolicyZone{z}, OnHit: onHit}
+	return r
+}
+
+func TestPolicyActions(t *testing.T) {
+	var hits []dnsresolver.PolicyHit
+	r := policyResolver(t, "blocked A 192.0.2.9\ndropped A 192.0.2.9\npassed A 192.0.2.9\nx.wild A 192.0.2.9\n", func(h dnsresolver.PolicyHit) {
+		hits = append(hits, h)
+	})
+	for _, tc := range []struct {
+		name    string
+		rType   uint16
+		action  dnsresolver.PolicyAction
+		trigger dnsresolver.PolicyTrigger
+		want    []string
+	}{
+		{"blocked.example.com", dns.TypeA, dnsresolver.PolicyNXDOMAIN, dnsresolver.TriggerQNAME, nil},
+		{"x.wild.example.com", dns.TypeA, dnsresolver.PolicyNXDOMAIN, dnsresolver.TriggerQNAME, nil},
+		{"empty.example.com", dns.TypeA, dnsresolver.PolicyNODATA, dnsresolver.TriggerQNAME, nil},
+		{"dropped.example.com", dns.TypeA, dnsresolver.PolicyDrop, dnsresolver.TriggerQNAME, nil},
+		{"passed.example.com", dns.TypeA, dnsresolver.PolicyPassthru, dnsresolver.TriggerQNAME, []string{"192.0.2.9"}},
+		{"local.example.com", dns.TypeA, dnsresolver.PolicyLocalData, dnsresolver.TriggerQNAME, []string{"198.51.100.1"}},
+		{"rewrite.example.com", dns.TypeA, dnsresolver.PolicyLocalData, dnsresolver.TriggerQNAME, []string{"192.0.2.80"}},
+		{"example.com", dns.TypeA, dnsresolver.PolicyNXDOMAIN, dnsresolver.TriggerIP, nil},
+		{"www.example.com", dns.TypeA, 0, 0, []string{"192.0.2.80"}},
+	} {
+		hits = nil
+		addrs, err := r.LookupAddr(tc.name, tc.rType)
+		var policyErr *dnsresolver.PolicyError
+		switch blocked := tc.want == nil; {
+		case blocked && (!errors.As(err, &policyErr) || policyErr.Hit.Action != tc.action):
+			t.Errorf("%s: got %v %v, want %s", tc.name, addrs, err, tc.action)
+		case !blocked && (err != nil || len(addrs) != len(tc.want) || addrs[0].String() != tc.want[0]):
+			t.Errorf("%s: got %v %v, want %v", tc.name, addrs, err, tc.want)
+		}
+		switch {
+		case tc.action == 0 && len(hits) != 0:
+			t.Errorf("%s: unexpected hits %v", tc.name, hits)
+		case tc.action != 0 && (len(hits) != 1 || hits[0].Action != tc.action || hits[0].Trigger != tc.trigger || hits[0].Zone != "rpz.test."):
+			t.Errorf("%s: hits %v, want %s via %s", tc.name, hits, tc.action, tc.trigger)
+		}
+	}
+}
+
+func TestPolicyIPv6Triggers(t *testing.T) {
+	for _, tc := range []struct {
+		rule string
+		addr string
+	}{
+		{"48.zz.db8.2001", "2001:db8:0:1::1"},        // trailing zz
+		{"128.80.zz.db8.2001", "2001:db8::80"},       // middle zz
+		{"128.1.zz", "::1"},                          // leading zz
+		{"128.1.0.0.0.0.0.db8.2001", "2001:db8::1"},  // uncompressed
+		{"64.ZZ.1.0.db8.2001", "2001:db8:0:1::cafe"}, // case insensitive
+	} {
+		z, err := dnsresolver.LoadPolicyZone(strings.NewReader(tc.rule+".rpz-ip 300 CNAME .\n"), "rpz.test")
+		if err != nil {
+			t.Errorf("%s: %v", tc.rule, err)
+			continue
+		}
+		s := newServer(t, _testZone+"host AAAA "+tc.addr+"\nother AAAA 2001:db9::1\n")
+		r := s.Resolver()
+		r.Policy = &dnsresolver.Policy{Zones: []*dnsresolver.PolicyZone{z}}
+		var policyErr *dnsresolver.PolicyError
+		if _, err := r.LookupAddr("host.example.com", dns.TypeAAAA); !errors.As(err, &policyErr) || policyErr.Hit.Trigger != dnsresolver.TriggerIP {
+			t.Errorf("%s: %s not blocked: %v", tc.rule, tc.addr, err)
+		}
+		if _, err := r.LookupAddr("other.example.com", dns.TypeAAAA); err != nil {
+			t.Errorf("%s: 2001:db9::1 blocked: %v", tc.rule, err)
+		}
+	}
+}
+
+func TestPolicyInvalidTriggers(t *testing.T) {
+	for _, rule := range []string{
+		"128.1.zz.db8.zz.2001", // two zz
+		"33.1.2.0.192",         // prefix too long
+		"x.2.0.192",            // no prefix length
+		"64.1.2.3",             // neither ipv4 nor ipv6
+	} {
+		if _, err := dnsresolver.LoadPolicyZone(strings.NewReader(rule+".rpz-ip 300 CNAME .\n"), "rpz.test"); err == nil {
+			t.Errorf("%s: want error", rule)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package dnsresolver
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_serviceTTLMin = 5 * time.Second
+	_serviceRetry  = 30 * time.Second
+	_errService    = "[dnsinfo] [service] "
+)
+
+// ServiceTarget is a SRV target with its resolved addresses
+type ServiceTarget struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	Addrs    []netip.Addr
+}
+
+// Service is a discovered SRV service, see Resolver.LookupService
+type Service struct {
+	// Name _service._proto.domain
+	Name string
+
+	r       *Resolver
+	mu      sync.Mutex
+	targets []ServiceTarget
+	expire  time.Time
+	stop    chan struct{}
+}
+
+// LookupService resolves the SRV records of _service._proto.domain and their target
+// addresses, an empty service and proto looks up domain directly
+func (r *Resolver) LookupService(service, proto, domain string) (*Service, error) {
+	s := &Service{Name: serviceName(service, proto, domain), r: r}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DialService connects to the first reachable target of the service, in RFC 2782 order
+func (r *Resolver) DialService(network, service, proto, domain string) (net.Conn, error) {
+	s, err := r.LookupService(service, proto, domain)
+	if err != nil {
+		return nil, err
+	}
+	return s.Dial(network)
+}
+
+// Targets returns the targets in RFC 2782 order: ascending priority, weighted random
+// selection within a priority, a new selection on every call
+func (s *Service) Targets() []ServiceTarget {
+	s.mu.Lock()
+	targets := s.targets
+	s.mu.Unlock()
+	return weightedOrder(targets)
+}
+
+// Expire returns the time the records expire, Watch refreshes them then
+func (s *Service) Expire() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expire
+}
+
+// Dial tries all target addresses in order until a connection succeeds
+func (s *Service) Dial(network string) (net.Conn, error) {
+	err := errors.New(_errService + s.Name + _sep + "no target address")
+	for _, t := range s.Targets() {
+		for _, addr := range t.Addrs {
+			var conn net.Conn
+			if conn, err = net.DialTimeout(network, netip.AddrPortFrom(addr, t.Port).String(), s.r.Timeout); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// Watch re-resolves the service in the background when its TTL expires, until Stop,
+// failed refreshes keep the previous targets and get retried
+func (s *Service) Watch() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mu.Unlock()
+	go func() {
+		for {
+			wait := max(time.Until(s.Expire()), _serviceTTLMin)
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+			if err := s.refresh(); err != nil {
+				s.mu.Lock()
+				s.expire = time.Now().Add(_serviceRetry)
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh started via Watch
+func (s *Service) Stop() {
+	s.mu.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Unlock()
+}
+
+// refresh ...
+func (s *Service) refresh() error {
+	rsp, err := s.r.resolve(s.Name, dns.TypeSRV)
+	if err != nil {
+		return errors.New(_errService + s.Name + _sep + err.Error())
+	}
+	var targets []ServiceTarget
+	ttl := uint32(0)
+	for _, rr := range rsp.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if ttl == 0 || srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+		if srv.Target == _dot {
+			continue // RFC 2782: decidedly not available
+		}
+		targets = append(targets, ServiceTarget{
+			Target:   srv.Target,
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Addrs:    s.r.targetAddrs(srv.Target, rsp.Extra),
+		})
+	}
+	if len(targets) == 0 {
+		return errors.New(_errService + s.Name + _sep + "service not available")
+	}
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].Priority < targets[j].Priority })
+	s.mu.Lock()
+	s.targets = targets
+	s.expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	s.mu.Unlock()
+	return nil
+}
+
+// targetAddrs uses the additional section addresses, or resolves the target
+func (r *Resolver) targetAddrs(target string, extra []dns.RR) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range extra {
+		if !strings.EqualFold(rr.Header().Name, target) {
+			continue
+		}
+		switch v := rr.(type) {
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(v.AAAA); ok {
+				addrs = append(addrs, addr)
+			}
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(v.A.To4()); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs
+	}
+	addrs, _ = r.resolveAddrs(target, []uint16{dns.TypeAAAA, dns.TypeA})
+	return addrs
+}
+
+// weightedOrder applies the RFC 2782 selection to priority sorted targets
+func weightedOrder(targets []ServiceTarget) []ServiceTarget {
+	ordered := make([]ServiceTarget, 0, len(targets))
+	for i := 0; i < len(targets); {
+		j := i
+		for j < len(targets) && targets[j].Priority == targets[i].Priority {
+			j++
+		}
+		group := slices.Clone(targets[i:j])
+		sort.SliceStable(group, func(a, b int) bool { return group[a].Weight == 0 && group[b].Weight != 0 })
+		for len(group) > 0 {
+			sum := 0
+			for _, t := range group {
+				sum += int(t.Weight)
+			}
+			n, running, k := rand.IntN(sum+1), 0, 0
+			for k = range group {
+				if running += int(group[k].Weight); running >= n {
+					break
+				}
+			}
+			ordered = append(ordered, group[k])
+			group = slices.Delete(group, k, k+1)
+		}
+		i = j
+	}
+	return ordered
+}
+
+// serviceName ...
+func serviceName(service, proto, domain string) string {
+	if service == _empty && proto == _empty {
+		return dns.Fqdn(domain)
+	}
+	return "_" + strings.TrimPrefix(service, "_") + "._" + strings.TrimPrefix(proto, "_") + _dot + dns.Fqdn(domain)
+}
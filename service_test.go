@@ -0,0 +1,121 @@
+package dnsresolver_test
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _serviceZone ...
+const _serviceZone = `_http._tcp	60 IN SRV	10 90 8080 a.example.com.
+_http._tcp	60 IN SRV	10 10 8080 b.example.com.
+_http._tcp	60 IN SRV	10 0 8080 c.example.com.
+_http._tcp	30 IN SRV	20 0 8080 d.example.com.
+_none._tcp	IN SRV	0 0 0 .
+a		IN A	192.0.2.10
+b		IN AAAA	2001:db8::11
+c		IN A	192.0.2.12
+d		IN A	192.0.2.13
+`
+
+func TestLookupService(t *testing.T) {
+	s := newServer(t, _testZone+_serviceZone)
+	svc, err := s.Resolver().LookupService("http", "tcp", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Name != "_http._tcp.example.com." {
+		t.Errorf("name %s", svc.Name)
+	}
+	if ttl := time.Until(svc.Expire()); ttl < 25*time.Second || ttl > 30*time.Second {
+		t.Errorf("expire in %v, want min ttl 30s", ttl)
+	}
+	first := make(map[string]int)
+	for range 1000 {
+		targets := svc.Targets()
+		if len(targets) != 4 || targets[3].Target != "d.example.com." {
+			t.Fatalf("got %+v, want priority 20 last", targets)
+		}
+		first[targets[0].Target]++
+	}
+	if first["a.example.com."] < 800 || first["b.example.com."] < 50 || first["c.example.com."] > 50 {
+		t.Errorf("first target counts %v, want weighted about 90:10:~0", first)
+	}
+	for _, target := range svc.Targets() {
+		if len(target.Addrs) != 1 || target.Port != 8080 {
+			t.Errorf("%s: got %+v", target.Target, target)
+		}
+	}
+}
+
+func TestLookupServiceNotAvailable(t *testing.T) {
+	s := newServer(t, _testZone+_serviceZone)
+	for _, service := range []string{"none", "missing"} {
+		if _, err := s.Resolver().LookupService(service, "tcp", "example.com"); err == nil {
+			t.Errorf("%s: want error", service)
+		}
+	}
+}
+
+func TestServiceWatch(t *testing.T) {
+	before := newServer(t, _testZone+"_svc._tcp 1 IN SRV 0 0 80 a.example.com.\na IN A 192.0.2.10\n")
+	after := newServer(t, _testZone+"_svc._tcp 1 IN SRV 0 0 80 b.example.com.\nb IN A 192.0.2.11\n")
+	var zone atomic.Pointer[dnsresolvertest.Server]
+	zone.Store(before)
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) { zone.Load().ServeDNS(w, m) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	svc, err := s.Resolver().LookupService("svc", "tcp", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Watch()
+	zone.Store(after)
+	time.Sleep(5500 * time.Millisecond) // min refresh interval
+	if targets := svc.Targets(); len(targets) != 1 || targets[0].Target != "b.example.com." || targets[0].Addrs[0].String() != "192.0.2.11" {
+		t.Errorf("got %+v, want the changed target", targets)
+	}
+	svc.Stop()
+	queries := s.Queries()
+	time.Sleep(5500 * time.Millisecond)
+	if s.Queries() != queries {
+		t.Errorf("queries %d after Stop, want %d", s.Queries(), queries)
+	}
+	svc.Stop()
+}
+
+func TestDialService(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	zone := _testZone + strings.Join([]string{
+		"_svc._tcp IN SRV 10 0 1 down.example.com.", // port 1, refused
+		"_svc._tcp IN SRV 20 0 " + port + " up.example.com.",
+		"down IN A 127.0.0.1",
+		"up IN A 127.0.0.1",
+	}, "\n") + "\n"
+	s := newServer(t, zone)
+	conn, err := s.Resolver().DialService("tcp", "svc", "tcp", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "127.0.0.1:"+port {
+		t.Errorf("connected to %s, want port %s", conn.RemoteAddr(), port)
+	}
+}
@@ -0,0 +1,769 @@
+package dnsresolver
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_spfVersion    = "v=spf1"
+	_spfLookupMax  = 10 // RFC 7208 section 4.6.4
+	_spfVoidMax    = 2
+	_spfNamesMax   = 10 // mx and ptr names per mechanism
+	_spfDomainMax  = 253
+	_spfDelimiters = ".-+,/_="
+	_spfUnknown    = "unknown"
+	_errSPF        = "[dnsinfo] [spf] "
+)
+
+// errSPFVoid ...
+var errSPFVoid = errors.New("void lookup limit exceeded")
+
+// SPFResult is the result of check_host(), RFC 7208 section 2.6
+type SPFResult int
+
+// SPF results
+const (
+	SPFNone SPFResult = iota
+	SPFNeutral
+	SPFPass
+	SPFFail
+	SPFSoftFail
+	SPFTempError
+	SPFPermError
+)
+
+// SPF is the outcome of a check_host() evaluation
+type SPF struct {
+	Result SPFResult
+	// Explanation of a fail result, from the exp modifier
+	Explanation string
+	// Lookups counted against the 10 lookup limit, Voids against the void lookup limit
+	Lookups int
+	Voids   int
+	// Trace of every evaluated record and term
+	Trace []SPFStep
+}
+
+// SPFStep is a single evaluated record or term
+type SPFStep struct {
+	// Depth of include and redirect nesting
+	Depth  int
+	Domain string
+	Term   string
+	Result string
+}
+
+// SPFError details a temperror or permerror result, Domain is the (included) domain
+// whose record or Term caused it
+type SPFError struct {
+	Domain string
+	Term   string
+	Result SPFResult
+	Err    error
+}
+
+// Error ...
+func (e *SPFError) Error() string {
+	if e.Term == _empty {
+		return _errSPF + e.Domain + _sep + e.Err.Error()
+	}
+	return _errSPF + e.Domain + _sep + e.Term + _sep + e.Err.Error()
+}
+
+// Unwrap ...
+func (e *SPFError) Unwrap() error {
+	return e.Err
+}
+
+// spfTerm is a parsed mechanism or modifier
+type spfTerm struct {
+	raw       string
+	qualifier SPFResult
+	name      string
+	domain    string // domain-spec or modifier value
+	addr      netip.Prefix
+	cidr4     int
+	cidr6     int
+	modifier  bool
+}
+
+// spfCheck holds the state of one evaluation, shared by include and redirect
+type spfCheck struct {
+	r       *Resolver
+	ip      netip.Addr
+	sender  string
+	helo    string
+	depth   int
+	lookups int
+	voids   int
+	trace   []SPFStep
+}
+
+// CheckHost evaluates the SPF policy of domain for a mail from sender (MAIL FROM, empty:
+// postmaster@helo) received from ip, an *SPFError details temperror and permerror results
+func (r *Resolver) CheckHost(ip netip.Addr, domain, sender, helo string) (*SPF, error) {
+	switch {
+	case sender == _empty:
+		sender = "postmaster@" + helo
+	case strings.HasPrefix(sender, "@"):
+		sender = "postmaster" + sender
+	case !strings.Contains(sender, "@"):
+		sender = "postmaster@" + sender
+	}
+	c := &spfCheck{r: r, ip: ip.Unmap(), sender: sender, helo: helo}
+	result, explanation, err := c.checkHost(domain)
+	return &SPF{Result: result, Explanation: explanation, Lookups: c.lookups, Voids: c.voids, Trace: c.trace}, err
+}
+
+// checkHost ...
+func (c *spfCheck) checkHost(domain string) (SPFResult, string, error) {
+	domain = strings.TrimSuffix(domain, _dot)
+	if !spfValidDomain(domain) {
+		c.step(domain, _empty, "none: invalid domain")
+		return SPFNone, _empty, nil
+	}
+	record, result, err := c.record(domain)
+	if record == _empty {
+		c.step(domain, _empty, result.String())
+		return result, _empty, err
+	}
+	c.step(domain, record, _empty)
+	terms, err := parseSPF(record)
+	if err != nil {
+		c.step(domain, record, err.Error())
+		return SPFPermError, _empty, &SPFError{Domain: domain, Result: SPFPermError, Err: err}
+	}
+	var redirect, exp string
+	for _, t := range terms {
+		switch {
+		case t.modifier && t.name == "redirect":
+			redirect = t.domain
+		case t.modifier && t.name == "exp":
+			exp = t.domain
+		}
+	}
+	c.depth++
+	defer func() { c.depth-- }()
+	for _, t := range terms {
+		if t.modifier {
+			continue
+		}
+		match, result, err := c.mechanism(domain, t)
+		var spfErr *SPFError
+		switch {
+		case errors.As(err, &spfErr):
+			c.step(domain, t.raw, result.String())
+			return result, _empty, err // already detailed by the included domain
+		case err != nil:
+			c.step(domain, t.raw, result.String()+": "+err.Error())
+			return result, _empty, &SPFError{Domain: domain, Term: t.raw, Result: result, Err: err}
+		case !match:
+			c.step(domain, t.raw, "no match")
+			continue
+		}
+		c.step(domain, t.raw, "match: "+t.qualifier.String())
+		if t.qualifier == SPFFail && exp != _empty {
+			return t.qualifier, c.explain(domain, exp), nil
+		}
+		return t.qualifier, _empty, nil
+	}
+	if redirect == _empty {
+		c.step(domain, "default", SPFNeutral.String())
+		return SPFNeutral, _empty, nil
+	}
+	if err := c.count(); err != nil {
+		c.step(domain, "redirect="+redirect, err.Error())
+		return SPFPermError, _empty, &SPFError{Domain: domain, Term: "redirect=" + redirect, Result: SPFPermError, Err: err}
+	}
+	target, err := c.expand(redirect, domain, false)
+	if err != nil {
+		return SPFPermError, _empty, &SPFError{Domain: domain, Term: "redirect=" + redirect, Result: SPFPermError, Err: err}
+	}
+	c.step(domain, "redirect="+redirect, target)
+	result, explanation, err := c.checkHost(target)
+	if result == SPFNone {
+		return SPFPermError, _empty, &SPFError{Domain: domain, Term: "redirect=" + redirect, Result: SPFPermError, Err: errors.New("domain without spf record: " + target)}
+	}
+	return result, explanation, err
+}
+
+// record fetches the single spf record of domain, not counted as void lookup
+func (c *spfCheck) record(domain string) (string, SPFResult, error) {
+	rrs, err := c.lookup(domain, dns.TypeTXT)
+	if err != nil {
+		return _empty, SPFTempError, &SPFError{Domain: domain, Result: SPFTempError, Err: err}
+	}
+	var records []string
+	for _, rr := range rrs {
+		if txt, ok := rr.(*dns.TXT); ok {
+			s := strings.Join(txt.Txt, _empty)
+			if len(s) >= len(_spfVersion) && strings.EqualFold(s[:len(_spfVersion)], _spfVersion) && (len(s) == len(_spfVersion) || s[len(_spfVersion)] == _whitespace) {
+				records = append(records, s)
+			}
+		}
+	}
+	switch len(records) {
+	case 0:
+		return _empty, SPFNone, nil
+	case 1:
+		return records[0], SPFNone, nil
+	}
+	return _empty, SPFPermError, &SPFError{Domain: domain, Result: SPFPermError, Err: errors.New("multiple spf records")}
+}
+
+// mechanism reports whether t matches, the result is only set along with an error
+func (c *spfCheck) mechanism(domain string, t spfTerm) (bool, SPFResult, error) {
+	switch t.name {
+	case "all":
+		return true, SPFNone, nil
+	case "ip4", "ip6":
+		return t.addr.Contains(c.ip), SPFNone, nil
+	}
+	if err := c.count(); err != nil {
+		return false, SPFPermError, err
+	}
+	target := domain
+	if t.domain != _empty {
+		var err error
+		if target, err = c.expand(t.domain, domain, false); err != nil {
+			return false, SPFPermError, err
+		}
+	}
+	switch t.name {
+	case "include":
+		result, _, err := c.checkHost(target)
+		switch result {
+		case SPFPass:
+			return true, SPFNone, nil
+		case SPFTempError:
+			return false, spfErrResult(err), err
+		case SPFNone, SPFPermError:
+			if err == nil {
+				err = &SPFError{Domain: target, Result: SPFPermError, Err: errors.New("included domain without spf record")}
+			}
+			return false, SPFPermError, err
+		}
+		return false, SPFNone, nil
+	case "a":
+		return c.matchHost(target, t)
+	case "mx":
+		rrs, err := c.query(target, dns.TypeMX)
+		if err != nil {
+			return false, spfErrResult(err), err
+		}
+		if len(rrs) > _spfNamesMax {
+			return false, SPFPermError, errors.New("more than 10 mx names")
+		}
+		for _, rr := range rrs {
+			if mx, ok := rr.(*dns.MX); ok {
+				if match, result, err := c.matchHost(mx.Mx, t); match || err != nil {
+					return match, result, err
+				}
+			}
+		}
+		return false, SPFNone, nil
+	case "ptr":
+		for _, name := range c.validatedNames() {
+			name = strings.TrimSuffix(name, _dot)
+			if strings.EqualFold(name, target) || strings.HasSuffix(strings.ToLower(name), _dot+strings.ToLower(target)) {
+				return true, SPFNone, nil
+			}
+		}
+		return false, SPFNone, nil
+	case "exists":
+		rrs, err := c.query(target, dns.TypeA)
+		if err != nil {
+			return false, spfErrResult(err), err
+		}
+		return len(rrs) > 0, SPFNone, nil
+	}
+	return false, SPFPermError, errors.New("unknown mechanism " + t.name)
+}
+
+// matchHost compares ip against the addresses of host, within the term cidr
+func (c *spfCheck) matchHost(host string, t spfTerm) (bool, SPFResult, error) {
+	rType, bits := dns.TypeA, t.cidr4
+	if c.ip.Is6() {
+		rType, bits = dns.TypeAAAA, t.cidr6
+	}
+	rrs, err := c.query(host, rType)
+	if err != nil {
+		return false, spfErrResult(err), err
+	}
+	for _, addr := range rrAddrs(rrs) {
+		if prefix, err := addr.Prefix(bits); err == nil && prefix.Contains(c.ip) {
+			return true, SPFNone, nil
+		}
+	}
+	return false, SPFNone, nil
+}
+
+// validatedNames returns the ptr names of ip that resolve back to ip, RFC 7208 section 5.5
+func (c *spfCheck) validatedNames() []string {
+	rrs, err := c.query(reverseName(c.ip), dns.TypePTR)
+	if err != nil {
+		return nil
+	}
+	rType := dns.TypeA
+	if c.ip.Is6() {
+		rType = dns.TypeAAAA
+	}
+	var names []string
+	for i, rr := range rrs {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok || i >= _spfNamesMax {
+			continue
+		}
+		addrs, err := c.query(ptr.Ptr, rType)
+		if err != nil {
+			continue
+		}
+		for _, addr := range rrAddrs(addrs) {
+			if addr == c.ip {
+				names = append(names, ptr.Ptr)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// explain fetches and expands the exp explanation, failures give no explanation
+func (c *spfCheck) explain(domain, exp string) string {
+	target, err := c.expand(exp, domain, false)
+	if err != nil {
+		return _empty
+	}
+	rsp, err := c.r.resolve(target, dns.TypeTXT)
+	if err != nil {
+		return _empty
+	}
+	var txts []string
+	for _, rr := range rsp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, _empty))
+		}
+	}
+	if len(txts) != 1 {
+		return _empty
+	}
+	explanation, err := c.expand(txts[0], domain, true)
+	if err != nil {
+		return _empty
+	}
+	c.step(domain, "exp="+exp, explanation)
+	return explanation
+}
+
+// query resolves name for a mechanism or modifier, NXDOMAIN and empty answers count as
+// void lookups
+func (c *spfCheck) query(name string, rType uint16) ([]dns.RR, error) {
+	rrs, err := c.lookup(name, rType)
+	if err == nil && len(rrs) == 0 {
+		if c.voids++; c.voids > _spfVoidMax {
+			return nil, errSPFVoid
+		}
+	}
+	return rrs, err
+}
+
+// lookup returns the rType records of name, NXDOMAIN is an empty answer
+func (c *spfCheck) lookup(name string, rType uint16) ([]dns.RR, error) {
+	rsp, err := c.r.resolve(name, rType)
+	var rcodeErr *RcodeError
+	if err != nil && !(errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError) {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for _, rr := range rsp.Answer {
+		if rr.Header().Rrtype == rType {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, nil
+}
+
+// spfErrResult maps lookup errors, dns failures are temporary, exceeded limits permanent
+func spfErrResult(err error) SPFResult {
+	if errors.Is(err, errSPFVoid) {
+		return SPFPermError
+	}
+	return SPFTempError
+}
+
+// count adds a dns lookup term
+func (c *spfCheck) count() error {
+	if c.lookups++; c.lookups > _spfLookupMax {
+		return errors.New("dns lookup limit exceeded")
+	}
+	return nil
+}
+
+// step ...
+func (c *spfCheck) step(domain, term, result string) {
+	c.trace = append(c.trace, SPFStep{Depth: c.depth, Domain: domain, Term: term, Result: result})
+}
+
+// expand applies the macro expansion, RFC 7208 section 7
+func (c *spfCheck) expand(spec, domain string, exp bool) (string, error) {
+	var s strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			s.WriteByte(spec[i])
+			continue
+		}
+		if i++; i >= len(spec) {
+			return _empty, errors.New("invalid macro: " + spec)
+		}
+		switch spec[i] {
+		case '%':
+			s.WriteByte('%')
+		case '_':
+			s.WriteByte(_whitespace)
+		case '-':
+			s.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return _empty, errors.New("invalid macro: " + spec)
+			}
+			value, err := c.macro(spec[i+1:i+end], domain, exp)
+			if err != nil {
+				return _empty, err
+			}
+			s.WriteString(value)
+			i += end
+		default:
+			return _empty, errors.New("invalid macro: " + spec)
+		}
+	}
+	if exp {
+		return s.String(), nil
+	}
+	return spfTruncate(s.String()), nil
+}
+
+// macro expands a single %{...} macro body
+func (c *spfCheck) macro(body, domain string, exp bool) (string, error) {
+	if body == _empty {
+		return _empty, errors.New("empty macro")
+	}
+	letter := body[0]
+	local, senderDomain, _ := strings.Cut(c.sender, "@")
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = c.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		value = strings.Join(reverseLabels(strings.Split(reversedIP(c.ip), _dot)), _dot)
+	case 'p':
+		value = _spfUnknown
+		for _, name := range c.validatedNames() {
+			name = strings.TrimSuffix(name, _dot)
+			if strings.EqualFold(name, domain) || strings.HasSuffix(strings.ToLower(name), _dot+strings.ToLower(domain)) {
+				value = name
+				break
+			}
+			if value == _spfUnknown {
+				value = name
+			}
+		}
+	case 'v':
+		value = "in-addr"
+		if c.ip.Is6() {
+			value = "ip6"
+		}
+	case 'h':
+		value = c.helo
+	case 'c', 'r', 't':
+		if !exp {
+			return _empty, errors.New("macro %{" + body + "} only valid in exp")
+		}
+		switch letter | 0x20 {
+		case 'c':
+			value = c.ip.String()
+		case 'r':
+			value = _spfUnknown
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return _empty, errors.New("unknown macro letter: " + body)
+	}
+	rest := body[1:]
+	digits := 0
+	for len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' {
+		digits = digits*10 + int(rest[0]-'0')
+		rest = rest[1:]
+	}
+	if len(body)-1 > len(rest) && digits == 0 {
+		return _empty, errors.New("invalid macro digits: " + body)
+	}
+	reverse := false
+	if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse, rest = true, rest[1:]
+	}
+	delimiters := _dot
+	if rest != _empty {
+		if strings.Trim(rest, _spfDelimiters) != _empty {
+			return _empty, errors.New("invalid macro delimiter: " + body)
+		}
+		delimiters = rest
+	}
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		parts = reverseLabels(parts)
+	}
+	if digits > 0 && digits < len(parts) {
+		parts = parts[len(parts)-digits:]
+	}
+	value = strings.Join(parts, _dot)
+	if letter >= 'A' && letter <= 'Z' {
+		value = spfEscape(value)
+	}
+	return value, nil
+}
+
+// parseSPF parses and validates a whole record, any syntax error is a permerror
+func parseSPF(record string) ([]spfTerm, error) {
+	var terms []spfTerm
+	seen := make(map[string]bool)
+	for _, raw := range strings.Fields(record)[1:] {
+		t := spfTerm{raw: raw, qualifier: SPFPass, cidr4: 32, cidr6: 128}
+		s := raw
+		if name, value, ok := strings.Cut(s, "="); ok && spfModifierName(name) {
+			t.modifier, t.name, t.domain = true, strings.ToLower(name), value
+			if t.name == "redirect" || t.name == "exp" {
+				if seen[t.name] {
+					return nil, errors.New("duplicate modifier " + t.name)
+				}
+				seen[t.name] = true
+			}
+			if err := spfCheckMacro(value); err != nil {
+				return nil, err
+			}
+			terms = append(terms, t)
+			continue
+		}
+		switch s[0] {
+		case '+':
+			t.qualifier, s = SPFPass, s[1:]
+		case '-':
+			t.qualifier, s = SPFFail, s[1:]
+		case '~':
+			t.qualifier, s = SPFSoftFail, s[1:]
+		case '?':
+			t.qualifier, s = SPFNeutral, s[1:]
+		}
+		end := strings.IndexAny(s, ":/")
+		if end < 0 {
+			end = len(s)
+		}
+		t.name, s = strings.ToLower(s[:end]), s[end:]
+		var err error
+		switch t.name {
+		case "all":
+			if s != _empty {
+				err = errors.New("invalid term " + raw)
+			}
+		case "include", "exists":
+			if !strings.HasPrefix(s, ":") || len(s) < 2 {
+				err = errors.New("missing domain " + raw)
+			}
+			t.domain = strings.TrimPrefix(s, ":")
+		case "a", "mx":
+			t.domain, t.cidr4, t.cidr6, err = spfDualCIDR(s)
+		case "ptr":
+			if s != _empty && !strings.HasPrefix(s, ":") {
+				err = errors.New("invalid term " + raw)
+			}
+			t.domain = strings.TrimPrefix(s, ":")
+		case "ip4", "ip6":
+			t.addr, err = spfPrefix(t.name, strings.TrimPrefix(s, ":"))
+		default:
+			err = errors.New("unknown mechanism " + raw)
+		}
+		if err == nil && t.domain != _empty {
+			err = spfCheckMacro(t.domain)
+		}
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// spfDualCIDR parses [:domain-spec][/ip4-cidr][//ip6-cidr]
+func spfDualCIDR(s string) (string, int, int, error) {
+	cidr4, cidr6 := 32, 128
+	spec, cidr, _ := strings.Cut(s, "/")
+	if spec != _empty && (!strings.HasPrefix(spec, ":") || len(spec) < 2) {
+		return _empty, 0, 0, errors.New("invalid domain-spec " + s)
+	}
+	if strings.Contains(s, "/") {
+		cidr = "/" + cidr
+		v4, v6, dual := strings.Cut(cidr, "//")
+		var err error
+		if v4 != _empty {
+			if cidr4, err = spfBits(strings.TrimPrefix(v4, "/"), 32); err != nil {
+				return _empty, 0, 0, err
+			}
+		}
+		if dual {
+			if cidr6, err = spfBits(v6, 128); err != nil {
+				return _empty, 0, 0, err
+			}
+		}
+	}
+	return strings.TrimPrefix(spec, ":"), cidr4, cidr6, nil
+}
+
+// spfBits ...
+func spfBits(s string, max int) (int, error) {
+	bits, err := strconv.Atoi(s)
+	if err != nil || bits < 0 || bits > max || (len(s) > 1 && s[0] == '0') {
+		return 0, errors.New("invalid cidr length /" + s)
+	}
+	return bits, nil
+}
+
+// spfPrefix parses ip4:/ip6: networks
+func spfPrefix(name, s string) (netip.Prefix, error) {
+	addrStr, bitsStr, hasBits := strings.Cut(s, "/")
+	addr, err := netip.ParseAddr(addrStr)
+	if err != nil || (name == "ip4") != addr.Is4() {
+		return netip.Prefix{}, errors.New("invalid " + name + " address " + s)
+	}
+	bits := addr.BitLen()
+	if hasBits {
+		if bits, err = spfBits(bitsStr, addr.BitLen()); err != nil {
+			return netip.Prefix{}, err
+		}
+	}
+	return addr.Prefix(bits)
+}
+
+// spfCheckMacro validates the macro syntax of a domain-spec without expanding it
+func spfCheckMacro(spec string) error {
+	c := &spfCheck{ip: netip.IPv4Unspecified()}
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			continue
+		}
+		if i++; i >= len(spec) || !strings.ContainsRune("%_-{", rune(spec[i])) {
+			return errors.New("invalid macro: " + spec)
+		}
+		if spec[i] != '{' {
+			continue
+		}
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 0 {
+			return errors.New("invalid macro: " + spec)
+		}
+		if body := spec[i+1 : i+end]; body == _empty || body[0]|0x20 != 'p' {
+			if _, err := c.macro(body, _empty, true); err != nil {
+				return err
+			}
+		}
+		i += end
+	}
+	return nil
+}
+
+// spfModifierName ...
+func spfModifierName(name string) bool {
+	if name == _empty || !(name[0]|0x20 >= 'a' && name[0]|0x20 <= 'z') {
+		return false
+	}
+	return strings.Trim(strings.ToLower(name), "abcdefghijklmnopqrstuvwxyz0123456789-_.") == _empty
+}
+
+// spfValidDomain requires a multi-label name within the dns length limits
+func spfValidDomain(domain string) bool {
+	_, ok := dns.IsDomainName(domain)
+	return ok && len(domain) <= _spfDomainMax && dns.CountLabel(domain) > 1
+}
+
+// spfTruncate drops leading labels until the name fits into 253 chars
+func spfTruncate(name string) string {
+	for len(name) > _spfDomainMax {
+		_, rest, ok := strings.Cut(name, _dot)
+		if !ok {
+			break
+		}
+		name = rest
+	}
+	return name
+}
+
+// spfEscape url-encodes all but the unreserved characters, RFC 3986
+func spfEscape(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("-._~", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[ch>>4])
+		b.WriteByte(hexDigits[ch&15])
+	}
+	return b.String()
+}
+
+// reverseLabels ...
+func reverseLabels(labels []string) []string {
+	out := make([]string, len(labels))
+	for i, l := range labels {
+		out[len(labels)-1-i] = l
+	}
+	return out
+}
+
+// String ...
+func (s SPFResult) String() string {
+	switch s {
+	case SPFNeutral:
+		return "neutral"
+	case SPFPass:
+		return "pass"
+	case SPFFail:
+		return "fail"
+	case SPFSoftFail:
+		return "softfail"
+	case SPFTempError:
+		return "temperror"
+	case SPFPermError:
+		return "permerror"
+	}
+	return "none"
+}
+
+// String renders the trace, one indented line per step
+func (s *SPF) String() string {
+	var b strings.Builder
+	for _, step := range s.Trace {
+		b.WriteString(strings.Repeat("  ", step.Depth) + step.Domain + _sep + step.Term + _sep + step.Result + _linefeed)
+	}
+	b.WriteString(s.Result.String())
+	if s.Explanation != _empty {
+		b.WriteString(_sep + s.Explanation)
+	}
+	return b.String() + _linefeed
+}
@@ -0,0 +1,155 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// _spfZone ...
+const _spfZone = `ip4		IN TXT	"v=spf1 ip4:192.0.2.0/24 -all"
+ip6		IN TXT	"v=spf1 ip6:2001:db8::/32 ~all"
+a		IN TXT	"v=spf1 a/24 -all"
+a		IN A	192.0.2.200
+mx		IN TXT	"v=spf1 mx -all"
+mx		IN MX	10 mail
+include		IN TXT	"v=spf1 include:ip4.example.com ?all"
+redirect	IN TXT	"v=spf1 redirect=ip6.example.com"
+exists		IN TXT	"v=spf1 exists:%{ir}.%{l}.list.example.com -all"
+2.2.0.192.user.list IN A 127.0.0.2
+ptr		IN TXT	"v=spf1 ptr -all"
+5.2.0.192.in-addr.arpa. IN PTR host.ptr.example.com.
+host.ptr	IN A	192.0.2.5
+exp		IN TXT	"v=spf1 -all exp=why.example.com"
+why		IN TXT	"%{i} is not allowed to send for %{d}"
+multi		IN TXT	"v=spf1 -all"
+multi		IN TXT	"v=spf1 +all"
+syntax		IN TXT	"v=spf1 foo:bar -all"
+voids		IN TXT	"v=spf1 a:nx1.example.com a:nx2.example.com a:nx3.example.com -all"
+twovoids	IN TXT	"v=spf1 a:nx1.example.com a:nx2.example.com -all"
+includevoid	IN TXT	"v=spf1 include:nospf.example.com -all"
+nospf		IN TXT	"not an spf record"
+loop		IN TXT	"v=spf1 include:loop.example.com -all"
+temp		IN TXT	"v=spf1 a:broken.example.com -all"
+deep		IN TXT	"v=spf1 include:temp.example.com -all"
+`
+
+// checkHost runs CheckHost for ip and domain with sender user@domain
+func checkHost(t *testing.T, r *dnsresolver.Resolver, ip, domain string) (*dnsresolver.SPF, error) {
+	t.Helper()
+	return r.CheckHost(netip.MustParseAddr(ip), domain, "user@"+domain, "mail.example.org")
+}
+
+func TestCheckHost(t *testing.T) {
+	s := newServer(t, _testZone+_spfZone)
+	r := s.Resolver()
+	for _, tc := range []struct {
+		ip     string
+		domain string
+		want   dnsresolver.SPFResult
+	}{
+		{"192.0.2.1", "ip4.example.com", dnsresolver.SPFPass},
+		{"198.51.100.1", "ip4.example.com", dnsresolver.SPFFail},
+		{"2001:db8::1", "ip6.example.com", dnsresolver.SPFPass},
+		{"2001:db9::1", "ip6.example.com", dnsresolver.SPFSoftFail},
+		{"192.0.2.7", "a.example.com", dnsresolver.SPFPass},
+		{"192.0.2.25", "mx.example.com", dnsresolver.SPFPass},
+		{"192.0.2.26", "mx.example.com", dnsresolver.SPFFail},
+		{"192.0.2.1", "include.example.com", dnsresolver.SPFPass},
+		{"198.51.100.1", "include.example.com", dnsresolver.SPFNeutral},
+		{"2001:db8::1", "redirect.example.com", dnsresolver.SPFPass},
+		{"2001:db9::1", "redirect.example.com", dnsresolver.SPFSoftFail},
+		{"192.0.2.3", "exists.example.com", dnsresolver.SPFFail}, // no list entry for 3.2.0.192.user
+		{"192.0.2.5", "ptr.example.com", dnsresolver.SPFPass},
+		{"192.0.2.6", "ptr.example.com", dnsresolver.SPFFail},
+		{"192.0.2.1", "www.example.com", dnsresolver.SPFNone},
+		{"192.0.2.1", "nx.example.com", dnsresolver.SPFNone},
+		{"192.0.2.1", "localhost", dnsresolver.SPFNone},
+		{"192.0.2.1", "twovoids.example.com", dnsresolver.SPFFail},
+	} {
+		spf, err := checkHost(t, r, tc.ip, tc.domain)
+		if err != nil || spf.Result != tc.want {
+			t.Errorf("%s %s: got %s %v, want %s\n%s", tc.domain, tc.ip, spf.Result, err, tc.want, spf)
+		}
+	}
+}
+
+func TestCheckHostMacros(t *testing.T) {
+	s := newServer(t, _testZone+_spfZone)
+	spf, err := s.Resolver().CheckHost(netip.MustParseAddr("192.0.2.2"), "exists.example.com", "user@example.org", "mail.example.org")
+	if err != nil || spf.Result != dnsresolver.SPFPass {
+		t.Errorf("got %s %v\n%s", spf.Result, err, spf)
+	}
+	spf, err = checkHost(t, s.Resolver(), "192.0.2.9", "exp.example.com")
+	if err != nil || spf.Result != dnsresolver.SPFFail || spf.Explanation != "192.0.2.9 is not allowed to send for exp.example.com" {
+		t.Errorf("got %s %q %v", spf.Result, spf.Explanation, err)
+	}
+}
+
+func TestCheckHostErrors(t *testing.T) {
+	s := newServer(t, _testZone+_spfZone)
+	s.SetFault("broken.example.com", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	r := s.Resolver()
+	for _, tc := range []struct {
+		domain string
+		want   dnsresolver.SPFResult
+		origin string
+		term   string
+	}{
+		{"multi.example.com", dnsresolver.SPFPermError, "multi.example.com", ""},
+		{"syntax.example.com", dnsresolver.SPFPermError, "syntax.example.com", ""},
+		{"voids.example.com", dnsresolver.SPFPermError, "voids.example.com", "a:nx3.example.com"},
+		{"includevoid.example.com", dnsresolver.SPFPermError, "nospf.example.com", ""},
+		{"loop.example.com", dnsresolver.SPFPermError, "loop.example.com", "include:loop.example.com"},
+		{"temp.example.com", dnsresolver.SPFTempError, "temp.example.com", "a:broken.example.com"},
+		{"deep.example.com", dnsresolver.SPFTempError, "temp.example.com", "a:broken.example.com"},
+	} {
+		spf, err := checkHost(t, r, "192.0.2.1", tc.domain)
+		var spfErr *dnsresolver.SPFError
+		switch {
+		case spf.Result != tc.want:
+			t.Errorf("%s: got %s %v, want %s\n%s", tc.domain, spf.Result, err, tc.want, spf)
+		case !errors.As(err, &spfErr):
+			t.Errorf("%s: got %v, want SPFError", tc.domain, err)
+		case spfErr.Domain != tc.origin || spfErr.Term != tc.term || spfErr.Result != tc.want:
+			t.Errorf("%s: got %+v, want %s %q", tc.domain, spfErr, tc.origin, tc.term)
+		case !strings.HasPrefix(err.Error(), "[dnsinfo] [spf] "+tc.origin):
+			t.Errorf("%s: message %q", tc.domain, err)
+		}
+	}
+}
+
+func TestCheckHostVoids(t *testing.T) {
+	s := newServer(t, _testZone+_spfZone)
+	r := s.Resolver()
+	for _, tc := range []struct {
+		domain string
+		voids  int
+	}{
+		{"nx.example.com", 0},  // the record lookup is no void lookup
+		{"www.example.com", 0}, // no TXT for the policy record
+		{"twovoids.example.com", 2},
+	} {
+		if spf, _ := checkHost(t, r, "192.0.2.1", tc.domain); spf.Voids != tc.voids {
+			t.Errorf("%s: voids %d, want %d\n%s", tc.domain, spf.Voids, tc.voids, spf)
+		}
+	}
+}
+
+func TestCheckHostLookupLimit(t *testing.T) {
+	zone := _testZone
+	for i := range 11 {
+		zone += "l" + string(rune('a'+i)) + " IN TXT \"v=spf1 include:l" + string(rune('a'+i+1)) + ".example.com -all\"\n"
+	}
+	zone += "ll IN TXT \"v=spf1 +all\"\n"
+	s := newServer(t, zone)
+	spf, err := checkHost(t, s.Resolver(), "192.0.2.1", "la.example.com")
+	if spf.Result != dnsresolver.SPFPermError || !strings.Contains(err.Error(), "dns lookup limit exceeded") || spf.Lookups != 11 {
+		t.Errorf("got %s %d %v", spf.Result, spf.Lookups, err)
+	}
+}
@@ -0,0 +1,169 @@
+package dnsresolver
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_svcbAliasMax = 8
+	_httpsPort    = 443
+	_errSVCB      = "[dnsinfo] [svcb] "
+)
+
+// ServiceBinding is a ServiceMode SVCB/HTTPS record, RFC 9460
+type ServiceBinding struct {
+	// Name the record was found at, after following AliasMode records
+	Name string
+	// Priority, lower is preferred
+	Priority uint16
+	// Target host, "." already replaced by Name
+	Target string
+	// ALPN protocol ids, eg. h3, h2
+	ALPN []string
+	// NoDefaultALPN excludes the default protocol (http/1.1 for HTTPS)
+	NoDefaultALPN bool
+	// Port alternative port (0: default port)
+	Port uint16
+	// IPv4Hint, IPv6Hint address hints, the Target addresses take precedence
+	IPv4Hint []netip.Addr
+	IPv6Hint []netip.Addr
+	// ECH encoded ECHConfigList
+	ECH []byte
+	// Mandatory keys the client has to understand
+	Mandatory []string
+	// DoHPath uri template, RFC 9461
+	DoHPath string
+	// Params holds all other keys, presentation format
+	Params map[string]string
+}
+
+// LookupSVCB resolves the SVCB records of name (eg. _dns.resolver.arpa or _8443._foo.example.com)
+func (r *Resolver) LookupSVCB(name string) ([]ServiceBinding, error) {
+	return r.lookupBindings(dns.Fqdn(name), dns.TypeSVCB)
+}
+
+// LookupHTTPS resolves the HTTPS records of host, host:port queries _port._https.host for
+// ports other than 443
+func (r *Resolver) LookupHTTPS(host string) ([]ServiceBinding, error) {
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		name = h
+		if p, err := strconv.Atoi(port); err == nil && p != _httpsPort {
+			name = "_" + port + "._https." + h
+		}
+	}
+	return r.lookupBindings(dns.Fqdn(name), dns.TypeHTTPS)
+}
+
+// lookupBindings follows AliasMode records and returns the ServiceMode records by priority,
+// ServiceMode records next to an AliasMode one are ignored (RFC 9460 section 2.4.2), records
+// with unsupported mandatory keys are skipped
+func (r *Resolver) lookupBindings(name string, rType uint16) ([]ServiceBinding, error) {
+	for range _svcbAliasMax {
+		rsp, err := r.resolve(name, rType)
+		if err != nil {
+			return nil, errors.New(_errSVCB + name + _sep + err.Error())
+		}
+		var alias string
+		var bindings []ServiceBinding
+		for _, rr := range rsp.Answer {
+			var svcb *dns.SVCB
+			switch v := rr.(type) {
+			case *dns.SVCB:
+				svcb = v
+			case *dns.HTTPS:
+				svcb = &v.SVCB
+			default:
+				continue
+			}
+			if svcb.Priority == 0 {
+				alias = svcb.Target
+				continue
+			}
+			if b, ok := serviceBinding(rr.Header().Name, svcb); ok { // owner name, also behind a CNAME
+				bindings = append(bindings, b)
+			}
+		}
+		switch {
+		case alias == _dot:
+			return nil, errors.New(_errSVCB + name + _sep + "service not available")
+		case alias != _empty:
+			name = alias
+		case len(bindings) > 0:
+			sort.SliceStable(bindings, func(i, j int) bool { return bindings[i].Priority < bindings[j].Priority })
+			return bindings, nil
+		default:
+			return nil, errors.New(_errSVCB + name + _sep + _noAnswer)
+		}
+	}
+	return nil, errors.New(_errSVCB + name + _sep + "alias chain too long")
+}
+
+// serviceBinding ...
+func serviceBinding(name string, svcb *dns.SVCB) (ServiceBinding, bool) {
+	b := ServiceBinding{Name: name, Priority: svcb.Priority, Target: svcb.Target}
+	if b.Target == _dot {
+		b.Target = name
+	}
+	for _, kv := range svcb.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			b.ALPN = v.Alpn
+		case *dns.SVCBNoDefaultAlpn:
+			b.NoDefaultALPN = true
+		case *dns.SVCBPort:
+			b.Port = v.Port
+		case *dns.SVCBIPv4Hint:
+			b.IPv4Hint = hintAddrs(v.Hint)
+		case *dns.SVCBIPv6Hint:
+			b.IPv6Hint = hintAddrs(v.Hint)
+		case *dns.SVCBECHConfig:
+			b.ECH = v.ECH
+		case *dns.SVCBMandatory:
+			for _, key := range v.Code {
+				b.Mandatory = append(b.Mandatory, key.String())
+			}
+		case *dns.SVCBDoHPath:
+			b.DoHPath = v.Template
+		default:
+			if b.Params == nil {
+				b.Params = make(map[string]string)
+			}
+			b.Params[kv.Key().String()] = kv.String()
+		}
+	}
+	for _, key := range b.Mandatory {
+		if _, ok := b.Params[key]; ok {
+			return b, false // unsupported mandatory key
+		}
+	}
+	return b, true
+}
+
+// hintAddrs ...
+func hintAddrs(ips []net.IP) []netip.Addr {
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs
+}
+
+// String returns the binding as target:port with its alpn list
+func (b ServiceBinding) String() string {
+	port := _empty
+	if b.Port != 0 {
+		port = ":" + strconv.Itoa(int(b.Port))
+	}
+	return strconv.Itoa(int(b.Priority)) + _sep + strings.TrimSuffix(b.Target, _dot) + port + _sep + strings.Join(b.ALPN, ",")
+}
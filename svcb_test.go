@@ -0,0 +1,82 @@
+package dnsresolver_test
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// _svcbZone ...
+const _svcbZone = `svc		IN HTTPS	2 alt.example.com. alpn=h2
+svc		IN HTTPS	1 . alpn=h3,h2 no-default-alpn port=8443 ipv4hint=192.0.2.1 ipv6hint=2001:db8::1 ech=AEX+DQBB key65001=custom
+aliased		IN HTTPS	0 svc.example.com.
+gone		IN HTTPS	0 .
+mixed		IN HTTPS	0 svc.example.com.
+mixed		IN HTTPS	1 . alpn=h2
+mixedgone	IN HTTPS	0 .
+mixedgone	IN HTTPS	1 . alpn=h2
+mandatory	IN HTTPS	1 . mandatory=key65000 key65000=foo
+mandatory	IN HTTPS	2 . alpn=h2
+loop1		IN HTTPS	0 loop2.example.com.
+loop2		IN HTTPS	0 loop1.example.com.
+_8443._https.svc IN HTTPS	1 port8443.example.com.
+_dns.resolver	IN SVCB		1 dot.example.com. alpn=dot dohpath=/dns-query{?dns}
+`
+
+func TestLookupHTTPS(t *testing.T) {
+	s := newServer(t, _testZone+_svcbZone)
+	r := s.Resolver()
+	for _, host := range []string{"svc.example.com", "aliased.example.com", "svc.example.com:443", "mixed.example.com"} { // mixed: alias wins
+		b, err := r.LookupHTTPS(host)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if len(b) != 2 || b[0].Priority != 1 || b[1].Priority != 2 {
+			t.Fatalf("%s: got %v, want priority order", host, b)
+		}
+		want := "svc.example.com."
+		if b[0].Name != want || b[0].Target != want || b[1].Target != "alt.example.com." {
+			t.Errorf("%s: name %s targets %s %s", host, b[0].Name, b[0].Target, b[1].Target)
+		}
+		p := b[0]
+		if !slices.Equal(p.ALPN, []string{"h3", "h2"}) || !p.NoDefaultALPN || p.Port != 8443 ||
+			!slices.Equal(p.IPv4Hint, []netip.Addr{netip.MustParseAddr("192.0.2.1")}) ||
+			!slices.Equal(p.IPv6Hint, []netip.Addr{netip.MustParseAddr("2001:db8::1")}) ||
+			len(p.ECH) != 6 || p.Params["key65001"] != "custom" {
+			t.Errorf("%s: got %+v", host, p)
+		}
+		if got := p.String(); got != "1\tsvc.example.com:8443\th3,h2" {
+			t.Errorf("%s: String %q", host, got)
+		}
+	}
+}
+
+func TestLookupHTTPSPort(t *testing.T) {
+	s := newServer(t, _testZone+_svcbZone)
+	b, err := s.Resolver().LookupHTTPS("svc.example.com:8443")
+	if err != nil || len(b) != 1 || b[0].Name != "_8443._https.svc.example.com." || b[0].Target != "port8443.example.com." {
+		t.Errorf("got %v %v", b, err)
+	}
+}
+
+func TestLookupHTTPSErrors(t *testing.T) {
+	s := newServer(t, _testZone+_svcbZone)
+	r := s.Resolver()
+	for _, host := range []string{"gone.example.com", "mixedgone.example.com", "loop1.example.com", "www.example.com", "nx.example.com"} {
+		if b, err := r.LookupHTTPS(host); err == nil {
+			t.Errorf("%s: got %v, want error", host, b)
+		}
+	}
+	b, err := r.LookupHTTPS("mandatory.example.com")
+	if err != nil || len(b) != 1 || b[0].Priority != 2 {
+		t.Errorf("mandatory: got %v %v, want the record with unsupported mandatory key skipped", b, err)
+	}
+}
+
+func TestLookupSVCB(t *testing.T) {
+	s := newServer(t, _testZone+_svcbZone)
+	b, err := s.Resolver().LookupSVCB("_dns.resolver.example.com")
+	if err != nil || len(b) != 1 || !slices.Equal(b[0].ALPN, []string{"dot"}) || b[0].DoHPath != "/dns-query{?dns}" {
+		t.Errorf("got %+v %v", b, err)
+	}
+}
@@ -0,0 +1,376 @@
+package dnsresolver
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_traceMaxDepth = 32
+	_errTrace      = "[dnsinfo] [trace] "
+)
+
+// RootServers are the root hints Trace starts from (name, addresses), replace as needed
+var RootServers = map[string][]netip.Addr{
+	"a.root-servers.net.": {netip.MustParseAddr("198.41.0.4"), netip.MustParseAddr("2001:503:ba3e::2:30")},
+	"b.root-servers.net.": {netip.MustParseAddr("170.247.170.2"), netip.MustParseAddr("2801:1b8:10::b")},
+	"c.root-servers.net.": {netip.MustParseAddr("192.33.4.12"), netip.MustParseAddr("2001:500:2::c")},
+	"d.root-servers.net.": {netip.MustParseAddr("199.7.91.13"), netip.MustParseAddr("2001:500:2d::d")},
+	"e.root-servers.net.": {netip.MustParseAddr("192.203.230.10"), netip.MustParseAddr("2001:500:a8::e")},
+	"f.root-servers.net.": {netip.MustParseAddr("192.5.5.241"), netip.MustParseAddr("2001:500:2f::f")},
+	"g.root-servers.net.": {netip.MustParseAddr("192.112.36.4"), netip.MustParseAddr("2001:500:12::d0d")},
+	"h.root-servers.net.": {netip.MustParseAddr("198.97.190.53"), netip.MustParseAddr("2001:500:1::53")},
+	"i.root-servers.net.": {netip.MustParseAddr("192.36.148.17"), netip.MustParseAddr("2001:7fe::53")},
+	"j.root-servers.net.": {netip.MustParseAddr("192.58.128.30"), netip.MustParseAddr("2001:503:c27::2:30")},
+	"k.root-servers.net.": {netip.MustParseAddr("193.0.14.129"), netip.MustParseAddr("2001:7fd::1")},
+	"l.root-servers.net.": {netip.MustParseAddr("199.7.83.42"), netip.MustParseAddr("2001:500:9f::42")},
+	"m.root-servers.net.": {netip.MustParseAddr("202.12.27.33"), netip.MustParseAddr("2001:dc3::35")},
+}
+
+// Trace is the delegation path of a query, from the root servers down to the authoritative ones
+type Trace struct {
+	Query string
+	Type  uint16
+	Steps []TraceStep
+	// Answer of the authoritative servers, empty for NXDOMAIN and NODATA, CNAMEs are not followed
+	Answer []dns.RR
+	Rcode  int
+}
+
+// TraceStep is a zone on the delegation path and the answers of all its servers
+type TraceStep struct {
+	Zone    string
+	Servers []TraceServer
+	// DNSKEY the zone publishes a DNSKEY RRset
+	DNSKEY bool
+	// Referral child zone, empty for the final step
+	Referral string
+	// NS name servers of the child zone
+	NS []string
+	// Glue addresses of the additional section, name servers without glue are resolved via the resolver
+	Glue map[string][]netip.Addr
+	// DS the referral carries a DS RRset (signed delegation)
+	DS bool
+	// Inconsistencies among the servers, differing rcodes, referrals and answers, lame servers
+	Inconsistencies []string
+}
+
+// TraceServer is the answer of a single server
+type TraceServer struct {
+	Name  string
+	Addr  netip.Addr
+	Proto string
+	RTT   time.Duration
+	Rcode int
+	// Authoritative answer flag
+	Authoritative bool
+	// Msg nil on failure
+	Msg *dns.Msg
+	Err error
+}
+
+// Trace resolves name iteratively like dig +trace, every server of every zone on the path is
+// asked (non recursive, DNSSEC OK), Sources, Cache, TSIG and DoT of the resolver are bypassed,
+// followed is the first server with a referral or an authoritative answer
+func (r *Resolver) Trace(name string, rType uint16) (*Trace, error) {
+	t := &Trace{Query: dns.CanonicalName(name), Type: rType}
+	zone, servers := _dot, RootServers
+	for range _traceMaxDepth {
+		step := TraceStep{Zone: zone, Servers: r.traceServers(t.Query, rType, servers)}
+		step.Inconsistencies = traceInconsistencies(t.Query, zone, step.Servers)
+		server, ok := traceSelect(t.Query, zone, step.Servers)
+		if !ok {
+			t.Steps = append(t.Steps, step)
+			return t, errors.New(_errTrace + zone + _sep + "no server answered with a referral or authoritatively")
+		}
+		rsp := server.Msg
+		step.DNSKEY = r.traceDNSKEY(zone, server)
+		child, ns := referral(t.Query, zone, rsp)
+		if child == _empty {
+			t.Steps = append(t.Steps, step)
+			t.Answer, t.Rcode = rsp.Answer, rsp.Rcode
+			return t, nil
+		}
+		step.Referral, step.NS, step.Glue = child, ns, make(map[string][]netip.Addr)
+		for _, rr := range rsp.Ns {
+			if ds, ok := rr.(*dns.DS); ok && dns.CanonicalName(ds.Hdr.Name) == child {
+				step.DS = true
+			}
+		}
+		for _, addr := range rsp.Extra {
+			owner := dns.CanonicalName(addr.Header().Name)
+			if !slices.Contains(ns, owner) {
+				continue
+			}
+			if ips := rrAddrs([]dns.RR{addr}); len(ips) > 0 {
+				step.Glue[owner] = append(step.Glue[owner], ips...)
+			}
+		}
+		servers = make(map[string][]netip.Addr, len(ns))
+		for _, host := range ns {
+			servers[host] = step.Glue[host]
+			if len(servers[host]) == 0 {
+				servers[host], _ = r.resolveAddrs(host, []uint16{dns.TypeA, dns.TypeAAAA})
+			}
+		}
+		t.Steps = append(t.Steps, step)
+		zone = child
+	}
+	return t, errors.New(_errTrace + t.Query + _sep + "delegation path too long")
+}
+
+// traceServers asks all servers concurrently, sorted by name
+func (r *Resolver) traceServers(name string, rType uint16, servers map[string][]netip.Addr) []TraceServer {
+	hosts := make([]string, 0, len(servers))
+	for host := range servers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	results := make([]TraceServer, len(hosts))
+	var bg sync.WaitGroup
+	for i, host := range hosts {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			results[i] = r.traceHost(host, servers[host], name, rType)
+		}()
+	}
+	bg.Wait()
+	return results
+}
+
+// traceHost asks the addresses of host in turn until one answers, returns the last failure
+// when none does
+func (r *Resolver) traceHost(host string, addrs []netip.Addr, name string, rType uint16) TraceServer {
+	addrs = r.traceAddrs(addrs)
+	if len(addrs) == 0 {
+		return r.traceQuery(host, _emptyAddr, name, rType)
+	}
+	var s TraceServer
+	for _, addr := range addrs {
+		if s = r.traceQuery(host, addr, name, rType); s.Err == nil {
+			break
+		}
+	}
+	return s
+}
+
+// traceAddrs returns the addresses of the enabled families, ip4 first
+func (r *Resolver) traceAddrs(addrs []netip.Addr) []netip.Addr {
+	var out []netip.Addr
+	for _, want4 := range []bool{true, false} {
+		for _, addr := range addrs {
+			if addr.Is4() == want4 && !(addr.Is4() && r.NoIP4) && !(!addr.Is4() && r.NoIP6) {
+				out = append(out, addr)
+			}
+		}
+	}
+	return out
+}
+
+// traceQuery sends a non recursive query, falls back to tcp on truncation
+func (r *Resolver) traceQuery(host string, addr netip.Addr, name string, rType uint16) TraceServer {
+	s := TraceServer{Name: host, Addr: addr, Proto: _udp}
+	if !addr.IsValid() {
+		s.Err = errors.New(_errTrace + host + _sep + "no usable address")
+		return s
+	}
+	if r.NoUDP {
+		s.Proto = _tcp
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, rType)
+	msg.RecursionDesired = false
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+	port := r.TracePort
+	if port == 0 {
+		port = _dnsPortNum
+	}
+	server := netip.AddrPortFrom(addr, port).String()
+	for {
+		client := &dns.Client{Net: s.Proto, Timeout: r.Timeout}
+		rsp, rtt, err := client.Exchange(msg, server)
+		s.RTT += rtt
+		if err == nil && rsp.Truncated && s.Proto == _udp && !r.NoTCP {
+			s.Proto = _tcp
+			continue
+		}
+		if err != nil {
+			s.Err = errors.New(_errTrace + host + _sep + server + _sep + s.Proto + _sep + err.Error())
+			return s
+		}
+		s.Msg, s.Rcode, s.Authoritative = rsp, rsp.Rcode, rsp.Authoritative
+		return s
+	}
+}
+
+// traceDNSKEY asks the server for the DNSKEY RRset of zone
+func (r *Resolver) traceDNSKEY(zone string, server TraceServer) bool {
+	s := r.traceQuery(server.Name, server.Addr, zone, dns.TypeDNSKEY)
+	if s.Err != nil {
+		return false
+	}
+	for _, rr := range s.Msg.Answer {
+		if rr.Header().Rrtype == dns.TypeDNSKEY {
+			return true
+		}
+	}
+	return false
+}
+
+// referral returns the child zone and its sorted name servers, when rsp delegates name
+// further down than zone
+func referral(name, zone string, rsp *dns.Msg) (string, []string) {
+	if len(rsp.Answer) > 0 || rsp.Rcode != dns.RcodeSuccess {
+		return _empty, nil
+	}
+	var child string
+	var ns []string
+	for _, rr := range rsp.Ns {
+		v, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		owner := dns.CanonicalName(v.Hdr.Name)
+		if owner == dns.CanonicalName(zone) || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, name) {
+			continue
+		}
+		if child != _empty && owner != child {
+			continue
+		}
+		child = owner
+		ns = append(ns, dns.CanonicalName(v.Ns))
+	}
+	sort.Strings(ns)
+	return child, slices.Compact(ns)
+}
+
+// traceSelect returns the first server with a referral or an authoritative answer (NOERROR,
+// NXDOMAIN), lame, failing (REFUSED, SERVFAIL) and unreachable servers are passed over
+func traceSelect(name, zone string, servers []TraceServer) (TraceServer, bool) {
+	for _, s := range servers {
+		if s.Err != nil {
+			continue
+		}
+		if child, _ := referral(name, zone, s.Msg); child != _empty {
+			return s, true
+		}
+		if s.Authoritative && (s.Rcode == dns.RcodeSuccess || s.Rcode == dns.RcodeNameError) {
+			return s, true
+		}
+	}
+	return TraceServer{}, false
+}
+
+// traceInconsistencies compares every server with the one the trace follows (the first
+// responding one when none qualifies), flags unreachable and lame servers
+func traceInconsistencies(name, zone string, servers []TraceServer) []string {
+	ref, ok := traceSelect(name, zone, servers)
+	for i := 0; !ok && i < len(servers); i++ {
+		ref, ok = servers[i], servers[i].Err == nil
+	}
+	var out []string
+	for _, s := range servers {
+		if s.Err != nil {
+			out = append(out, s.Name+_sep+"unreachable")
+			continue
+		}
+		child, _ := referral(name, zone, s.Msg)
+		if child == _empty && !s.Authoritative {
+			out = append(out, s.Name+_sep+"lame, no referral and not authoritative")
+		}
+		if s.Name == ref.Name {
+			continue
+		}
+		key, refKey := traceKey(name, zone, s), traceKey(name, zone, ref)
+		switch {
+		case s.Rcode != ref.Rcode:
+			out = append(out, s.Name+_sep+"rcode "+dns.RcodeToString[s.Rcode]+", "+ref.Name+" "+dns.RcodeToString[ref.Rcode])
+		case key != refKey && child != _empty:
+			out = append(out, s.Name+_sep+"referral differs from "+ref.Name)
+		case key != refKey:
+			out = append(out, s.Name+_sep+"answer differs from "+ref.Name)
+		}
+	}
+	return out
+}
+
+// traceKey summarizes rcode, referral and answer of a server, for comparison
+func traceKey(name, zone string, s TraceServer) string {
+	child, ns := referral(name, zone, s.Msg)
+	return dns.RcodeToString[s.Rcode] + _sep + child + _sep + strings.Join(ns, " ") + _sep + strings.Join(traceRRs(s.Msg.Answer), " ")
+}
+
+// traceRRs returns the sorted records without ttl, for comparison
+func traceRRs(rrs []dns.RR) []string {
+	out := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		h := rr.Header()
+		out = append(out, h.Name+_sep+dns.Type(h.Rrtype).String()+_sep+rdataString(rr))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// String renders the trace as text, one block per zone
+func (t *Trace) String() string {
+	var s strings.Builder
+	s.WriteString(";; trace " + t.Query + " " + dns.Type(t.Type).String() + _linefeed)
+	for _, step := range t.Steps {
+		s.WriteString(_linefeed + ";; zone " + step.Zone)
+		if step.DNSKEY {
+			s.WriteString(" DNSKEY")
+		}
+		s.WriteString(_linefeed)
+		for _, srv := range step.Servers {
+			s.WriteString(srv.Name + _sep)
+			if srv.Addr.IsValid() {
+				s.WriteString(srv.Addr.String() + _sep)
+			}
+			if srv.Err != nil {
+				s.WriteString(_rfail + srv.Err.Error() + _linefeed)
+				continue
+			}
+			aa := _empty
+			if srv.Authoritative {
+				aa = _sep + "aa"
+			}
+			s.WriteString(srv.Proto + _sep + srv.RTT.Round(time.Microsecond).String() + _sep + dns.RcodeToString[srv.Rcode] + aa + _linefeed)
+		}
+		if step.Referral != _empty {
+			s.WriteString(";; referral " + step.Referral)
+			if step.DS {
+				s.WriteString(" DS")
+			}
+			s.WriteString(_linefeed)
+			for _, host := range step.NS {
+				var glue []string
+				for _, addr := range step.Glue[host] {
+					glue = append(glue, addr.String())
+				}
+				s.WriteString(";;   " + host + _sep + strings.Join(glue, " ") + _linefeed)
+			}
+		}
+		for _, inconsistency := range step.Inconsistencies {
+			s.WriteString(";; ! " + inconsistency + _linefeed)
+		}
+	}
+	if len(t.Steps) > 0 && t.Steps[len(t.Steps)-1].Referral == _empty {
+		s.WriteString(_linefeed + ";; " + dns.RcodeToString[t.Rcode] + _linefeed)
+		for _, rr := range t.Answer {
+			s.WriteString(rr.String() + _linefeed)
+		}
+	}
+	return s.String()
+}
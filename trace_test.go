@@ -0,0 +1,140 @@
+package dnsresolver_test
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// newServerAddr starts a harness server for h on addr, skips where the loopback address is missing
+func newServerAddr(t *testing.T, h dns.Handler, addr string) *dnsresolvertest.Server {
+	t.Helper()
+	s, err := dnsresolvertest.NewServerHandlerAddr(h, addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// delegation answers every query with a referral to the name servers of child and their glue,
+// DNSKEY queries for the own zone authoritatively with keys
+func delegation(zone, child string, ns, glue, keys []dns.RR) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, m *dns.Msg) {
+		rsp := new(dns.Msg)
+		rsp.SetReply(m)
+		if q := m.Question[0]; q.Qtype == dns.TypeDNSKEY && dns.CanonicalName(q.Name) == zone {
+			rsp.Authoritative, rsp.Answer = true, keys
+		} else {
+			rsp.Ns, rsp.Extra = ns, glue
+		}
+		w.WriteMsg(rsp)
+	}
+}
+
+// lame answers NOERROR, neither authoritative nor with a referral
+func lame(w dns.ResponseWriter, m *dns.Msg) {
+	rsp := new(dns.Msg)
+	rsp.SetReply(m)
+	w.WriteMsg(rsp)
+}
+
+// traceHierarchy starts a fake root, com. and example.com. on one port of several loopback
+// addresses, sorted first in each zone are a failing com. server and a lame example.com. server
+func traceHierarchy(t *testing.T) (*dnsresolver.Resolver, *dnsresolvertest.Server) {
+	t.Helper()
+	key := rr(t, ". 3600 IN DNSKEY 257 3 13 AwEAAQ==")
+	root, err := dnsresolvertest.NewServerHandlerAddr(delegation(".", "com.",
+		[]dns.RR{rr(t, "com. 172800 IN NS a.nic.com."), rr(t, "com. 172800 IN NS b.nic.com."), rr(t, "com. 86400 IN DS 19718 13 2 8ACBB0CD28F41250A80A491389424D341522D946B0DA0C0291F2D3D771D7805A")},
+		[]dns.RR{rr(t, "a.nic.com. 172800 IN A 127.0.0.5"), rr(t, "b.nic.com. 172800 IN A 127.0.0.2")},
+		[]dns.RR{key}), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	_, p, _ := net.SplitHostPort(root.Addr)
+	port, _ := strconv.ParseUint(p, 10, 16)
+	tld := delegation("com.", "example.com.",
+		[]dns.RR{rr(t, "example.com. 172800 IN NS ns.example.com."), rr(t, "example.com. 172800 IN NS a-lame.example.com.")},
+		[]dns.RR{rr(t, "ns.example.com. 172800 IN A 127.0.0.3"), rr(t, "a-lame.example.com. 172800 IN A 127.0.0.4")}, nil)
+	broken := newServerAddr(t, tld, "127.0.0.5:"+p)
+	broken.SetFault("", dnsresolvertest.Fault{Rcode: dns.RcodeServerFailure})
+	good := newServerAddr(t, tld, "127.0.0.2:"+p)
+	newServerAddr(t, newServer(t, _testZone), "127.0.0.3:"+p)
+	newServerAddr(t, dns.HandlerFunc(lame), "127.0.0.4:"+p)
+	roots := dnsresolver.RootServers
+	dnsresolver.RootServers = map[string][]netip.Addr{"a.root-servers.test.": {netip.MustParseAddr("127.0.0.1")}}
+	t.Cleanup(func() { dnsresolver.RootServers = roots })
+	r := root.Resolver()
+	r.TracePort = uint16(port)
+	return r, good
+}
+
+func TestTrace(t *testing.T) {
+	r, _ := traceHierarchy(t)
+	trace, err := r.Trace("www.example.com", dns.TypeA)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, trace)
+	}
+	var zones []string
+	for _, step := range trace.Steps {
+		zones = append(zones, step.Zone)
+	}
+	if !slices.Equal(zones, []string{".", "com.", "example.com."}) {
+		t.Fatalf("zones: got %v\n%s", zones, trace)
+	}
+	if trace.Rcode != dns.RcodeSuccess || len(trace.Answer) != 1 || trace.Answer[0].(*dns.A).A.String() != "192.0.2.80" {
+		t.Errorf("answer: got %s %v, want the authoritative one", dns.RcodeToString[trace.Rcode], trace.Answer)
+	}
+	root, tld, auth := trace.Steps[0], trace.Steps[1], trace.Steps[2]
+	if root.Referral != "com." || !root.DS || !root.DNSKEY || !slices.Equal(root.NS, []string{"a.nic.com.", "b.nic.com."}) ||
+		len(root.Glue["b.nic.com."]) != 1 || len(root.Inconsistencies) != 0 {
+		t.Errorf("root step: %+v", root)
+	}
+	if tld.Referral != "example.com." || tld.DS || len(tld.Servers) != 2 || tld.Servers[0].Rcode != dns.RcodeServerFailure ||
+		!slices.ContainsFunc(tld.Inconsistencies, func(s string) bool { return strings.HasPrefix(s, "a.nic.com.") }) {
+		t.Errorf("com. step has to follow b.nic.com. past the failing a.nic.com.: %+v", tld)
+	}
+	if len(auth.Servers) != 2 || auth.Servers[0].Name != "a-lame.example.com." || auth.Referral != "" ||
+		!slices.ContainsFunc(auth.Inconsistencies, func(s string) bool { return strings.Contains(s, "a-lame.example.com.") && strings.Contains(s, "lame") }) {
+		t.Errorf("example.com. step has to report a-lame.example.com. and follow ns.example.com.: %+v", auth)
+	}
+	if out := trace.String(); !strings.Contains(out, "example.com.") || !strings.Contains(out, "192.0.2.80") {
+		t.Errorf("rendering:\n%s", out)
+	}
+	if trace, err = r.Trace("nx.example.com", dns.TypeA); err != nil || trace.Rcode != dns.RcodeNameError || len(trace.Steps) != 3 {
+		t.Errorf("nxdomain: got %v\n%s", err, trace)
+	}
+}
+
+func TestTraceNoReferral(t *testing.T) {
+	r, good := traceHierarchy(t)
+	good.SetFault("", dnsresolvertest.Fault{Rcode: dns.RcodeRefused})
+	trace, err := r.Trace("www.example.com", dns.TypeA)
+	if err == nil || len(trace.Steps) != 2 || trace.Steps[1].Zone != "com." || len(trace.Answer) != 0 {
+		t.Fatalf("got %v\n%s, want the trace to end at com. with an error", err, trace)
+	}
+	if len(trace.Steps[1].Inconsistencies) == 0 {
+		t.Errorf("failing servers have to be reported: %+v", trace.Steps[1])
+	}
+}
+
+func TestTraceAddrFailover(t *testing.T) {
+	r, _ := traceHierarchy(t)
+	// nothing listens on the first root address
+	dnsresolver.RootServers = map[string][]netip.Addr{"a.root-servers.test.": {netip.MustParseAddr("127.0.0.6"), netip.MustParseAddr("127.0.0.1")}}
+	trace, err := r.Trace("www.example.com", dns.TypeA)
+	if err != nil || len(trace.Steps) != 3 {
+		t.Fatalf("got %v\n%s", err, trace)
+	}
+	if root := trace.Steps[0]; root.Servers[0].Addr != netip.MustParseAddr("127.0.0.1") || len(root.Inconsistencies) != 0 {
+		t.Errorf("root step has to answer from the second address: %+v", root)
+	}
+}
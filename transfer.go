@@ -0,0 +1,87 @@
+package dnsresolver
+
+import (
+	"errors"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_errTransfer = "[dnsinfo] [transfer] "
+)
+
+// Transfer streams a full zone transfer (AXFR) via tcp, or DoT (XoT, RFC 9103)
+func (r *Resolver) Transfer(zone string) iter.Seq2[dns.RR, error] {
+	msg := new(dns.Msg)
+	msg.SetAxfr(dns.Fqdn(zone))
+	return r.transfer(msg)
+}
+
+// IncrementalTransfer streams the changes since serial (IXFR, RFC 1995), servers without
+// history answer with a full transfer
+func (r *Resolver) IncrementalTransfer(zone string, serial uint32) iter.Seq2[dns.RR, error] {
+	msg := new(dns.Msg)
+	msg.SetIxfr(dns.Fqdn(zone), serial, _dot, _dot) // root names, empty ones do not unpack
+	return r.transfer(msg)
+}
+
+// TransferZone writes a full zone transfer as master file, see WriteZone
+func (r *Resolver) TransferZone(zone string, w io.Writer) error {
+	var rrs []dns.RR
+	for rr, err := range r.Transfer(zone) {
+		if err != nil {
+			return err
+		}
+		rrs = append(rrs, rr)
+	}
+	return WriteZone(w, zone, rrs)
+}
+
+// transfer ...
+func (r *Resolver) transfer(query *dns.Msg) iter.Seq2[dns.RR, error] {
+	return func(yield func(dns.RR, error) bool) {
+		msg := query.Copy() // signed fresh on every iteration
+		zone := msg.Question[0].Name
+		server := r.server()
+		conn, err := r.dial(server, strings.Replace(r.proto(), _udp, _tcp, 1)) // DoT, keypin as for queries
+		if err != nil {
+			yield(nil, errors.New(_errTransfer+zone+_sep+server+_sep+err.Error()))
+			return
+		}
+		t := &dns.Transfer{Conn: conn, ReadTimeout: r.Timeout, WriteTimeout: r.Timeout}
+		if r.TSIG != nil {
+			t.TsigSecret = r.TSIG.secret()
+			r.TSIG.sign(msg)
+		}
+		envelopes, err := t.In(msg, server)
+		if err != nil {
+			_ = conn.Close()
+			yield(nil, errors.New(_errTransfer+zone+_sep+server+_sep+err.Error()))
+			return
+		}
+		defer func() {
+			_ = t.Close()
+			for range envelopes { // unblock the reader on early exit
+			}
+		}()
+		for e := range envelopes {
+			if e.Error != nil {
+				if r.TSIG != nil && isTSIGFailure(e.Error) {
+					yield(nil, &TSIGError{Key: r.TSIG.Name, Server: server, Err: e.Error})
+					return
+				}
+				yield(nil, errors.New(_errTransfer+zone+_sep+server+_sep+e.Error.Error()))
+				return
+			}
+			for _, rr := range e.RR {
+				if !yield(rr, nil) {
+					return
+				}
+			}
+		}
+	}
+}
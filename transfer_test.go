@@ -0,0 +1,169 @@
+package dnsresolver_test
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// transferRRs collects a transfer stream
+func transferRRs(t *testing.T, seq func(func(dns.RR, error) bool)) []dns.RR {
+	t.Helper()
+	var rrs []dns.RR
+	for rr, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func TestTransfer(t *testing.T) {
+	s := newServer(t, _testZone)
+	for _, r := range []*dnsresolver.Resolver{s.Resolver(), s.ResolverDoT()} {
+		rrs := transferRRs(t, r.Transfer("example.com"))
+		if len(rrs) != 11 || rrs[0].Header().Rrtype != dns.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+			t.Errorf("%s: got %d records %v, want 9 enclosed in SOA", r.Name, len(rrs), rrs)
+		}
+	}
+}
+
+func TestTransferEarlyExit(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	for range 3 {
+		n := 0
+		for _, err := range r.Transfer("example.com") {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n++; n == 2 {
+				break
+			}
+		}
+	}
+}
+
+func TestTransferTSIGResign(t *testing.T) {
+	soa := rr(t, "example.com. 300 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 86400 60")
+	zone := []dns.RR{soa, rr(t, "www.example.com. 300 IN A 192.0.2.80"), soa}
+	var mu sync.Mutex
+	var signed []uint64
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		req := m.IsTsig()
+		if req == nil {
+			return
+		}
+		mu.Lock()
+		signed = append(signed, req.TimeSigned)
+		mu.Unlock()
+		rsp := new(dns.Msg)
+		rsp.SetReply(m)
+		rsp.Answer = zone
+		rsp.SetTsig(req.Hdr.Name, req.Algorithm, 300, time.Now().Unix())
+		if buf, _, err := dns.TsigGenerate(rsp, _tsigSecret, req.MAC, false); err == nil {
+			w.Write(buf)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	r := s.Resolver()
+	r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Secret: _tsigSecret}
+	seq := r.Transfer("example.com")
+	transferRRs(t, seq)
+	time.Sleep(1100 * time.Millisecond)
+	var wg sync.WaitGroup
+	for range 4 { // iterations share no message
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, err := range seq {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(signed) != 5 || slices.Min(signed[1:]) <= signed[0] {
+		t.Errorf("signing times %v, every iteration has to be signed anew", signed)
+	}
+}
+
+func TestTransferError(t *testing.T) {
+	s := newServer(t, _testZone)
+	s.SetFault("example.com", dnsresolvertest.Fault{Rcode: dns.RcodeRefused})
+	for _, err := range s.Resolver().Transfer("example.com") {
+		if err == nil || !strings.HasPrefix(err.Error(), "[dnsinfo] [transfer] example.com.") {
+			t.Errorf("got %v, want transfer error", err)
+		}
+	}
+}
+
+func TestTransferZone(t *testing.T) {
+	s := newServer(t, _testZone)
+	var out strings.Builder
+	if err := s.Resolver().TransferZone("example.com", &out); err != nil {
+		t.Fatal(err)
+	}
+	zp := dns.NewZoneParser(strings.NewReader(out.String()), "", "")
+	n := 0
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if n++; n == 1 && rr.Header().Rrtype != dns.TypeSOA {
+			t.Errorf("first record %v, want SOA", rr)
+		}
+	}
+	if err := zp.Err(); err != nil || n != 10 {
+		t.Errorf("got %d records (%v) in\n%s", n, err, out.String())
+	}
+}
+
+func TestIncrementalTransfer(t *testing.T) {
+	soa := func(serial uint32) dns.RR {
+		rr, _ := dns.NewRR("example.com. 300 IN SOA ns.example.com. hostmaster.example.com. " + strconv.FormatUint(uint64(serial), 10) + " 7200 3600 86400 60")
+		return rr
+	}
+	deleted, _ := dns.NewRR("old.example.com. 300 IN A 192.0.2.1")
+	added, _ := dns.NewRR("new.example.com. 300 IN A 192.0.2.2")
+	var serial uint32
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		serial = m.Ns[0].(*dns.SOA).Serial
+		ch := make(chan *dns.Envelope, 1)
+		ch <- &dns.Envelope{RR: []dns.RR{soa(2), soa(1), deleted, soa(2), added, soa(2)}}
+		close(ch)
+		new(dns.Transfer).Out(w, m, ch)
+		w.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rrs := transferRRs(t, s.Resolver().IncrementalTransfer("example.com", 1))
+	if serial != 1 || len(rrs) != 6 || rrs[2].String() != deleted.String() || rrs[4].String() != added.String() {
+		t.Errorf("serial %d, got %v", serial, rrs)
+	}
+}
+
+func TestTransferDoTDefaultConfig(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.ResolverDoT()
+	r.TLSConfig, r.TLSKeyPin = nil, ""
+	// tls with the system roots, the self-signed harness certificate has to be rejected
+	for _, err := range r.Transfer("example.com") {
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Errorf("got %v, want certificate verification error", err)
+		}
+	}
+}
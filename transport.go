@@ -6,8 +6,12 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"errors"
+	"fmt"
 )
 
+// errKeyPin ...
+var errKeyPin = errors.New(_errKeyPin)
+
 // tlsConfigPin ...
 func tlsConfigPin(r *Resolver) *tls.Config {
 	tlsConfig := &tls.Config{
@@ -22,7 +26,7 @@ func tlsConfigPin(r *Resolver) *tls.Config {
 	if r.TLSKeyPin != _empty {
 		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
 			if !pinVerifyState(r.TLSKeyPin, &state) {
-				return errors.New(_errKeyPin + r.Name)
+				return fmt.Errorf("%w%s", errKeyPin, r.Name)
 			}
 			return nil
 		}
@@ -0,0 +1,97 @@
+package dnsresolver
+
+import (
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_tsigFudge = 300 // seconds, RFC 8945 section 10
+	_errTSIG   = "[dnsinfo] [tsig] verification failed "
+)
+
+// TSIGKey is a named shared secret to sign messages, RFC 8945
+type TSIGKey struct {
+	// Name of the key
+	Name string
+	// Algorithm, dns.HmacSHA256 (default) or dns.HmacSHA512
+	Algorithm string
+	// Secret base64 encoded
+	Secret string
+}
+
+// TSIGError is returned for responses failing the TSIG verification, either
+// locally (mac, time window, missing signature) or reported by the server
+type TSIGError struct {
+	Key    string
+	Server string
+	// Err is the local verification error, eg. dns.ErrSig, dns.ErrTime, dns.ErrNoSig
+	Err error
+	// Rcode is the TSIG error reported by the server, eg. dns.RcodeBadSig, dns.RcodeBadKey
+	Rcode int
+}
+
+// Error ...
+func (e *TSIGError) Error() string {
+	reason := dns.RcodeToString[e.Rcode]
+	if e.Err != nil {
+		reason = e.Err.Error()
+	}
+	return _errTSIG + e.Key + _sep + e.Server + _sep + reason
+}
+
+// Unwrap ...
+func (e *TSIGError) Unwrap() error {
+	return e.Err
+}
+
+// algorithm ...
+func (k *TSIGKey) algorithm() string {
+	if k.Algorithm == _empty {
+		return dns.HmacSHA256
+	}
+	return dns.CanonicalName(k.Algorithm)
+}
+
+// secret returns the key in the dns.Client/dns.Transfer TsigSecret format
+func (k *TSIGKey) secret() map[string]string {
+	return map[string]string{dns.CanonicalName(k.Name): k.Secret}
+}
+
+// sign adds the TSIG record once, the mac itself is calculated when the message is packed
+func (k *TSIGKey) sign(msg *dns.Msg) {
+	if msg.IsTsig() == nil {
+		msg.SetTsig(dns.CanonicalName(k.Name), k.algorithm(), _tsigFudge, time.Now().Unix())
+	}
+}
+
+// verify checks the TSIG of a response, dns.Conn already verified mac and time window
+func (k *TSIGKey) verify(server string, rsp *dns.Msg, err error) error {
+	if rsp != nil {
+		if t := rsp.IsTsig(); t != nil && t.Error != dns.RcodeSuccess {
+			return &TSIGError{Key: k.Name, Server: server, Rcode: int(t.Error)}
+		}
+	}
+	switch {
+	case err != nil && isTSIGFailure(err):
+		return &TSIGError{Key: k.Name, Server: server, Err: err}
+	case err != nil:
+		return err
+	case rsp.IsTsig() == nil:
+		return &TSIGError{Key: k.Name, Server: server, Err: dns.ErrNoSig}
+	}
+	return nil
+}
+
+// isTSIGFailure ...
+func isTSIGFailure(err error) bool {
+	for _, e := range []error{dns.ErrSig, dns.ErrTime, dns.ErrKeyAlg, dns.ErrSecret, dns.ErrNoSig, dns.ErrAuth} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// const
+const (
+	_tsigName   = "key.example.com."
+	_tsigSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0MTI="
+	_tsigOther  = "b3RoZXJvdGhlcm90aGVyb3RoZXJvdGhlcm90aGVyMTI="
+)
+
+// tsigServer answers A queries signed with secret, tsigErr is reported in the TSIG record,
+// an empty secret leaves the answer unsigned
+func tsigServer(t *testing.T, secret string, tsigErr uint16) *dnsresolvertest.Server {
+	t.Helper()
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		rsp := new(dns.Msg)
+		rsp.SetReply(m)
+		rsp.Answer = append(rsp.Answer, &dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: []byte{192, 0, 2, 1}})
+		req := m.IsTsig()
+		if secret == "" || req == nil {
+			w.WriteMsg(rsp)
+			return
+		}
+		rsp.SetTsig(req.Hdr.Name, req.Algorithm, 300, time.Now().Unix())
+		if tsigErr != dns.RcodeSuccess {
+			rsp.Rcode, rsp.Answer = dns.RcodeNotAuth, nil
+			rsp.IsTsig().Error = tsigErr
+		}
+		buf, _, err := dns.TsigGenerate(rsp, secret, req.MAC, false)
+		if err != nil {
+			return
+		}
+		w.Write(buf)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestTSIG(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		algorithm string
+		secret    string
+		tsigErr   uint16
+		err       error
+		rcode     int
+	}{
+		{name: "valid", secret: _tsigSecret},
+		{name: "sha512", algorithm: dns.HmacSHA512, secret: _tsigSecret},
+		{name: "wrong secret", secret: _tsigOther, err: dns.ErrSig},
+		{name: "unsigned", err: dns.ErrNoSig},
+		{name: "bad key", secret: _tsigSecret, tsigErr: dns.RcodeBadKey, rcode: dns.RcodeBadKey},
+	} {
+		s := tsigServer(t, tc.secret, tc.tsigErr)
+		r := s.Resolver()
+		r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Algorithm: tc.algorithm, Secret: _tsigSecret}
+		addrs, err := r.LookupAddr("www.example.com", dns.TypeA)
+		if tc.err == nil && tc.rcode == 0 {
+			if err != nil || len(addrs) != 1 {
+				t.Errorf("%s: got %v %v", tc.name, addrs, err)
+			}
+			continue
+		}
+		var tsigErr *dnsresolver.TSIGError
+		switch {
+		case !errors.As(err, &tsigErr):
+			t.Errorf("%s: got %v, want TSIGError", tc.name, err)
+		case tsigErr.Key != _tsigName || tsigErr.Server != s.Addr:
+			t.Errorf("%s: got %+v", tc.name, tsigErr)
+		case tc.err != nil && !errors.Is(err, tc.err):
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.err)
+		case tc.rcode != 0 && tsigErr.Rcode != tc.rcode:
+			t.Errorf("%s: rcode %d, want %d", tc.name, tsigErr.Rcode, tc.rcode)
+		}
+		if s.Queries() != 1 {
+			t.Errorf("%s: queries %d, TSIG failures must not be retried", tc.name, s.Queries())
+		}
+	}
+}
+
+func TestTSIGExchange(t *testing.T) {
+	s := tsigServer(t, _tsigOther, dns.RcodeSuccess)
+	r := s.Resolver()
+	r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Secret: _tsigSecret}
+	for res, err := range r.ExchangeSeq("www.example.com", []uint16{dns.TypeA}) {
+		var tsigErr *dnsresolver.TSIGError
+		if !errors.As(err, &tsigErr) {
+			t.Errorf("got %+v %v, want TSIGError", res, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package dnsresolver
+
+import (
+	"crypto"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_sig0Validity = 300 // seconds, signature inception/expiration window
+	_errUpdate    = "[dnsinfo] [update] "
+	_errSIG0      = "[dnsinfo] [sig0] "
+)
+
+// Update is a dynamic update (RFC 2136) of a single zone, prerequisites and
+// operations are collected in order and sent as one message via Resolver.Update
+type Update struct {
+	// Zone to update
+	Zone string
+	// Server primary server_ip:port (optional, default: resolve the SOA MNAME)
+	Server string
+
+	prereq []dns.RR
+	ops    []dns.RR
+}
+
+// UpdateError is returned when the primary refuses an update, eg. YXDOMAIN,
+// NXRRSET (failed prerequisites), NOTAUTH or REFUSED
+type UpdateError struct {
+	Zone   string
+	Server string
+	Rcode  int
+}
+
+// Error ...
+func (e *UpdateError) Error() string {
+	return _errUpdate + strings.TrimSuffix(e.Zone, _dot) + _sep + e.Server + _sep + dns.RcodeToString[e.Rcode]
+}
+
+// SIG0Error is returned for responses failing the SIG(0) verification against SIG0Key.ServerKey
+type SIG0Error struct {
+	Key    string
+	Server string
+	// Err is the verification error, eg. dns.ErrNoSig, dns.ErrSig
+	Err error
+}
+
+// Error ...
+func (e *SIG0Error) Error() string {
+	return _errSIG0 + "verification failed" + _sep + e.Key + _sep + e.Server + _sep + e.Err.Error()
+}
+
+// Unwrap ...
+func (e *SIG0Error) Unwrap() error {
+	return e.Err
+}
+
+// SIG0Key is a private key to sign dynamic updates, RFC 2931
+type SIG0Key struct {
+	// Name of the signer, owner of the KEY record in the zone
+	Name string
+	// Algorithm, eg. dns.ECDSAP256SHA256, dns.ED25519
+	Algorithm uint8
+	// KeyTag of the KEY record
+	KeyTag uint16
+	// Signer private key
+	Signer crypto.Signer
+	// ServerKey verifies the SIG(0) of responses (optional, responses are not verified without)
+	ServerKey *dns.KEY
+}
+
+// NewUpdate ...
+func NewUpdate(zone string) *Update {
+	return &Update{Zone: dns.Fqdn(zone)}
+}
+
+// NameUsed requires name to own at least one record
+func (u *Update) NameUsed(name string) {
+	u.prereq = append(u.prereq, updateRR(name, dns.TypeANY, dns.ClassANY))
+}
+
+// NameNotUsed requires name to own no records
+func (u *Update) NameNotUsed(name string) {
+	u.prereq = append(u.prereq, updateRR(name, dns.TypeANY, dns.ClassNONE))
+}
+
+// RRsetExists requires an rrset of rType at name, regardless of its value
+func (u *Update) RRsetExists(name string, rType uint16) {
+	u.prereq = append(u.prereq, updateRR(name, rType, dns.ClassANY))
+}
+
+// RRsetNotExists requires no rrset of rType at name
+func (u *Update) RRsetNotExists(name string, rType uint16) {
+	u.prereq = append(u.prereq, updateRR(name, rType, dns.ClassNONE))
+}
+
+// RRsetEquals requires the rrset to exist with exactly the given records (value dependent)
+func (u *Update) RRsetEquals(rrs ...dns.RR) {
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		u.prereq = append(u.prereq, rr)
+	}
+}
+
+// Add adds records to their rrsets
+func (u *Update) Add(rrs ...dns.RR) {
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		u.ops = append(u.ops, rr)
+	}
+}
+
+// Delete removes individual records
+func (u *Update) Delete(rrs ...dns.RR) {
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Class, rr.Header().Ttl = dns.ClassNONE, 0
+		u.ops = append(u.ops, rr)
+	}
+}
+
+// DeleteRRset removes the rrset of rType at name
+func (u *Update) DeleteRRset(name string, rType uint16) {
+	u.ops = append(u.ops, updateRR(name, rType, dns.ClassANY))
+}
+
+// DeleteName removes all rrsets at name
+func (u *Update) DeleteName(name string) {
+	u.ops = append(u.ops, updateRR(name, dns.TypeANY, dns.ClassANY))
+}
+
+// Msg returns the update message, unsigned
+func (u *Update) Msg() *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(u.Zone))
+	msg.Answer = append(msg.Answer, u.prereq...)
+	msg.Ns = append(msg.Ns, u.ops...)
+	return msg
+}
+
+// Update sends u to the zone primary via tcp (or DoT), signed with TSIG or, without TSIG key, SIG(0)
+func (r *Resolver) Update(u *Update) error {
+	servers := []string{u.Server}
+	if u.Server == _empty {
+		var err error
+		if servers, err = r.primary(u.Zone); err != nil {
+			return err
+		}
+	}
+	proto := strings.Replace(r.proto(), _udp, _tcp, 1)
+	var err error
+	for _, server := range servers {
+		var rsp *dns.Msg
+		var next bool
+		if rsp, next, err = r.exchangeUpdate(server, proto, u.Msg()); next {
+			continue
+		}
+		if err != nil { // the primary got the update, do not send it again
+			return err
+		}
+		if rsp.Rcode != dns.RcodeSuccess {
+			return &UpdateError{Zone: u.Zone, Server: server, Rcode: rsp.Rcode}
+		}
+		if r.Cache != nil {
+			for _, rr := range u.ops {
+				r.Cache.forget(rr.Header().Name)
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// primary resolves the zone SOA MNAME to server_ip:port candidates
+func (r *Resolver) primary(zone string) ([]string, error) {
+	rsp, err := r.resolve(zone, dns.TypeSOA)
+	if err != nil {
+		return nil, errors.New(_errUpdate + zone + _sep + err.Error())
+	}
+	var mname string
+	for _, rr := range append(rsp.Answer, rsp.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			mname = soa.Ns
+			break
+		}
+	}
+	if mname == _empty {
+		return nil, errors.New(_errUpdate + zone + _sep + "no SOA MNAME")
+	}
+	var rTypes []uint16
+	if !r.NoIP6 {
+		rTypes = append(rTypes, dns.TypeAAAA)
+	}
+	if !r.NoIP4 {
+		rTypes = append(rTypes, dns.TypeA)
+	}
+	addrs, err := r.resolveAddrs(mname, rTypes)
+	if err != nil {
+		return nil, errors.New(_errUpdate + zone + _sep + mname + _sep + err.Error())
+	}
+	port := uint16(_dnsPortNum)
+	if r.DoT {
+		port = _dotPortNum
+	}
+	servers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		servers = append(servers, netip.AddrPortFrom(addr, port).String())
+	}
+	return servers, nil
+}
+
+// exchangeUpdate sends msg to server, next reports dial and transport errors to try the next address
+func (r *Resolver) exchangeUpdate(server, proto string, msg *dns.Msg) (rsp *dns.Msg, next bool, err error) {
+	conn, err := r.dial(server, proto)
+	if err != nil {
+		return nil, true, errors.New(_errUpdate + server + _sep + proto + _sep + err.Error())
+	}
+	defer conn.Close()
+	if r.TSIG != nil || r.SIG0 == nil {
+		rsp, err := r.exchangeConn(conn, proto, server, msg)
+		var tsigErr *TSIGError
+		if err != nil && !errors.As(err, &tsigErr) {
+			return rsp, true, errors.New(_errUpdate + server + _sep + proto + _sep + err.Error())
+		}
+		return rsp, false, err
+	}
+	buf, err := r.SIG0.sign(msg)
+	if err != nil {
+		return nil, false, err
+	}
+	start := time.Now()
+	if r.Timeout > 0 {
+		_ = conn.SetDeadline(start.Add(r.Timeout))
+	}
+	if _, err = conn.Write(buf); err == nil {
+		var raw []byte
+		var rsp *dns.Msg
+		if raw, err = conn.ReadMsgHeader(nil); err == nil {
+			rsp = new(dns.Msg)
+			if err = rsp.Unpack(raw); err != nil {
+				rsp = nil
+			}
+		}
+		r.metrics().Query(server, proto, queryType(msg), rcodeOf(rsp), time.Since(start), err)
+		if err == nil {
+			return rsp, false, r.SIG0.verify(server, rsp, raw)
+		}
+	}
+	return nil, true, errors.New(_errUpdate + server + _sep + proto + _sep + err.Error())
+}
+
+// LoadSIG0Key reads a KEY record and its private key file, as written by dnssec-keygen -T KEY
+func LoadSIG0Key(keyFile, privateFile string) (*SIG0Key, error) {
+	pub, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.New(_errSIG0 + err.Error())
+	}
+	rr, err := dns.NewRR(string(pub))
+	if err != nil {
+		return nil, errors.New(_errSIG0 + keyFile + _sep + err.Error())
+	}
+	key, ok := rr.(*dns.KEY)
+	if !ok {
+		return nil, errors.New(_errSIG0 + keyFile + _sep + "no KEY record")
+	}
+	f, err := os.Open(privateFile)
+	if err != nil {
+		return nil, errors.New(_errSIG0 + err.Error())
+	}
+	defer f.Close()
+	priv, err := key.ReadPrivateKey(f, privateFile)
+	if err != nil {
+		return nil, errors.New(_errSIG0 + privateFile + _sep + err.Error())
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(_errSIG0 + privateFile + _sep + "unsupported key type")
+	}
+	return &SIG0Key{Name: key.Hdr.Name, Algorithm: key.Algorithm, KeyTag: key.KeyTag(), Signer: signer}, nil
+}
+
+// sign returns the packed message with the SIG(0) record appended
+func (k *SIG0Key) sign(msg *dns.Msg) ([]byte, error) {
+	now := uint32(time.Now().Unix())
+	sig := new(dns.SIG)
+	sig.Hdr = dns.RR_Header{Name: _dot, Rrtype: dns.TypeSIG, Class: dns.ClassANY}
+	sig.Algorithm = k.Algorithm
+	sig.KeyTag = k.KeyTag
+	sig.SignerName = dns.CanonicalName(k.Name)
+	sig.Inception = now - _sig0Validity
+	sig.Expiration = now + _sig0Validity
+	buf, err := sig.Sign(k.Signer, msg)
+	if err != nil {
+		return nil, errors.New(_errSIG0 + k.Name + _sep + strconv.Itoa(int(k.KeyTag)) + _sep + err.Error())
+	}
+	return buf, nil
+}
+
+// verify checks the SIG(0) of a response against ServerKey, raw is the response as received
+func (k *SIG0Key) verify(server string, rsp *dns.Msg, raw []byte) error {
+	if k.ServerKey == nil {
+		return nil
+	}
+	var sig *dns.SIG
+	if len(rsp.Extra) > 0 {
+		sig, _ = rsp.Extra[len(rsp.Extra)-1].(*dns.SIG)
+	}
+	if sig == nil {
+		return &SIG0Error{Key: k.ServerKey.Hdr.Name, Server: server, Err: dns.ErrNoSig}
+	}
+	if err := sig.Verify(k.ServerKey, raw); err != nil {
+		return &SIG0Error{Key: k.ServerKey.Hdr.Name, Server: server, Err: err}
+	}
+	return nil
+}
+
+// updateRR returns an empty rr (rdlength 0) of class ANY or NONE
+func updateRR(name string, rType uint16, class uint16) dns.RR {
+	return &dns.ANY{Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: rType, Class: class}}
+}
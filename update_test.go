@@ -0,0 +1,286 @@
+package dnsresolver_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+	"paepcke.de/dnsresolver/dnsresolvertest"
+)
+
+// updateServer records the updates it receives and answers them with rcode
+type updateServer struct {
+	*dnsresolvertest.Server
+	mu      sync.Mutex
+	updates []*dns.Msg
+	network []string
+}
+
+// newUpdateServer ...
+func newUpdateServer(t *testing.T, rcode int) *updateServer {
+	t.Helper()
+	u := &updateServer{}
+	s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		u.mu.Lock()
+		u.updates = append(u.updates, m)
+		u.network = append(u.network, w.RemoteAddr().Network())
+		u.mu.Unlock()
+		rsp := new(dns.Msg)
+		rsp.SetRcode(m, rcode)
+		w.WriteMsg(rsp)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	u.Server = s
+	return u
+}
+
+// last returns the last update received
+func (u *updateServer) last(t *testing.T) (*dns.Msg, string) {
+	t.Helper()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.updates) == 0 {
+		t.Fatal("no update received")
+	}
+	return u.updates[len(u.updates)-1], u.network[len(u.network)-1]
+}
+
+// rr ...
+func rr(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestUpdate(t *testing.T) {
+	s := newUpdateServer(t, dns.RcodeSuccess)
+	u := dnsresolver.NewUpdate("example.com")
+	u.Server = s.Addr
+	u.NameNotUsed("new.example.com")
+	u.RRsetExists("www.example.com", dns.TypeA)
+	u.RRsetNotExists("www.example.com", dns.TypeCNAME)
+	u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+	u.Delete(rr(t, "www.example.com. 300 IN A 192.0.2.80"))
+	u.DeleteRRset("www.example.com", dns.TypeTXT)
+	u.DeleteName("old.example.com")
+	if err := s.Resolver().Update(u); err != nil {
+		t.Fatal(err)
+	}
+	m, network := s.last(t)
+	if network != "tcp" || m.Opcode != dns.OpcodeUpdate || m.Question[0].Name != "example.com." || m.Question[0].Qtype != dns.TypeSOA {
+		t.Fatalf("got %s %v", network, m)
+	}
+	classes := func(rrs []dns.RR) (c []uint16) {
+		for _, rr := range rrs {
+			c = append(c, rr.Header().Class)
+		}
+		return c
+	}
+	for _, tc := range []struct {
+		section string
+		got     []uint16
+		want    []uint16
+	}{
+		{"prerequisites", classes(m.Answer), []uint16{dns.ClassNONE, dns.ClassANY, dns.ClassNONE}},
+		{"updates", classes(m.Ns), []uint16{dns.ClassINET, dns.ClassNONE, dns.ClassANY, dns.ClassANY}},
+	} {
+		if len(tc.got) != len(tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.section, tc.got, tc.want)
+		}
+		for i := range tc.want {
+			if tc.got[i] != tc.want[i] {
+				t.Errorf("%s %d: class %d, want %d", tc.section, i, tc.got[i], tc.want[i])
+			}
+		}
+	}
+	if del := m.Ns[1]; del.Header().Ttl != 0 || !strings.Contains(del.String(), "192.0.2.80") {
+		t.Errorf("delete: got %v", del)
+	}
+}
+
+func TestUpdateError(t *testing.T) {
+	s := newUpdateServer(t, dns.RcodeYXDomain)
+	u := dnsresolver.NewUpdate("example.com")
+	u.Server = s.Addr
+	u.NameNotUsed("www.example.com")
+	var updateErr *dnsresolver.UpdateError
+	if err := s.Resolver().Update(u); !errors.As(err, &updateErr) || updateErr.Rcode != dns.RcodeYXDomain || updateErr.Server != s.Addr || updateErr.Zone != "example.com." {
+		t.Errorf("got %v, want YXDOMAIN UpdateError", err)
+	}
+}
+
+func TestUpdateTSIG(t *testing.T) {
+	s := tsigServer(t, _tsigSecret, dns.RcodeSuccess)
+	r := s.Resolver()
+	r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Secret: _tsigSecret}
+	u := dnsresolver.NewUpdate("example.com")
+	u.Server = s.Addr
+	u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+	if err := r.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	r.TSIG.Secret = _tsigOther
+	var tsigErr *dnsresolver.TSIGError
+	if err := r.Update(u); !errors.As(err, &tsigErr) {
+		t.Errorf("got %v, want TSIGError", err)
+	}
+}
+
+func TestUpdateSIG0(t *testing.T) {
+	key, priv := sig0Key(t, "key.example.com.")
+	s := newUpdateServer(t, dns.RcodeSuccess)
+	r := s.Resolver()
+	r.SIG0 = &dnsresolver.SIG0Key{Name: key.Hdr.Name, Algorithm: key.Algorithm, KeyTag: key.KeyTag(), Signer: priv}
+	u := dnsresolver.NewUpdate("example.com")
+	u.Server = s.Addr
+	u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+	if err := r.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := s.last(t)
+	sig, ok := m.Extra[len(m.Extra)-1].(*dns.SIG)
+	if !ok || sig.SignerName != key.Hdr.Name || sig.KeyTag != key.KeyTag() {
+		t.Fatalf("got %v, want SIG(0) by %s", m.Extra, key.Hdr.Name)
+	}
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Verify(key, buf); err != nil {
+		t.Errorf("SIG(0) verification: %v", err)
+	}
+}
+
+// sig0Key generates an ECDSA P-256 KEY record and its private key
+func sig0Key(t *testing.T, name string) (*dns.KEY, *ecdsa.PrivateKey) {
+	t.Helper()
+	key := &dns.KEY{DNSKEY: dns.DNSKEY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET, Ttl: 300}, Algorithm: dns.ECDSAP256SHA256, Protocol: 3}}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, priv.(*ecdsa.PrivateKey)
+}
+
+func TestUpdateSIG0Response(t *testing.T) {
+	key, priv := sig0Key(t, "key.example.com.")
+	serverKey, serverPriv := sig0Key(t, "ns.example.com.")
+	otherKey, otherPriv := sig0Key(t, "ns.example.com.")
+	for _, tc := range []struct {
+		name   string
+		signer *dns.KEY
+		priv   *ecdsa.PrivateKey
+		ok     bool
+	}{
+		{"signed", serverKey, serverPriv, true},
+		{"unsigned", nil, nil, false},
+		{"other key", otherKey, otherPriv, false},
+	} {
+		s, err := dnsresolvertest.NewServerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+			rsp := new(dns.Msg)
+			rsp.SetRcode(m, dns.RcodeSuccess)
+			if tc.signer == nil {
+				w.WriteMsg(rsp)
+				return
+			}
+			now := uint32(time.Now().Unix())
+			sig := &dns.SIG{RRSIG: dns.RRSIG{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeSIG, Class: dns.ClassANY},
+				Algorithm: tc.signer.Algorithm, KeyTag: tc.signer.KeyTag(), SignerName: tc.signer.Hdr.Name, Inception: now - 300, Expiration: now + 300}}
+			if buf, err := sig.Sign(tc.priv, rsp); err == nil {
+				w.Write(buf)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		m := dnsresolver.NewPrometheusMetrics()
+		r := s.Resolver()
+		r.Metrics = m
+		r.SIG0 = &dnsresolver.SIG0Key{Name: key.Hdr.Name, Algorithm: key.Algorithm, KeyTag: key.KeyTag(), Signer: priv, ServerKey: serverKey}
+		u := dnsresolver.NewUpdate("example.com")
+		u.Server = s.Addr
+		u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+		var sig0Err *dnsresolver.SIG0Error
+		if err = r.Update(u); (err == nil) != tc.ok || (err != nil && (!errors.As(err, &sig0Err) || sig0Err.Server != s.Addr || !strings.HasPrefix(err.Error(), "[dnsinfo] [sig0] "))) {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+		if out := scrape(t, m); !strings.Contains(out, `type="None",rcode="NOERROR"} 1`) {
+			t.Errorf("%s: update has to be reported without query type:\n%s", tc.name, out)
+		}
+		r.SIG0.ServerKey = nil
+		if err = r.Update(u); err != nil {
+			t.Errorf("%s: without server key: %v", tc.name, err)
+		}
+	}
+}
+
+func TestUpdatePrimary(t *testing.T) {
+	s := newServer(t, strings.Replace(_testZone, "ns		IN A	192.0.2.53", "ns		IN A	127.0.0.1", 1))
+	u := dnsresolver.NewUpdate("example.com")
+	u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+	r := s.Resolver()
+	r.NoIP6 = true
+	// the primary is found via SOA MNAME ns.example.com, nothing listens on its port 53
+	if err := r.Update(u); err == nil || !strings.Contains(err.Error(), "127.0.0.1:53") {
+		t.Errorf("got %v, want update sent to 127.0.0.1:53", err)
+	}
+}
+
+func TestUpdateTSIGNotResent(t *testing.T) {
+	var mu sync.Mutex
+	var updates int
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		mu.Lock()
+		updates++
+		mu.Unlock()
+		rsp := new(dns.Msg)
+		rsp.SetRcode(m, dns.RcodeSuccess)
+		req := m.IsTsig()
+		if req == nil {
+			w.WriteMsg(rsp)
+			return
+		}
+		rsp.SetTsig(req.Hdr.Name, req.Algorithm, 300, time.Now().Unix())
+		if buf, _, err := dns.TsigGenerate(rsp, _tsigOther, req.MAC, false); err == nil {
+			w.Write(buf)
+		}
+	})
+	// both addresses of the SOA MNAME ns.example.com answer on port 53, signed with the wrong key
+	newServerAddr(t, h, "127.0.0.2:53")
+	newServerAddr(t, h, "127.0.0.3:53")
+	s := newServer(t, strings.Replace(_testZone, "ns		IN A	192.0.2.53", "ns		IN A	127.0.0.2\nns		IN A	127.0.0.3", 1))
+	r := s.Resolver()
+	r.NoIP6 = true
+	r.Cache = dnsresolver.NewCache()
+	// the zone server does not sign, primary discovery is answered from the cache
+	if _, err := r.Lookup("example.com", dns.TypeSOA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupAddr("ns.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	r.TSIG = &dnsresolver.TSIGKey{Name: _tsigName, Secret: _tsigSecret}
+	u := dnsresolver.NewUpdate("example.com")
+	u.Add(rr(t, "new.example.com. 300 IN A 192.0.2.2"))
+	var tsigErr *dnsresolver.TSIGError
+	if err := r.Update(u); !errors.As(err, &tsigErr) {
+		t.Errorf("got %v, want TSIGError", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if updates != 1 {
+		t.Errorf("primary got %d updates, want 1", updates)
+	}
+}
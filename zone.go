@@ -0,0 +1,242 @@
+package dnsresolver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// const
+const (
+	_zoneMaxCNAME = 8
+	_errZone      = "[dnsinfo] [zone] "
+)
+
+// WriteZone writes records as RFC 1035 master file, with $ORIGIN and $TTL header,
+// SOA first, then sorted by owner (canonical order) and type, duplicates removed
+func WriteZone(w io.Writer, origin string, rrs []dns.RR) error {
+	origin = dns.CanonicalName(origin)
+	rrs = sortRRs(rrs)
+	var s strings.Builder
+	s.WriteString("$ORIGIN " + origin + _linefeed)
+	if len(rrs) > 0 {
+		s.WriteString("$TTL " + strconv.FormatUint(uint64(defaultTTL(rrs)), 10) + _linefeed)
+	}
+	for _, rr := range rrs {
+		s.WriteString(rr.String() + _linefeed)
+	}
+	if _, err := io.WriteString(w, s.String()); err != nil {
+		return errors.New(_errZone + err.Error())
+	}
+	return nil
+}
+
+// sortRRs returns a sorted, duplicate free copy, the (first) SOA leads
+func sortRRs(rrs []dns.RR) []dns.RR {
+	var soa dns.RR
+	all := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeSOA && soa == nil {
+			soa = rr
+			continue
+		}
+		all = append(all, rr)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i].Header(), all[j].Header()
+		if c := canonicalCompare(a.Name, b.Name); c != 0 {
+			return c < 0
+		}
+		if a.Rrtype != b.Rrtype {
+			return a.Rrtype < b.Rrtype
+		}
+		return all[i].String() < all[j].String()
+	})
+	sorted := make([]dns.RR, 0, len(all)+1)
+	if soa != nil {
+		sorted = append(sorted, soa)
+	}
+	for _, rr := range all {
+		if len(sorted) > 0 && dns.IsDuplicate(sorted[len(sorted)-1], rr) {
+			continue
+		}
+		if soa != nil && dns.IsDuplicate(soa, rr) {
+			continue
+		}
+		sorted = append(sorted, rr)
+	}
+	return sorted
+}
+
+// canonicalCompare orders names label by label from the root, RFC 4034 section 6.1
+func canonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// defaultTTL returns the most common ttl
+func defaultTTL(rrs []dns.RR) uint32 {
+	count := make(map[uint32]int)
+	var ttl uint32
+	for _, rr := range rrs {
+		t := rr.Header().Ttl
+		count[t]++
+		if count[t] > count[ttl] || (count[t] == count[ttl] && t < ttl) {
+			ttl = t
+		}
+	}
+	return ttl
+}
+
+// WriteZone writes the answer records of all types as master file, see WriteZone
+func (a *Answer) WriteZone(w io.Writer, origin string) error {
+	var rrs []dns.RR
+	for _, records := range a.Records {
+		rrs = append(rrs, records...)
+	}
+	return WriteZone(w, origin, rrs)
+}
+
+// Zone is an in-memory record set, answers as MsgSource for all names within Name like an
+// authoritative server, NXDOMAIN and NODATA with the SOA, CNAMEs within the zone are followed
+type Zone struct {
+	Name string
+
+	records map[string]map[uint16][]dns.RR
+}
+
+// LoadZone parses a zone in master file format, see WriteZone
+func LoadZone(r io.Reader, origin string) (*Zone, error) {
+	z := &Zone{Name: dns.CanonicalName(origin), records: make(map[string]map[uint16][]dns.RR)}
+	zp := dns.NewZoneParser(r, z.Name, _empty)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		owner := dns.CanonicalName(rr.Header().Name)
+		if !dns.IsSubDomain(z.Name, owner) {
+			return nil, errors.New(_errZone + z.Name + _sep + "out of zone record: " + owner)
+		}
+		z.addName(owner)
+		z.records[owner][rr.Header().Rrtype] = append(z.records[owner][rr.Header().Rrtype], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, errors.New(_errZone + z.Name + _sep + err.Error())
+	}
+	return z, nil
+}
+
+// ZoneFile ...
+func ZoneFile(path, origin string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New(_errZone + err.Error())
+	}
+	defer f.Close()
+	return LoadZone(f, origin)
+}
+
+// Lookup answers from the zone, wildcards included, names outside the zone and names that
+// do not exist are handed over
+func (z *Zone) Lookup(query string, rType uint16) ([]dns.RR, bool) {
+	msg, ok := z.LookupMsg(query, rType)
+	if !ok || msg.Rcode != dns.RcodeSuccess {
+		return nil, false
+	}
+	return msg.Answer, true
+}
+
+// LookupMsg answers from the zone, wildcards included, NXDOMAIN (rcode of the last name of
+// a CNAME chain) and NODATA with the SOA, names outside the zone are handed over
+func (z *Zone) LookupMsg(query string, rType uint16) (*dns.Msg, bool) {
+	name := dns.CanonicalName(query)
+	if !dns.IsSubDomain(z.Name, name) {
+		return nil, false
+	}
+	msg := localMsg(query, rType, nil)
+	for range _zoneMaxCNAME {
+		set := z.rrset(name)
+		if set == nil {
+			msg.Rcode = dns.RcodeNameError
+			break
+		}
+		if records, ok := set[rType]; ok {
+			msg.Answer = append(msg.Answer, records...)
+			return msg, true
+		}
+		cname, ok := set[dns.TypeCNAME]
+		if !ok {
+			break
+		}
+		msg.Answer = append(msg.Answer, cname...)
+		name = dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+		if !dns.IsSubDomain(z.Name, name) {
+			return msg, true
+		}
+	}
+	if soa, ok := z.records[z.Name][dns.TypeSOA]; ok {
+		msg.Ns = []dns.RR{dns.Copy(soa[0])}
+	}
+	return msg, true
+}
+
+// Records returns all records, sorted as by WriteZone
+func (z *Zone) Records() []dns.RR {
+	var rrs []dns.RR
+	for _, set := range z.records {
+		for _, records := range set {
+			rrs = append(rrs, records...)
+		}
+	}
+	return sortRRs(rrs)
+}
+
+// addName adds name and its empty non-terminals up to the apex, RFC 4592 section 2.2.2
+func (z *Zone) addName(name string) {
+	for z.records[name] == nil {
+		z.records[name] = make(map[uint16][]dns.RR)
+		off, end := dns.NextLabel(name, 0)
+		if end || name == z.Name {
+			return
+		}
+		name = name[off:]
+	}
+}
+
+// rrset returns copies of the records of name, synthesized from the wildcard of the closest
+// encloser when name does not exist, RFC 4592, nil when neither exists, empty for empty
+// non-terminals
+func (z *Zone) rrset(name string) map[uint16][]dns.RR {
+	owner := name
+	set, ok := z.records[name]
+	for off := 0; !ok; {
+		next, end := dns.NextLabel(name, off)
+		if end || !dns.IsSubDomain(z.Name, name[next:]) {
+			return nil
+		}
+		parent := name[next:]
+		if set, ok = z.records["*."+parent]; ok {
+			break
+		}
+		if _, exists := z.records[parent]; exists {
+			return nil
+		}
+		off = next
+	}
+	copies := make(map[uint16][]dns.RR, len(set))
+	for rType, records := range set {
+		for _, rr := range records {
+			rr = dns.Copy(rr)
+			rr.Header().Name = owner
+			copies[rType] = append(copies[rType], rr)
+		}
+	}
+	return copies
+}
@@ -0,0 +1,214 @@
+package dnsresolver_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"paepcke.de/dnsresolver"
+)
+
+// _localZone ...
+const _localZone = `$ORIGIN example.com.
+$TTL 300
+@		IN SOA	ns hostmaster 1 7200 3600 86400 60
+@		IN NS	ns
+ns		IN A	192.0.2.53
+www		IN A	192.0.2.80
+alias		IN CNAME www
+dangling	IN CNAME nx
+external	IN CNAME www.example.org.
+*.w		IN TXT	"wildcard"
+`
+
+// _entZone has an apex wildcard and the empty non-terminals b and c
+const _entZone = `$ORIGIN example.com.
+$TTL 300
+@		IN SOA	ns hostmaster 1 7200 3600 86400 60
+*		IN TXT	"wildcard"
+a.b		IN A	192.0.2.10
+*.c		IN A	192.0.2.11
+`
+
+// loadZone ...
+func loadZone(t *testing.T, data string) *dnsresolver.Zone {
+	t.Helper()
+	z, err := dnsresolver.LoadZone(strings.NewReader(data), _testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// zoneResolver answers from z only, the upstream is unreachable
+func zoneResolver(z *dnsresolver.Zone) *dnsresolver.Resolver {
+	return &dnsresolver.Resolver{Server: _deadServer, NoUDP: true, Sources: []dnsresolver.Source{z}}
+}
+
+func TestZoneLookupMsg(t *testing.T) {
+	z := loadZone(t, _localZone)
+	for _, tc := range []struct {
+		name    string
+		rType   uint16
+		rcode   int
+		answers int
+	}{
+		{"www.example.com", dns.TypeA, dns.RcodeSuccess, 1},
+		{"WWW.Example.COM.", dns.TypeA, dns.RcodeSuccess, 1},
+		{"www.example.com", dns.TypeAAAA, dns.RcodeSuccess, 0}, // NODATA
+		{"alias.example.com", dns.TypeA, dns.RcodeSuccess, 2},
+		{"alias.example.com", dns.TypeCNAME, dns.RcodeSuccess, 1},
+		{"dangling.example.com", dns.TypeA, dns.RcodeNameError, 1},
+		{"external.example.com", dns.TypeA, dns.RcodeSuccess, 1},
+		{"nx.example.com", dns.TypeA, dns.RcodeNameError, 0},
+		{"x.w.example.com", dns.TypeTXT, dns.RcodeSuccess, 1}, // wildcard
+		{"x.y.w.example.com", dns.TypeTXT, dns.RcodeSuccess, 1},
+		{"x.w.example.com", dns.TypeA, dns.RcodeSuccess, 0},
+		{"w.example.com", dns.TypeTXT, dns.RcodeSuccess, 0}, // empty non-terminal above *.w
+		{"example.com", dns.TypeSOA, dns.RcodeSuccess, 1},
+	} {
+		msg, ok := z.LookupMsg(tc.name, tc.rType)
+		if !ok || msg.Rcode != tc.rcode || len(msg.Answer) != tc.answers || !msg.Authoritative {
+			t.Errorf("%s %s: got %v %v, want %s with %d answers", tc.name, dns.TypeToString[tc.rType], msg, ok, dns.RcodeToString[tc.rcode], tc.answers)
+			continue
+		}
+		if tc.answers == 0 && (len(msg.Ns) != 1 || msg.Ns[0].Header().Rrtype != dns.TypeSOA) {
+			t.Errorf("%s %s: negative answer without SOA: %v", tc.name, dns.TypeToString[tc.rType], msg)
+		}
+	}
+	if msg, ok := z.LookupMsg("x.w.example.com", dns.TypeTXT); !ok || msg.Answer[0].Header().Name != "x.w.example.com." {
+		t.Errorf("wildcard owner: got %v", msg)
+	}
+	if _, ok := z.LookupMsg("www.example.org", dns.TypeA); ok {
+		t.Error("out of zone name has to be handed over")
+	}
+	if rrs, ok := z.Lookup("nx.example.com", dns.TypeA); ok {
+		t.Errorf("Lookup of a missing name has to hand over, got %v", rrs)
+	}
+	if rrs, ok := z.Lookup("www.example.com", dns.TypeAAAA); !ok || len(rrs) != 0 {
+		t.Errorf("Lookup NODATA: got %v %v", rrs, ok)
+	}
+}
+
+func TestZoneEmptyNonTerminals(t *testing.T) {
+	z := loadZone(t, _entZone)
+	for _, tc := range []struct {
+		name    string
+		rType   uint16
+		rcode   int
+		answers int
+	}{
+		{"nx.example.com", dns.TypeTXT, dns.RcodeSuccess, 1}, // apex wildcard
+		{"nx.example.com", dns.TypeA, dns.RcodeSuccess, 0},
+		{"b.example.com", dns.TypeTXT, dns.RcodeSuccess, 0},     // empty non-terminal, no wildcard
+		{"x.b.example.com", dns.TypeTXT, dns.RcodeNameError, 0}, // closest encloser b, no *.b
+		{"a.b.example.com", dns.TypeTXT, dns.RcodeSuccess, 0},
+		{"c.example.com", dns.TypeTXT, dns.RcodeSuccess, 0}, // empty non-terminal above *.c
+		{"x.c.example.com", dns.TypeA, dns.RcodeSuccess, 1},
+		{"x.c.example.com", dns.TypeTXT, dns.RcodeSuccess, 0},
+	} {
+		if msg, ok := z.LookupMsg(tc.name, tc.rType); !ok || msg.Rcode != tc.rcode || len(msg.Answer) != tc.answers {
+			t.Errorf("%s %s: got %v %v, want %s with %d answers", tc.name, dns.TypeToString[tc.rType], msg, ok, dns.RcodeToString[tc.rcode], tc.answers)
+		}
+	}
+}
+
+func TestZoneResolver(t *testing.T) {
+	r := zoneResolver(loadZone(t, _localZone))
+	if addrs, err := r.LookupAddr("alias.example.com", dns.TypeA); err != nil || len(addrs) != 1 || addrs[0].String() != "192.0.2.80" {
+		t.Errorf("alias: got %v %v", addrs, err)
+	}
+	_, err := r.LookupAddr("nx.example.com", dns.TypeA)
+	var rcodeErr *dnsresolver.RcodeError
+	if !errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError || rcodeErr.Proto != "local" {
+		t.Errorf("missing name: got %v, want NXDOMAIN", err)
+	}
+	for res, err := range r.ExchangeSeq("x.w.example.com", []uint16{dns.TypeTXT}) {
+		if err != nil || len(res.Answer) != 1 || res.Proto != "local" {
+			t.Errorf("TXT: got %+v %v, want the wildcard", res, err)
+		}
+	}
+	for res, err := range r.ExchangeSeq("nx.example.com", []uint16{dns.TypeA, dns.TypeTXT}) {
+		if !errors.As(err, &rcodeErr) || res.Msg.Rcode != dns.RcodeNameError || len(res.Msg.Ns) != 1 {
+			t.Errorf("%s: got %v %v, want NXDOMAIN with SOA", dns.TypeToString[res.Type], res.Msg, err)
+		}
+	}
+}
+
+func TestZoneNXDOMAINHandOver(t *testing.T) {
+	s := newServer(t, _testZone)
+	r := s.Resolver()
+	r.Sources = []dnsresolver.Source{loadZone(t, "$ORIGIN example.com.\nlocal 300 IN A 192.0.2.99\n"), dnsresolver.SourceDNS}
+	if addrs, err := r.LookupAddr("local.example.com", dns.TypeA); err != nil || addrs[0].String() != "192.0.2.99" {
+		t.Errorf("local: got %v %v", addrs, err)
+	}
+	if addrs, err := r.LookupAddr("www.example.com", dns.TypeA); err != nil || addrs[0].String() != "192.0.2.80" {
+		t.Errorf("upstream after local NXDOMAIN: got %v %v", addrs, err)
+	}
+}
+
+func TestZoneSnapshotReplay(t *testing.T) {
+	s := newServer(t, _testZone)
+	types := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypeMX}
+	answer, err := s.Resolver().Exchange("alias.example.com", false, true, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs := answer.Records[dns.TypeA]; len(rrs) != 2 || rrs[0].Header().Rrtype != dns.TypeCNAME {
+		t.Fatalf("records have to include the cname chain: %v", rrs)
+	}
+	var file strings.Builder
+	if err = answer.WriteZone(&file, _testOrigin); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	if err = os.WriteFile(path, []byte(file.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	z, err := dnsresolver.ZoneFile(path, _testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(z.Records()); got != 4 { // CNAME, A, AAAA, TXT
+		t.Errorf("snapshot records: got %d, want 4\n%s", got, file.String())
+	}
+	replay, err := zoneResolver(z).Exchange("alias.example.com", false, true, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rType := range types {
+		if replay.Summary[rType] != answer.Summary[rType] {
+			t.Errorf("%s: replay %q, want %q", dns.TypeToString[rType], replay.Summary[rType], answer.Summary[rType])
+		}
+	}
+}
+
+func TestWriteZone(t *testing.T) {
+	z := loadZone(t, _localZone)
+	var out strings.Builder
+	if err := dnsresolver.WriteZone(&out, "example.com", append(z.Records(), z.Records()...)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if lines[0] != "$ORIGIN example.com." || lines[1] != "$TTL 300" || !strings.Contains(lines[2], "\tSOA\t") {
+		t.Errorf("header: got %q", lines[:3])
+	}
+	if len(lines) != 2+len(z.Records()) {
+		t.Errorf("duplicates have to be removed: got %d lines\n%s", len(lines), out.String())
+	}
+	again := loadZone(t, out.String())
+	if len(again.Records()) != len(z.Records()) {
+		t.Errorf("reloaded: got %d records, want %d", len(again.Records()), len(z.Records()))
+	}
+	for _, data := range []string{"www.example.org. 300 IN A 192.0.2.1\n", "www 300 IN A bogus\n"} {
+		if _, err := dnsresolver.LoadZone(strings.NewReader(data), _testOrigin); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+	if _, err := dnsresolver.ZoneFile(filepath.Join(t.TempDir(), "missing"), _testOrigin); err == nil {
+		t.Error("missing file: expected error")
+	}
+}